@@ -9,6 +9,7 @@ import (
 	"net/http"
 	_ "net/http/pprof"
 	"os"
+	"os/signal"
 	"path"
 	"path/filepath"
 	"runtime"
@@ -31,7 +32,9 @@ import (
 	"github.com/IvanX77/turbowings/environment"
 	"github.com/IvanX77/turbowings/internal/cron"
 	"github.com/IvanX77/turbowings/internal/database"
+	"github.com/IvanX77/turbowings/internal/metrics"
 	"github.com/IvanX77/turbowings/loggers/cli"
+	"github.com/IvanX77/turbowings/parser"
 	"github.com/IvanX77/turbowings/remote"
 	"github.com/IvanX77/turbowings/router"
 	"github.com/IvanX77/turbowings/server"
@@ -90,6 +93,7 @@ func init() {
 	rootCommand.AddCommand(configureCmd)
 	rootCommand.AddCommand(newDiagnosticsCommand())
 	rootCommand.AddCommand(newSelfupdateCommand())
+	rootCommand.AddCommand(newValidateCommand())
 }
 
 func isDockerSnap() bool {
@@ -163,6 +167,18 @@ func rootCmdRun(cmd *cobra.Command, _ []string) {
 		return
 	}
 
+	ctx, cancel := context.WithTimeout(cmd.Context(), time.Second*30)
+	err := environment.EnsureDockerReachable(ctx)
+	cancel()
+	if err != nil {
+		log.WithField("error", err).Fatal("docker is not reachable")
+		return
+	}
+
+	// Report the outcome of every configuration file that is parsed so that it
+	// is exported on the metrics endpoint.
+	parser.SetMetrics(metrics.Parser)
+
 	manager, err := server.NewManager(cmd.Context(), pclient)
 	if err != nil {
 		log.WithField("error", err).Fatal("failed to load server configurations")
@@ -210,6 +226,8 @@ func rootCmdRun(cmd *cobra.Command, _ []string) {
 		}
 	}()
 
+	go reloadConfigurationFilesOnSignal(cmd.Context(), manager)
+
 	// Create a new workerpool that limits us to 4 servers being bootstrapped at a time
 	// on TurboWings. This allows us to ensure the environment exists, write configurations,
 	// and reboot processes without causing a slow-down due to sequential booting.
@@ -410,6 +428,31 @@ func rootCmdRun(cmd *cobra.Command, _ []string) {
 	}
 }
 
+// reloadConfigurationFilesOnSignal updates the configuration files for all the
+// running servers whenever the process receives a SIGHUP, until the context is
+// canceled. The network interface for the node is refreshed first, so that any
+// changes made to the Docker network are picked up by the configuration files.
+func reloadConfigurationFilesOnSignal(ctx context.Context, manager *server.Manager) {
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, syscall.SIGHUP)
+	defer signal.Stop(c)
+
+	for {
+		select {
+		case <-c:
+			log.Info("received SIGHUP, reloading configuration files for running servers")
+			if err := environment.RefreshNetworkInterface(ctx); err != nil {
+				log.WithField("error", err).Error("failed to refresh docker network interface")
+			}
+			for id, err := range manager.ReloadConfigurationFiles() {
+				log.WithField("server", id).WithField("error", err).Error("failed to reload configuration files for server")
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
 // Reads the configuration from the disk and then sets up the global singleton
 // with all the configuration values.
 func initConfig() {
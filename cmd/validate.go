@@ -0,0 +1,70 @@
+package cmd
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/spf13/cobra"
+
+	"github.com/IvanX77/turbowings/config"
+	"github.com/IvanX77/turbowings/loggers/cli"
+	"github.com/IvanX77/turbowings/remote"
+	"github.com/IvanX77/turbowings/server"
+)
+
+func newValidateCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:   "validate",
+		Short: "Check that the configuration files for every server can be parsed, without changing them.",
+		PreRun: func(cmd *cobra.Command, args []string) {
+			initConfig()
+			log.SetHandler(cli.Default)
+		},
+		Run: validateCmdRun,
+	}
+}
+
+// validateCmdRun loads all the servers for this node from the Panel and performs
+// a dry run of their configuration file parsers. This does not boot any of the
+// servers or write to their files, so it is safe to run on a live node.
+func validateCmdRun(cmd *cobra.Command, _ []string) {
+	t := config.Get().Token
+	pclient := remote.New(
+		config.Get().PanelLocation,
+		remote.WithCredentials(t.ID, t.Token),
+		remote.WithHttpClient(&http.Client{
+			Timeout: time.Second * time.Duration(config.Get().RemoteQuery.Timeout),
+		}),
+	)
+
+	manager, err := server.NewManager(cmd.Context(), pclient)
+	if err != nil {
+		log.WithField("error", err).Fatal("failed to load server configurations")
+		return
+	}
+
+	results := manager.ValidateConfigurationFiles()
+	if len(results) == 0 {
+		fmt.Printf("All configuration files for %d servers were parsed successfully.\n", manager.Len())
+		return
+	}
+
+	ids := make([]string, 0, len(results))
+	for id := range results {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	for _, id := range ids {
+		fmt.Printf("%s:\n", id)
+		for _, e := range results[id] {
+			fmt.Printf("  %s: %s\n", e.File, e.Err)
+		}
+	}
+	fmt.Printf("\n%d of %d servers have configuration files that could not be parsed.\n", len(results), manager.Len())
+	os.Exit(1)
+}
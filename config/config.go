@@ -204,6 +204,16 @@ type SystemConfiguration struct {
 	// frequently modifying a servers' files.
 	CheckPermissionsOnBoot bool `default:"true" yaml:"check_permissions_on_boot"`
 
+	// If set to true, configuration files for a server are only parsed when booting if
+	// the file or the replacements for it have changed since the last time it was parsed.
+	// A fingerprint of each file is stored in a marker file in the root of the server.
+	SkipUnchangedConfigurationFiles bool `default:"false" yaml:"skip_unchanged_configuration_files"`
+
+	// If set to true, configuration files for a server are updated as a single operation
+	// when booting. If any file fails to update, every file is restored to the contents
+	// it had beforehand so that the server is never left with only some files updated.
+	AtomicConfigurationFiles bool `default:"false" yaml:"atomic_configuration_files"`
+
 	// If set to false TurboWings will not attempt to write a log rotate configuration to the disk
 	// when it boots and one is not detected.
 	EnableLogRotate bool `default:"true" yaml:"enable_log_rotate"`
@@ -223,6 +233,12 @@ type SystemConfiguration struct {
 	Transfers Transfers `yaml:"transfers"`
 
 	OpenatMode string `default:"auto" yaml:"openat_mode"`
+
+	// MaxConfigFileSize is the maximum size in MiB of a server configuration file that
+	// will be processed by the configuration file parser. Files larger than this are
+	// skipped to avoid consuming large amounts of memory when booting a server. Set
+	// to 0 to disable this limit.
+	MaxConfigFileSize int64 `default:"50" yaml:"max_config_file_size"`
 }
 
 type CrashDetection struct {
@@ -262,6 +278,94 @@ type Backups struct {
 
 	// RemoveBackupsOnServerDelete deletes backups associated with a server when the server is deleted
 	RemoveBackupsOnServerDelete bool `default:"true" yaml:"remove_backups_on_server_delete"`
+
+	// Timeout is the maximum number of minutes that generating a backup may take before
+	// it is cancelled and marked as failed. This prevents a stuck backup, such as one
+	// reading from a hanging network filesystem, from running forever. If the value is
+	// less than 1 backups do not time out.
+	//
+	// Defaults to 0 (no timeout)
+	Timeout int `default:"0" yaml:"timeout"`
+
+	// MaxConcurrent is the maximum number of backups that can be generated at once
+	// across every server on the node. Any additional backups wait for a running one
+	// to finish before they are started. If the value is less than 1 there is no limit.
+	//
+	// Defaults to 0 (no limit)
+	MaxConcurrent int `default:"0" yaml:"max_concurrent"`
+
+	// MaxIgnoreFileSize is the maximum size in KiB of an ignore file, such as
+	// .pelicanignore, in the root of a server that will be read when determining which
+	// files to exclude from a backup. Larger ignore files are skipped entirely. If the
+	// value is less than 1 there is no limit. This can be overridden for individual
+	// servers in their backup configuration.
+	//
+	// Defaults to 32 (32KiB)
+	MaxIgnoreFileSize int64 `default:"32" yaml:"max_ignore_file_size"`
+
+	// Symlinks determines how symbolic links within a server are handled when a
+	// backup is generated.
+	//
+	// "skip" -> symbolic links are excluded from the backup
+	// "store" -> symbolic links are stored as links, their targets are never read
+	// "follow" -> the file a symbolic link points to is stored in place of the link,
+	//             as long as it is a file within the root of the server. Links that
+	//             point elsewhere are skipped.
+	//
+	// Defaults to "skip", any unknown value is treated as "skip"
+	Symlinks string `default:"skip" yaml:"symlinks"`
+
+	// FilenameTemplate is a Go template used to name local backups on the disk, the
+	// ".tar.gz" extension is added automatically. The template has access to the
+	// .Name and .ServerUuid of the server, the .Uuid of the backup, and a .Timestamp
+	// of when it was created. For example:
+	//
+	//   {{.Name}}_{{.Timestamp}}_{{.Uuid}}
+	//
+	// Characters that are not safe to use in a filename are replaced. Backups are
+	// always located using their UUID, which is mapped to the templated name.
+	//
+	// Defaults to "" (backups are named using their UUID)
+	FilenameTemplate string `default:"" yaml:"filename_template"`
+
+	// RestoreOwnership determines the owner given to files when a backup is restored,
+	// as well as to any directories that need to be created for them.
+	//
+	// "user" -> files are owned by the configured container user
+	// "preserve" -> files are owned by the uid and gid recorded in the backup archive,
+	//               except for files archived as being owned by root, which are given
+	//               to the configured container user instead. Archives that do not
+	//               record an owner are treated the same as "user".
+	//
+	// Defaults to "user", any unknown value is treated as "user"
+	RestoreOwnership string `default:"user" yaml:"restore_ownership"`
+
+	// VerifyOnBoot causes the checksums of all local backups to be verified once when
+	// TurboWings boots, this is useful after a node has experienced disk issues.
+	//
+	// Defaults to false
+	VerifyOnBoot bool `default:"false" yaml:"verify_on_boot"`
+
+	// VerifyInterval is the number of minutes between each verification of the checksums
+	// of all local backups. If the value is less than 1 backups are not periodically verified.
+	//
+	// Defaults to 0 (disabled)
+	VerifyInterval int `default:"0" yaml:"verify_interval"`
+
+	// VerifyReadLimit imposes a Disk I/O read limit when verifying local backups so that
+	// the verification does not starve running servers of disk access.
+	//
+	// If the value is less than 1, the read speed is unlimited,
+	// if the value is greater than 0, the read speed is the value in MiB/s.
+	//
+	// Defaults to 50
+	VerifyReadLimit int `default:"50" yaml:"verify_read_limit"`
+
+	// ReportVerifyFailures marks any local backups that fail verification as unsuccessful
+	// on the Panel, in addition to logging the failure.
+	//
+	// Defaults to false
+	ReportVerifyFailures bool `default:"false" yaml:"report_verify_failures"`
 }
 
 type Transfers struct {
@@ -333,6 +437,14 @@ type Configuration struct {
 	// This is required to have the "Server Mounts" feature work properly.
 	AllowedMounts []string `json:"-" yaml:"allowed_mounts"`
 
+	// DeniedMounts is a list of sensitive host-system paths that can never be mounted
+	// into a server container, even if they fall within one of the allowed mounts. A
+	// mount is rejected if its source is one of these paths, is nested within one, or
+	// contains one, with the exception of "/" which only rejects mounting the root
+	// itself. The directory of the configuration file, and the root, data and backup
+	// directories of TurboWings are always denied in addition to these.
+	DeniedMounts []string `default:"[\"/\", \"/etc\", \"/proc\", \"/sys\", \"/dev\", \"/boot\", \"/root\", \"/var/run/docker.sock\", \"/run/docker.sock\", \"/var/lib/docker\"]" json:"-" yaml:"denied_mounts"`
+
 	SearchRecursion SearchRecursion `yaml:"Search"`
 	// BlockBaseDirMount indicates whether mounting to /home/container is blocked.
 	// If true, mounting to /home/container is blocked.
@@ -378,6 +490,12 @@ func NewAtPath(path string) (*Configuration, error) {
 	return &c, nil
 }
 
+// Path returns the location of the file that the configuration was loaded from,
+// or an empty string if it was not loaded from a file.
+func (c *Configuration) Path() string {
+	return c.path
+}
+
 // Set the global configuration instance. This is a blocking operation such that
 // anything trying to set a different configuration value, or read the configuration
 // will be paused until it is complete.
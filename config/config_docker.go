@@ -9,6 +9,27 @@ import (
 	"github.com/goccy/go-json"
 )
 
+// The modes that control which IP stacks the ports for server containers are
+// published on.
+const (
+	// BindingModeV4Only publishes ports over IPv4 only.
+	BindingModeV4Only = "v4only"
+	// BindingModeV6Only publishes ports over IPv6 only.
+	BindingModeV6Only = "v6only"
+	// BindingModeDual publishes ports over both IPv4 and IPv6.
+	BindingModeDual = "dual"
+
+	// EnvironmentTypesWarn logs a warning and passes an empty value for environment
+	// variables of a type that cannot be converted into a string.
+	EnvironmentTypesWarn = "warn"
+	// EnvironmentTypesError prevents a server from starting while any of its
+	// environment variables are of a type that cannot be converted into a string.
+	EnvironmentTypesError = "error"
+	// EnvironmentTypesJson passes environment variables of a type that cannot be
+	// converted into a string as their JSON encoding.
+	EnvironmentTypesJson = "json"
+)
+
 type dockerNetworkInterfaces struct {
 	V4 struct {
 		Subnet  string `default:"172.18.0.0/16"`
@@ -40,6 +61,32 @@ type DockerNetworkConfiguration struct {
 	EnableICC  bool                    `default:"true" yaml:"enable_icc"`
 	NetworkMTU int64                   `default:"1500" yaml:"network_mtu"`
 	Interfaces dockerNetworkInterfaces `yaml:"interfaces"`
+
+	// The name of the bridge interface created on the host for the network. This must
+	// be changed if another network on the host is already using the default name.
+	BridgeName string `default:"pelican0" json:"bridge_name" yaml:"bridge_name"`
+
+	// Whether outgoing traffic from containers is masqueraded behind the host's address.
+	EnableIPMasquerade bool `default:"true" json:"enable_ip_masquerade" yaml:"enable_ip_masquerade"`
+
+	// The default host address that container ports are published on. By default ports
+	// are published on every interface, on multi-homed hosts this can be set to the
+	// address of a specific interface to restrict where container ports are reachable.
+	HostBindingIPv4 string `default:"0.0.0.0" json:"host_binding_ipv4" yaml:"host_binding_ipv4"`
+
+	// BindingMode controls which IP stacks the ports for server containers are published
+	// on when they are allocated to every address on the host. This can be "v4only",
+	// "v6only" or "dual", publishing over IPv6 requires IPv6 to be enabled for the network.
+	BindingMode string `default:"v4only" json:"binding_mode" yaml:"binding_mode"`
+
+	// RepairDrift controls what happens when the existing network does not match the
+	// settings defined above. By default, the differences are only logged. If enabled,
+	// the network will be removed and re-created as long as no containers are attached.
+	RepairDrift bool `default:"false" json:"repair_drift" yaml:"repair_drift"`
+
+	// Timeout is the number of seconds to wait for Docker to inspect and create the
+	// network when turbowings boots before giving up. Set to 0 to wait indefinitely.
+	Timeout int `default:"30" json:"timeout" yaml:"timeout"`
 }
 
 // DockerConfiguration defines the docker configuration used by the daemon when
@@ -79,8 +126,32 @@ type DockerConfiguration struct {
 	// software such as the JVM not staying below the maximum memory limit.
 	Overhead Overhead `json:"overhead" yaml:"overhead"`
 
+	// EnvFile is the path to a file of additional environment variables to pass to
+	// server containers, in the same format as Docker's --env-file option. This allows
+	// secrets to be provided to a server without them being stored by the Panel. Any
+	// ${SERVER_UUID} in the path is replaced with the UUID of the server, so that each
+	// server can be given its own file. Variables in the file take precedence over the
+	// ones of the same name from the Panel.
+	//
+	// The file cannot be within any of the denied mounts.
+	EnvFile string `default:"" json:"env_file" yaml:"env_file"`
+
 	UsePerformantInspect bool `default:"true" json:"use_performant_inspect" yaml:"use_performant_inspect"`
 
+	// ForwardOomEvents watches the Docker events for a running container and notifies the
+	// Panel whenever a process within it is killed by the kernel OOM killer. This only has
+	// an effect for servers that have the OOM killer enabled.
+	ForwardOomEvents bool `default:"true" json:"forward_oom_events" yaml:"forward_oom_events"`
+
+	// UnknownEnvironmentTypes controls how environment variables sent by the Panel as
+	// something other than a string, number, or boolean are handled. This should be
+	// one of "warn", "error", or "json", see the EnvironmentTypes constants.
+	UnknownEnvironmentTypes string `default:"warn" json:"unknown_environment_types" yaml:"unknown_environment_types"`
+
+	// Labels is a set of labels applied to every container created by turbowings. Any labels
+	// provided by the Panel for a specific server take precedence over these.
+	Labels map[string]string `json:"labels" yaml:"labels"`
+
 	// Sets the user namespace mode for the container when user namespace remapping option is
 	// enabled.
 	//
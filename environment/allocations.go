@@ -64,10 +64,28 @@ func (a *Allocations) Bindings() nat.PortMap {
 // Returns the bindings for the server in a way that is supported correctly by Docker. This replaces
 // any reference to 127.0.0.1 with the IP of the pelican0 network interface which will allow the
 // server to operate on a local address while still being accessible by other containers.
+//
+// Ports allocated to every IPv4 address on the host are published over IPv6 as well, or instead,
+// depending on the binding mode of the network.
 func (a *Allocations) DockerBindings() nat.PortMap {
 	iface := config.Get().Docker.Network.Interface
 
 	out := a.Bindings()
+	if mode := config.Get().Docker.Network.BindingMode; mode == config.BindingModeV6Only || mode == config.BindingModeDual {
+		for p, binds := range out {
+			for i, alloc := range binds {
+				if alloc.HostIP != "0.0.0.0" {
+					continue
+				}
+				v6 := nat.PortBinding{HostIP: "::", HostPort: alloc.HostPort}
+				if mode == config.BindingModeV6Only {
+					out[p][i] = v6
+				} else {
+					out[p] = append(out[p], v6)
+				}
+			}
+		}
+	}
 	// Loop over all the bindings for this container, and convert any that reference 127.0.0.1
 	// to use the pelican0 network interface IP, as that is the true local for what people are
 	// trying to do when creating servers.
@@ -0,0 +1,60 @@
+package environment
+
+import (
+	"testing"
+
+	"github.com/docker/go-connections/nat"
+	. "github.com/franela/goblin"
+
+	"github.com/IvanX77/turbowings/config"
+)
+
+func TestAllocations_DockerBindings(t *testing.T) {
+	g := Goblin(t)
+
+	g.Describe("DockerBindings", func() {
+		var a Allocations
+
+		setMode := func(mode string) {
+			c := &config.Configuration{AuthenticationToken: "abc"}
+			c.Docker.Network.BindingMode = mode
+			config.Set(c)
+		}
+
+		g.BeforeEach(func() {
+			a = Allocations{Mappings: map[string][]int{
+				"0.0.0.0":  {25565},
+				"10.0.0.5": {25566},
+			}}
+		})
+
+		g.It("publishes ports over IPv4 only by default", func() {
+			setMode(config.BindingModeV4Only)
+			out := a.DockerBindings()
+			g.Assert(out[nat.Port("25565/tcp")]).Equal([]nat.PortBinding{{HostIP: "0.0.0.0", HostPort: "25565"}})
+		})
+
+		g.It("publishes ports over IPv6 only", func() {
+			setMode(config.BindingModeV6Only)
+			out := a.DockerBindings()
+			g.Assert(out[nat.Port("25565/udp")]).Equal([]nat.PortBinding{{HostIP: "::", HostPort: "25565"}})
+			g.Assert(out[nat.Port("25566/tcp")]).Equal([]nat.PortBinding{{HostIP: "10.0.0.5", HostPort: "25566"}})
+		})
+
+		g.It("publishes ports over both stacks", func() {
+			setMode(config.BindingModeDual)
+			out := a.DockerBindings()
+			g.Assert(out[nat.Port("25565/tcp")]).Equal([]nat.PortBinding{{HostIP: "0.0.0.0", HostPort: "25565"}, {HostIP: "::", HostPort: "25565"}})
+			g.Assert(out[nat.Port("25566/tcp")]).Equal([]nat.PortBinding{{HostIP: "10.0.0.5", HostPort: "25566"}})
+		})
+	})
+
+	g.Describe("checkBindingMode", func() {
+		g.It("requires IPv6 to publish ports over IPv6", func() {
+			g.Assert(checkBindingMode(config.BindingModeV4Only, false)).IsNil()
+			g.Assert(checkBindingMode(config.BindingModeDual, true)).IsNil()
+			g.Assert(checkBindingMode(config.BindingModeV6Only, false) == nil).IsFalse()
+			g.Assert(checkBindingMode("v5only", true) == nil).IsFalse()
+		})
+	})
+}
@@ -9,6 +9,11 @@ type Settings struct {
 	Allocations Allocations
 	Limits      Limits
 	Labels      map[string]string
+	// Entrypoint and Command override the entrypoint and command of the image the
+	// environment is created from, the defaults from the image are used if these
+	// are empty.
+	Entrypoint []string
+	Command    []string
 }
 
 // Defines the actual configuration struct for the environment with all of the settings
@@ -77,6 +82,24 @@ func (c *Configuration) Labels() map[string]string {
 	return c.settings.Labels
 }
 
+// Entrypoint returns the entrypoint that overrides the one defined by the image,
+// or nil if the entrypoint of the image should be used.
+func (c *Configuration) Entrypoint() []string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.settings.Entrypoint
+}
+
+// Command returns the command that overrides the one defined by the image, or
+// nil if the command of the image should be used.
+func (c *Configuration) Command() []string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.settings.Command
+}
+
 // Returns the environment variables associated with this instance.
 func (c *Configuration) EnvironmentVariables() []string {
 	c.mu.RLock()
@@ -2,34 +2,134 @@ package environment
 
 import (
 	"context"
+	"fmt"
+	"net"
+	"os"
 	"strconv"
+	"strings"
 	"sync"
+	"time"
 
 	"emperror.dev/errors"
 	"github.com/apex/log"
+	"github.com/docker/docker/api"
 	"github.com/docker/docker/api/types/network"
+	"github.com/docker/docker/api/types/versions"
 	"github.com/docker/docker/client"
 
 	"github.com/IvanX77/turbowings/config"
 )
 
+// dockerHealthCheckInterval is the minimum amount of time between health checks
+// of the shared Docker client.
+const dockerHealthCheckInterval = time.Second * 30
+
 var (
-	_conce  sync.Once
-	_client *client.Client
+	_cmu         sync.Mutex
+	_client      *client.Client
+	_lastChecked time.Time
+	_checking    bool
 )
 
 // Docker returns a docker client to be used throughout the codebase. Once a
 // client has been created it will be returned for all subsequent calls to this
 // function.
+//
+// The client is periodically pinged in the background when requested, and if the
+// Docker daemon can no longer be reached over it (for example, because the daemon
+// was restarted) a new client is created in its place. Callers are never blocked
+// by the health check, they continue to receive the existing client until it has
+// been replaced.
 func Docker() (*client.Client, error) {
-	var err error
-	_conce.Do(func() {
-		_client, err = client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
-	})
-	return _client, errors.Wrap(err, "environment/docker: could not create client")
+	_cmu.Lock()
+	defer _cmu.Unlock()
+
+	if _client == nil {
+		cli, err := newDockerClient()
+		if err != nil {
+			return nil, err
+		}
+		_client = cli
+		_lastChecked = time.Now()
+		return _client, nil
+	}
+
+	if !_checking && time.Since(_lastChecked) >= dockerHealthCheckInterval {
+		_checking = true
+		go checkDockerClient(_client)
+	}
+	return _client, nil
+}
+
+// newDockerClient creates a new Docker client using the environment.
+func newDockerClient() (*client.Client, error) {
+	cli, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
+	if err != nil {
+		return nil, errors.Wrap(err, "environment/docker: could not create client")
+	}
+	return cli, nil
+}
+
+// checkDockerClient pings the Docker daemon over the client, and replaces the
+// shared client with a new one if the daemon cannot be reached over it.
+func checkDockerClient(cli *client.Client) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	var replacement *client.Client
+	if _, err := cli.Ping(ctx); err != nil {
+		log.WithField("error", err).Warn("docker client failed health check, recreating client")
+		if replacement, err = newDockerClient(); err != nil {
+			log.WithField("error", err).Error("failed to recreate docker client")
+		}
+	}
+
+	_cmu.Lock()
+	defer _cmu.Unlock()
+	_checking = false
+	_lastChecked = time.Now()
+	// The replaced client is not closed since running environments may still be
+	// streaming over it, it is released once nothing holds a reference to it.
+	if replacement != nil && _client == cli {
+		_client = replacement
+	}
+}
+
+// EnsureDockerReachable creates the Docker client and pings the daemon, returning
+// a descriptive error if the daemon cannot be used. This should be called when
+// turbowings boots so that it fails with a clear message, rather than with a far
+// less obvious error the first time a server is started.
+func EnsureDockerReachable(ctx context.Context) error {
+	cli, err := Docker()
+	if err != nil {
+		return err
+	}
+
+	ping, err := cli.Ping(ctx)
+	if err != nil {
+		switch {
+		case errors.Is(err, os.ErrPermission) || strings.Contains(strings.ToLower(err.Error()), "permission denied"):
+			return errors.Wrapf(err, "environment/docker: permission denied while connecting to the docker daemon at %s, ensure turbowings is running as a user with access to the docker socket", cli.DaemonHost())
+		case client.IsErrConnectionFailed(err):
+			return errors.Wrapf(err, "environment/docker: cannot connect to the docker daemon at %s, ensure the docker daemon is running", cli.DaemonHost())
+		case errors.Is(err, context.DeadlineExceeded):
+			return errors.Wrapf(err, "environment/docker: timed out waiting for the docker daemon at %s to respond", cli.DaemonHost())
+		}
+		return errors.Wrapf(err, "environment/docker: failed to ping the docker daemon at %s", cli.DaemonHost())
+	}
+
+	if ping.APIVersion != "" && versions.LessThan(ping.APIVersion, api.MinSupportedAPIVersion) {
+		return errors.Errorf("environment/docker: the docker daemon API version %s is not supported, version %s or newer is required", ping.APIVersion, api.MinSupportedAPIVersion)
+	}
+
+	return nil
 }
 
 // ConfigureDocker configures the required network for the docker environment.
+//
+// The calls made to Docker are bounded by the configured network timeout so that
+// an unresponsive Docker daemon causes turbowings to fail to boot, rather than
+// hanging indefinitely.
 func ConfigureDocker(ctx context.Context) error {
 	// Ensure the required docker network exists on the system.
 	cli, err := Docker()
@@ -38,64 +138,225 @@ func ConfigureDocker(ctx context.Context) error {
 	}
 
 	nw := config.Get().Docker.Network
+	if nw.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, time.Duration(nw.Timeout)*time.Second)
+		defer cancel()
+	}
+
+	if err := configureDockerNetwork(ctx, cli, nw); err != nil {
+		if errors.Is(err, context.DeadlineExceeded) || errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return errors.Errorf("environment/docker: timed out after %ds waiting for docker to configure the \"%s\" network, ensure the docker daemon is running and responsive", nw.Timeout, nw.Name)
+		}
+		return err
+	}
+	return nil
+}
+
+// configureDockerNetwork ensures that the network for the docker environment, and
+// any additional networks, exist on the system.
+func configureDockerNetwork(ctx context.Context, cli *client.Client, nw config.DockerNetworkConfiguration) error {
 	resource, err := cli.NetworkInspect(ctx, nw.Name, network.InspectOptions{})
 	if err != nil {
 		if !client.IsErrNotFound(err) {
 			return err
 		}
 
-		log.Info("creating missing pelican0 interface, this could take a few seconds...")
+		log.WithField("bridge", nw.BridgeName).Info("creating missing network interface, this could take a few seconds...")
 		if err := createDockerNetwork(ctx, cli); err != nil {
 			return err
 		}
+	} else if drift := networkDrift(nw, resource); len(drift) > 0 {
+		log.WithField("network", nw.Name).WithField("drift", drift).Warn("existing docker network does not match the configured network settings")
+		if nw.RepairDrift {
+			if len(resource.Containers) > 0 {
+				log.WithField("network", nw.Name).WithField("containers", len(resource.Containers)).Warn("unable to recreate docker network while containers are still attached to it")
+			} else {
+				log.WithField("network", nw.Name).Info("recreating misconfigured docker network, this could take a few seconds...")
+				if err := cli.NetworkRemove(ctx, resource.ID); err != nil {
+					return errors.Wrap(err, "environment/docker: failed to remove misconfigured network")
+				}
+				if err := createDockerNetwork(ctx, cli); err != nil {
+					return err
+				}
+				if resource, err = cli.NetworkInspect(ctx, nw.Name, network.InspectOptions{}); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	// Ports can only be published over IPv6 if the network has IPv6 enabled, which
+	// also requires the daemon to support it for the network to have been created.
+	ipv6 := nw.IPv6
+	if resource.ID != "" {
+		ipv6 = resource.EnableIPv6
+	}
+	if err := checkBindingMode(nw.BindingMode, ipv6); err != nil {
+		return err
 	}
 
 	config.Update(func(c *config.Configuration) {
-		c.Docker.Network.Driver = resource.Driver
-		switch c.Docker.Network.Driver {
-		case "host":
-			c.Docker.Network.Interface = "127.0.0.1"
-			c.Docker.Network.ISPN = false
-		case "overlay":
-			fallthrough
-		case "weavemesh":
-			c.Docker.Network.Interface = ""
-			c.Docker.Network.ISPN = true
-		default:
-			c.Docker.Network.ISPN = false
-		}
+		applyNetwork(c, resource)
+	})
+	return nil
+}
+
+// RefreshNetworkInterface inspects the docker network again and updates the
+// cached network interface for the node. This allows changes made to the network
+// while turbowings is running, such as the gateway being reassigned, to be picked
+// up without needing to restart. This is done whenever the process receives a
+// SIGHUP.
+func RefreshNetworkInterface(ctx context.Context) error {
+	cli, err := Docker()
+	if err != nil {
+		return err
+	}
+	name := config.Get().Docker.Network.Name
+	resource, err := cli.NetworkInspect(ctx, name, network.InspectOptions{})
+	if err != nil {
+		return errors.Wrapf(err, "environment/docker: failed to inspect \"%s\" network", name)
+	}
+	config.Update(func(c *config.Configuration) {
+		applyNetwork(c, resource)
 	})
 	return nil
 }
 
+// applyNetwork updates the network driver and interface in the configuration to
+// match the network that exists within Docker.
+func applyNetwork(c *config.Configuration, resource network.Inspect) {
+	c.Docker.Network.Driver = resource.Driver
+	switch c.Docker.Network.Driver {
+	case "host":
+		c.Docker.Network.Interface = "127.0.0.1"
+		c.Docker.Network.ISPN = false
+	case "overlay":
+		fallthrough
+	case "weavemesh":
+		c.Docker.Network.Interface = ""
+		c.Docker.Network.ISPN = true
+	default:
+		c.Docker.Network.ISPN = false
+		// Use the IPv4 gateway of the network as the interface, as this is the
+		// address containers are able to reach the host on.
+		for _, cfg := range resource.IPAM.Config {
+			if ip := net.ParseIP(cfg.Gateway); ip != nil && ip.To4() != nil {
+				c.Docker.Network.Interface = cfg.Gateway
+				break
+			}
+		}
+	}
+}
+
+// networkDrift compares the configured network settings against the network
+// that currently exists within Docker and returns a human-readable description
+// of each difference found.
+func networkDrift(nw config.DockerNetworkConfiguration, resource network.Inspect) []string {
+	var drift []string
+	if resource.Driver != nw.Driver {
+		drift = append(drift, fmt.Sprintf("driver: expected \"%s\", got \"%s\"", nw.Driver, resource.Driver))
+	}
+	if resource.Internal != nw.IsInternal {
+		drift = append(drift, fmt.Sprintf("internal: expected %t, got %t", nw.IsInternal, resource.Internal))
+	}
+	if resource.EnableIPv6 != nw.IPv6 {
+		drift = append(drift, fmt.Sprintf("ipv6: expected %t, got %t", nw.IPv6, resource.EnableIPv6))
+	}
+	if mtu, ok := resource.Options["com.docker.network.driver.mtu"]; ok && mtu != strconv.FormatInt(nw.NetworkMTU, 10) {
+		drift = append(drift, fmt.Sprintf("mtu: expected %d, got %s", nw.NetworkMTU, mtu))
+	}
+	if ip, ok := resource.Options["com.docker.network.bridge.host_binding_ipv4"]; ok && ip != nw.HostBindingIPv4 {
+		drift = append(drift, fmt.Sprintf("host binding: expected \"%s\", got \"%s\"", nw.HostBindingIPv4, ip))
+	}
+	if name, ok := resource.Options["com.docker.network.bridge.name"]; ok && name != nw.BridgeName {
+		drift = append(drift, fmt.Sprintf("bridge name: expected \"%s\", got \"%s\"", nw.BridgeName, name))
+	}
+
+	// Only bridge networks are created with an address pool by TurboWings, the other
+	// drivers are managed externally.
+	if nw.Driver != "bridge" {
+		return drift
+	}
+
+	subnets := make(map[string]struct{}, len(resource.IPAM.Config))
+	for _, c := range resource.IPAM.Config {
+		subnets[c.Subnet] = struct{}{}
+	}
+	if _, ok := subnets[nw.Interfaces.V4.Subnet]; !ok {
+		drift = append(drift, fmt.Sprintf("subnet: expected \"%s\" to be assigned", nw.Interfaces.V4.Subnet))
+	}
+	if _, ok := subnets[nw.Interfaces.V6.Subnet]; nw.IPv6 && !ok {
+		drift = append(drift, fmt.Sprintf("subnet: expected \"%s\" to be assigned", nw.Interfaces.V6.Subnet))
+	}
+
+	return drift
+}
+
+// checkBindingMode ensures that the binding mode is valid, and that IPv6 is
+// enabled for the network if ports are to be published over IPv6.
+func checkBindingMode(mode string, ipv6 bool) error {
+	switch mode {
+	case "", config.BindingModeV4Only:
+		return nil
+	case config.BindingModeV6Only, config.BindingModeDual:
+		if !ipv6 {
+			return errors.Errorf("environment/docker: binding mode \"%s\" requires IPv6 to be enabled for the docker network", mode)
+		}
+		return nil
+	}
+	return errors.Errorf("environment/docker: binding mode \"%s\" is not valid, expected one of \"%s\", \"%s\" or \"%s\"", mode, config.BindingModeV4Only, config.BindingModeV6Only, config.BindingModeDual)
+}
+
 // Creates a new network on the machine if one does not exist already.
 func createDockerNetwork(ctx context.Context, cli *client.Client) error {
 	nw := config.Get().Docker.Network
-	enableIPv6 := nw.IPv6 // get the value from the config file, todo add some logic to the IPAM interaface for that.
+	enableIPv6 := nw.IPv6
+
+	if err := checkBindingMode(nw.BindingMode, enableIPv6); err != nil {
+		return err
+	}
+
+	// Docker will happily accept an invalid host binding address when creating the network,
+	// and then fail to publish the ports for every container, so check it up front.
+	if ip := net.ParseIP(nw.HostBindingIPv4); ip == nil || ip.To4() == nil {
+		return errors.Errorf("environment/docker: host binding \"%s\" is not a valid IPv4 address", nw.HostBindingIPv4)
+	}
+
+	// Only include the IPv6 address pool if IPv6 is actually enabled, otherwise the network
+	// creation will fail on hosts where the daemon does not have IPv6 support enabled.
+	ipam := []network.IPAMConfig{{
+		Subnet:  nw.Interfaces.V4.Subnet,
+		Gateway: nw.Interfaces.V4.Gateway,
+	}}
+	if enableIPv6 {
+		ipam = append(ipam, network.IPAMConfig{
+			Subnet:  nw.Interfaces.V6.Subnet,
+			Gateway: nw.Interfaces.V6.Gateway,
+		})
+	}
+
 	_, err := cli.NetworkCreate(ctx, nw.Name, network.CreateOptions{
 		Driver:     nw.Driver,
 		EnableIPv6: &enableIPv6,
 		Internal:   nw.IsInternal,
 		IPAM: &network.IPAM{
-			Config: []network.IPAMConfig{{
-				Subnet:  nw.Interfaces.V4.Subnet,
-				Gateway: nw.Interfaces.V4.Gateway,
-			}, {
-				Subnet:  nw.Interfaces.V6.Subnet,
-				Gateway: nw.Interfaces.V6.Gateway,
-			}},
+			Config: ipam,
 		},
 		Options: map[string]string{
 			"encryption": "false",
 			"com.docker.network.bridge.default_bridge":       "false",
 			"com.docker.network.bridge.enable_icc":           strconv.FormatBool(nw.EnableICC),
-			"com.docker.network.bridge.enable_ip_masquerade": "true",
-			"com.docker.network.bridge.host_binding_ipv4":    "0.0.0.0",
-			"com.docker.network.bridge.name":                 "pelican0",
+			"com.docker.network.bridge.enable_ip_masquerade": strconv.FormatBool(nw.EnableIPMasquerade),
+			"com.docker.network.bridge.host_binding_ipv4":    nw.HostBindingIPv4,
+			"com.docker.network.bridge.name":                 nw.BridgeName,
 			"com.docker.network.driver.mtu":                  strconv.FormatInt(nw.NetworkMTU, 10),
 		},
 	})
 	if err != nil {
+		if enableIPv6 && strings.Contains(strings.ToLower(err.Error()), "ipv6") {
+			return errors.Wrap(err, "environment/docker: failed to create network with IPv6 enabled, ensure IPv6 is enabled for the Docker daemon or disable it in the network configuration")
+		}
 		return err
 	}
 	if nw.Driver != "host" && nw.Driver != "overlay" && nw.Driver != "weavemesh" {
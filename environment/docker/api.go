@@ -56,7 +56,7 @@ func (e *Environment) ContainerInspect(ctx context.Context) (types.ContainerJSON
 	// wrong for now it is easy enough for people to switch back to the older method
 	// of fetching stats.
 	if !fastEnabled {
-		return e.client.ContainerInspect(ctx, e.Id)
+		return e.dockerClient().ContainerInspect(ctx, e.Id)
 	}
 
 	var st types.ContainerJSON
@@ -72,7 +72,7 @@ func (e *Environment) ContainerInspect(ctx context.Context) (types.ContainerJSON
 	req.URL.Host = cli.host
 	req.URL.Scheme = cli.scheme
 
-	res, err := e.client.HTTPClient().Do(req)
+	res, err := e.dockerClient().HTTPClient().Do(req)
 	if err != nil {
 		if res == nil {
 			return st, errdefs.Unknown(err)
@@ -0,0 +1,101 @@
+package docker
+
+import (
+	"context"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"emperror.dev/errors"
+)
+
+// applyBandwidthLimits shapes the traffic for a running container using the tc
+// binary on the host. The ingress limit is applied as a rate limit on the host
+// side of the container's veth pair (traffic leaving the host towards the
+// container), and the egress limit is applied as a policer on traffic entering
+// the host from the container.
+//
+// This only works for containers attached to a bridge network where a veth pair
+// exists on the host. If tc is not available on the system the limits are
+// skipped and a warning is logged.
+func (e *Environment) applyBandwidthLimits(ctx context.Context) error {
+	l := e.Configuration.Limits()
+	if l.IngressBandwidth <= 0 && l.EgressBandwidth <= 0 {
+		return nil
+	}
+
+	tc, err := exec.LookPath("tc")
+	if err != nil {
+		e.log().Warn("bandwidth limits are configured for this server but tc is not installed on the host, skipping")
+		return nil
+	}
+
+	veth, err := e.hostVeth(ctx)
+	if err != nil {
+		return err
+	}
+
+	if l.IngressBandwidth > 0 {
+		rate := strconv.FormatInt(l.IngressBandwidth, 10) + "mbit"
+		if err := runTc(ctx, tc, "qdisc", "replace", "dev", veth, "root", "tbf", "rate", rate, "burst", "256kbit", "latency", "400ms"); err != nil {
+			return err
+		}
+	}
+
+	if l.EgressBandwidth > 0 {
+		rate := strconv.FormatInt(l.EgressBandwidth, 10) + "mbit"
+		// Remove any existing ingress qdisc so that the filter below is not applied
+		// twice, this will fail if one doesn't exist which is fine.
+		_ = runTc(ctx, tc, "qdisc", "del", "dev", veth, "ingress")
+		if err := runTc(ctx, tc, "qdisc", "add", "dev", veth, "handle", "ffff:", "ingress"); err != nil {
+			return err
+		}
+		if err := runTc(ctx, tc, "filter", "add", "dev", veth, "parent", "ffff:", "protocol", "all", "u32", "match", "u32", "0", "0", "police", "rate", rate, "burst", "1mb", "drop", "flowid", ":1"); err != nil {
+			return err
+		}
+	}
+
+	e.log().WithField("interface", veth).Debug("applied bandwidth limits to container")
+
+	return nil
+}
+
+// hostVeth returns the name of the host side of the veth pair for the container's
+// primary network interface. This is found by reading the peer interface index
+// from within the container's network namespace and then matching it against the
+// interfaces on the host.
+func (e *Environment) hostVeth(ctx context.Context) (string, error) {
+	c, err := e.ContainerInspect(ctx)
+	if err != nil {
+		return "", errors.WrapIf(err, "environment/docker: failed to inspect container")
+	}
+	if c.State == nil || c.State.Pid == 0 {
+		return "", errors.New("environment/docker: container is not running")
+	}
+
+	b, err := os.ReadFile(filepath.Join("/proc", strconv.Itoa(c.State.Pid), "root/sys/class/net/eth0/iflink"))
+	if err != nil {
+		return "", errors.Wrap(err, "environment/docker: failed to read container interface link")
+	}
+	idx, err := strconv.Atoi(strings.TrimSpace(string(b)))
+	if err != nil {
+		return "", errors.Wrap(err, "environment/docker: failed to parse container interface link")
+	}
+
+	iface, err := net.InterfaceByIndex(idx)
+	if err != nil {
+		return "", errors.Wrap(err, "environment/docker: failed to find host veth interface")
+	}
+
+	return iface.Name, nil
+}
+
+func runTc(ctx context.Context, tc string, args ...string) error {
+	if out, err := exec.CommandContext(ctx, tc, args...).CombinedOutput(); err != nil {
+		return errors.Wrapf(err, "environment/docker: tc %s: %s", strings.Join(args, " "), strings.TrimSpace(string(out)))
+	}
+	return nil
+}
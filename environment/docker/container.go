@@ -2,9 +2,11 @@ package docker
 
 import (
 	"bufio"
+	"bytes"
 	"context"
 	"fmt"
 	"io"
+	"slices"
 	"strconv"
 	"strings"
 	"time"
@@ -17,6 +19,7 @@ import (
 	"github.com/docker/docker/api/types/mount"
 	"github.com/docker/docker/api/types/network"
 	"github.com/docker/docker/client"
+	"github.com/docker/docker/pkg/stdcopy"
 
 	"github.com/IvanX77/turbowings/config"
 	"github.com/IvanX77/turbowings/environment"
@@ -58,7 +61,7 @@ func (e *Environment) Attach(ctx context.Context) error {
 	}
 
 	// Set the stream again with the container.
-	if st, err := e.client.ContainerAttach(ctx, e.Id, opts); err != nil {
+	if st, err := e.dockerClient().ContainerAttach(ctx, e.Id, opts); err != nil {
 		return errors.WrapIf(err, "environment/docker: error while attaching to container")
 	} else {
 		e.SetStream(&st)
@@ -86,6 +89,10 @@ func (e *Environment) Attach(ctx context.Context) error {
 			}
 		}()
 
+		if config.Get().Docker.ForwardOomEvents {
+			go e.watchOomEvents(pollCtx)
+		}
+
 		if err := system.ScanReader(e.stream.Reader, func(v []byte) {
 			e.logCallbackMx.Lock()
 			defer e.logCallbackMx.Unlock()
@@ -107,7 +114,8 @@ func (e *Environment) InSituUpdate() error {
 	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
 	defer cancel()
 
-	if _, err := e.ContainerInspect(ctx); err != nil {
+	c, err := e.ContainerInspect(ctx)
+	if err != nil {
 		// If the container doesn't exist for some reason there really isn't anything
 		// we can do to fix that in this process (it doesn't make sense at least). In those
 		// cases just return without doing anything since we still want to save the configuration
@@ -124,8 +132,17 @@ func (e *Environment) InSituUpdate() error {
 	// for removing memory limits, a container must be re-created.
 	//
 	// @see https://github.com/moby/moby/issues/41946
-	if _, err := e.client.ContainerUpdate(ctx, e.Id, container.UpdateConfig{
-		Resources: e.Configuration.Limits().AsContainerResources(),
+	limits := e.Configuration.Limits()
+
+	// Only apply the restart policy to a running container, otherwise a server that
+	// TurboWings stopped would be started by Docker once the daemon restarts.
+	policy := container.RestartPolicy{Name: container.RestartPolicyDisabled}
+	if c.State != nil && c.State.Running {
+		policy = limits.ContainerRestartPolicy()
+	}
+	if _, err := e.dockerClient().ContainerUpdate(ctx, e.Id, container.UpdateConfig{
+		Resources:     limits.AsContainerResources(),
+		RestartPolicy: policy,
 	}); err != nil {
 		return errors.Wrap(err, "environment/docker: could not update container")
 	}
@@ -163,10 +180,14 @@ func (e *Environment) Create() error {
 		}
 	}
 
-	// Merge user-provided labels with system labels
+	// Merge the node's base labels with the labels for the server, and then the system
+	// labels which can never be overridden.
 	confLabels := e.Configuration.Labels()
-	labels := make(map[string]string, 2+len(confLabels))
+	labels := make(map[string]string, 2+len(cfg.Docker.Labels)+len(confLabels))
 
+	for key := range cfg.Docker.Labels {
+		labels[key] = cfg.Docker.Labels[key]
+	}
 	for key := range confLabels {
 		labels[key] = confLabels[key]
 	}
@@ -186,6 +207,24 @@ func (e *Environment) Create() error {
 		Env:          e.Configuration.EnvironmentVariables(),
 		Labels:       labels,
 	}
+	// Override the entrypoint and command of the image if the egg defines them.
+	if entrypoint := e.Configuration.Entrypoint(); len(entrypoint) > 0 {
+		conf.Entrypoint = entrypoint
+	}
+	if cmd := e.Configuration.Command(); len(cmd) > 0 {
+		conf.Cmd = cmd
+	}
+
+	// Configure the signal and grace period used when Docker itself stops the container.
+	e.mu.RLock()
+	stop := e.meta.Stop
+	e.mu.RUnlock()
+	if stop.Signal != "" {
+		conf.StopSignal = stop.Signal
+	}
+	if stop.Timeout > 0 {
+		conf.StopTimeout = &stop.Timeout
+	}
 
 	// Set the user running the container properly depending on what mode we are operating in.
 	if cfg.System.User.Rootless.Enabled {
@@ -201,12 +240,12 @@ func (e *Environment) Create() error {
 		networkName := "ip-" + strings.ReplaceAll(strings.ReplaceAll(a.DefaultMapping.Ip, ".", "-"), ":", "-")
 		networkMode = container.NetworkMode(networkName)
 
-		if _, err := e.client.NetworkInspect(ctx, networkName, network.InspectOptions{}); err != nil {
+		if _, err := e.dockerClient().NetworkInspect(ctx, networkName, network.InspectOptions{}); err != nil {
 			if !client.IsErrNotFound(err) {
 				return err
 			}
 
-			if _, err := e.client.NetworkCreate(ctx, networkName, network.CreateOptions{
+			if _, err := e.dockerClient().NetworkCreate(ctx, networkName, network.CreateOptions{
 				Driver:     "bridge",
 				EnableIPv6: &enableIPv6,
 				Internal:   false,
@@ -224,6 +263,7 @@ func (e *Environment) Create() error {
 		}
 	}
 
+	limits := e.Configuration.Limits()
 	hostConf := &container.HostConfig{
 		PortBindings: a.DockerBindings(),
 
@@ -239,7 +279,7 @@ func (e *Environment) Create() error {
 
 		// Define resource limits for the container based on the data passed through
 		// from the Panel.
-		Resources: e.Configuration.Limits().AsContainerResources(),
+		Resources: limits.AsContainerResources(),
 
 		DNS: cfg.Docker.Network.Dns,
 
@@ -251,19 +291,58 @@ func (e *Environment) Create() error {
 
 		SecurityOpt:    []string{"no-new-privileges"},
 		ReadonlyRootfs: true,
-		CapDrop: []string{
-			"setpcap", "mknod", "audit_write", "net_raw", "dac_override",
-			"fowner", "fsetid", "net_bind_service", "sys_chroot", "setfcap",
-			"sys_ptrace",
-		},
-		NetworkMode: networkMode,
-		UsernsMode:  container.UsernsMode(cfg.Docker.UsernsMode),
+		CapAdd:         limits.CapAdd,
+		CapDrop:        append(defaultCapDrop(), limits.CapDrop...),
+		Sysctls:        limits.ContainerSysctls(),
+		RestartPolicy:  limits.ContainerRestartPolicy(),
+		ShmSize:        limits.ConvertedShmSize(),
+		NetworkMode:    networkMode,
+		UsernsMode:     container.UsernsMode(cfg.Docker.UsernsMode),
+	}
+
+	// Aliases can only be assigned on user-defined networks, the default bridge and
+	// host networks do not support them.
+	var netConf *network.NetworkingConfig
+	if len(limits.NetworkAliases) > 0 && networkMode.IsUserDefined() {
+		netConf = &network.NetworkingConfig{
+			EndpointsConfig: map[string]*network.EndpointSettings{
+				networkMode.NetworkName(): {Aliases: limits.NetworkAliases},
+			},
+		}
+	}
+
+	// Attaching the container to additional networks is not possible when the
+	// container is using the host network.
+	var networks []string
+	if !networkMode.IsHost() {
+		networks = limits.AdditionalNetworks
+	}
+	// Confirm that the additional networks exist before creating the container,
+	// otherwise the server would fail to boot with a much less helpful error.
+	for _, name := range networks {
+		if _, err := e.dockerClient().NetworkInspect(ctx, name, network.InspectOptions{}); err != nil {
+			if client.IsErrNotFound(err) {
+				return errors.Errorf("environment/docker: additional network \"%s\" does not exist", name)
+			}
+			return errors.Wrapf(err, "environment/docker: failed to inspect additional network \"%s\"", name)
+		}
 	}
 
-	if _, err := e.client.ContainerCreate(ctx, conf, hostConf, nil, nil, e.Id); err != nil {
+	if _, err := e.dockerClient().ContainerCreate(ctx, conf, hostConf, netConf, nil, e.Id); err != nil {
 		return errors.Wrap(err, "environment/docker: failed to create container")
 	}
 
+	for _, name := range networks {
+		if err := e.dockerClient().NetworkConnect(ctx, name, e.Id, &network.EndpointSettings{Aliases: limits.NetworkAliases}); err != nil {
+			// Remove the container again, since an existing container is never
+			// updated it would otherwise be left attached to only some networks.
+			if rerr := e.dockerClient().ContainerRemove(ctx, e.Id, container.RemoveOptions{Force: true}); rerr != nil {
+				e.log().WithField("error", rerr).Warn("failed to remove container after failing to connect it to a network")
+			}
+			return errors.Wrapf(err, "environment/docker: failed to connect container to \"%s\" network", name)
+		}
+	}
+
 	return nil
 }
 
@@ -273,7 +352,7 @@ func (e *Environment) Destroy() error {
 	// We set it to stopping than offline to prevent crash detection from being triggered.
 	e.SetState(environment.ProcessStoppingState)
 
-	err := e.client.ContainerRemove(context.Background(), e.Id, container.RemoveOptions{
+	err := e.dockerClient().ContainerRemove(context.Background(), e.Id, container.RemoveOptions{
 		RemoveVolumes: true,
 		RemoveLinks:   false,
 		Force:         true,
@@ -315,11 +394,53 @@ func (e *Environment) SendCommand(c string) error {
 	return errors.Wrap(err, "environment/docker: could not write to container stream")
 }
 
+// Exec runs the command inside the running container and waits for it to exit,
+// returning the exit code of the command along with its combined output. If the
+// context is canceled before the command exits an error is returned, however the
+// command itself may continue to run inside the container.
+func (e *Environment) Exec(ctx context.Context, cmd []string) (int, []byte, error) {
+	exec, err := e.dockerClient().ContainerExecCreate(ctx, e.Id, container.ExecOptions{
+		Cmd:          cmd,
+		AttachStdout: true,
+		AttachStderr: true,
+	})
+	if err != nil {
+		return 0, nil, errors.Wrap(err, "environment/docker: failed to create exec instance")
+	}
+
+	res, err := e.dockerClient().ContainerExecAttach(ctx, exec.ID, container.ExecAttachOptions{})
+	if err != nil {
+		return 0, nil, errors.Wrap(err, "environment/docker: failed to attach to exec instance")
+	}
+	defer res.Close()
+
+	var out bytes.Buffer
+	done := make(chan error, 1)
+	go func() {
+		_, err := stdcopy.StdCopy(&out, &out, res.Reader)
+		done <- err
+	}()
+	select {
+	case <-ctx.Done():
+		return 0, nil, errors.WithStack(ctx.Err())
+	case err := <-done:
+		if err != nil {
+			return 0, nil, errors.Wrap(err, "environment/docker: failed to read exec output")
+		}
+	}
+
+	inspect, err := e.dockerClient().ContainerExecInspect(ctx, exec.ID)
+	if err != nil {
+		return 0, out.Bytes(), errors.Wrap(err, "environment/docker: failed to inspect exec instance")
+	}
+	return inspect.ExitCode, out.Bytes(), nil
+}
+
 // Readlog reads the log file for the server. This does not care if the server
 // is running or not, it will simply try to read the last X bytes of the file
 // and return them.
 func (e *Environment) Readlog(lines int) ([]string, error) {
-	r, err := e.client.ContainerLogs(context.Background(), e.Id, container.LogsOptions{
+	r, err := e.dockerClient().ContainerLogs(context.Background(), e.Id, container.LogsOptions{
 		ShowStdout: true,
 		ShowStderr: true,
 		Tail:       strconv.Itoa(lines),
@@ -347,20 +468,41 @@ func (e *Environment) Readlog(lines int) ([]string, error) {
 // of that. I'd imagine in a lot of cases an outage shouldn't affect users too
 // badly. It'll at least keep existing servers working correctly if anything.
 func (e *Environment) ensureImageExists(image string) error {
-	e.Events().Publish(environment.DockerImagePullStarted, "")
-	defer e.Events().Publish(environment.DockerImagePullCompleted, "")
-
-	// Images prefixed with a ~ are local images that we do not need to try and pull.
-	if strings.HasPrefix(image, "~") {
-		return nil
-	}
-
 	// Give it up to 15 minutes to pull the image. I think this should cover 99.8% of cases where an
 	// image pull might fail. I can't imagine it will ever take more than 15 minutes to fully pull
 	// an image. Let me know when I am inevitably wrong here...
 	ctx, cancel := context.WithTimeout(context.Background(), time.Minute*15)
 	defer cancel()
 
+	// If the image could not be inspected it is not known whether it exists locally,
+	// so continue on to the pull and check again if that fails.
+	exists, err := e.ImageExists(ctx, image)
+	if err != nil {
+		log.WithFields(log.Fields{"image": image, "error": err}).Warn("failed to inspect docker image, attempting to pull it")
+	}
+	inspected := err == nil
+
+	// Images prefixed with a ~ are local images that we do not need to try and pull, but
+	// they must have been built or loaded on the node already.
+	if strings.HasPrefix(image, "~") {
+		if !inspected {
+			return err
+		}
+		if !exists {
+			return errors.Errorf("environment/docker: local image \"%s\" does not exist, it must be built or loaded on this node before the server can be created", strings.TrimPrefix(image, "~"))
+		}
+		return nil
+	}
+
+	// Let the user know when the image has to be pulled before the server can be
+	// created, as this can take a while on the first boot.
+	var msg string
+	if inspected && !exists {
+		msg = "Docker image " + image + " was not found locally, pulling it now, this could take a few minutes to complete..."
+	}
+	e.Events().Publish(environment.DockerImagePullStarted, msg)
+	defer e.Events().Publish(environment.DockerImagePullCompleted, "")
+
 	// Get a registry auth configuration from the config.
 	var registryAuth *config.RegistryConfiguration
 	for registry, c := range config.Get().Docker.Registries {
@@ -385,34 +527,24 @@ func (e *Environment) ensureImageExists(image string) error {
 		imagePullOptions.RegistryAuth = b64
 	}
 
-	out, err := e.client.ImagePull(ctx, image, imagePullOptions)
+	out, err := e.dockerClient().ImagePull(ctx, image, imagePullOptions)
 	if err != nil {
-		images, ierr := e.client.ImageList(ctx, dockerImage.ListOptions{})
-		if ierr != nil {
-			// Well damn, something has gone really wrong here, just go ahead and abort there
-			// isn't much anything we can do to try and self-recover from this.
-			return errors.Wrap(ierr, "environment/docker: failed to list images")
+		if !inspected {
+			exists, _ = e.ImageExists(ctx, image)
 		}
-
-		for _, img := range images {
-			for _, t := range img.RepoTags {
-				if t != image {
-					continue
-				}
-
-				log.WithFields(log.Fields{
-					"image":        image,
-					"container_id": e.Id,
-					"err":          err.Error(),
-				}).Warn("unable to pull requested image from remote source, however the image exists locally")
-
-				// Okay, we found a matching container image, in that case just go ahead and return
-				// from this function, since there is nothing else we need to do here.
-				return nil
-			}
+		if exists {
+			log.WithFields(log.Fields{
+				"image":        image,
+				"container_id": e.Id,
+				"err":          err.Error(),
+			}).Warn("unable to pull requested image from remote source, however the image exists locally")
+
+			// Okay, we have a matching container image, in that case just go ahead and return
+			// from this function, since there is nothing else we need to do here.
+			return nil
 		}
 
-		return errors.Wrapf(err, "environment/docker: failed to pull \"%s\" image for server", image)
+		return errors.Wrapf(err, "environment/docker: image \"%s\" does not exist locally and could not be pulled", image)
 	}
 	defer out.Close()
 
@@ -439,6 +571,29 @@ func (e *Environment) ensureImageExists(image string) error {
 	return nil
 }
 
+// ImageExists reports whether the given image is available locally, without
+// attempting to pull it. This allows callers to check whether an image needs to
+// be pulled before a container can be created from it.
+func (e *Environment) ImageExists(ctx context.Context, image string) (bool, error) {
+	if _, err := e.dockerClient().ImageInspect(ctx, strings.TrimPrefix(image, "~")); err != nil {
+		if client.IsErrNotFound(err) {
+			return false, nil
+		}
+		return false, errors.Wrapf(err, "environment/docker: failed to inspect \"%s\" image", image)
+	}
+	return true, nil
+}
+
+// defaultCapDrop returns the capabilities that are always dropped from server
+// containers, regardless of what has been requested by the Panel.
+func defaultCapDrop() []string {
+	return []string{
+		"setpcap", "mknod", "audit_write", "net_raw", "dac_override",
+		"fowner", "fsetid", "net_bind_service", "sys_chroot", "setfcap",
+		"sys_ptrace",
+	}
+}
+
 func (e *Environment) convertMounts() []mount.Mount {
 	mounts := e.Configuration.Mounts()
 	out := make([]mount.Mount, len(mounts))
@@ -449,6 +604,16 @@ func (e *Environment) convertMounts() []mount.Mount {
 			Target:   m.Target,
 			ReadOnly: m.ReadOnly,
 		}
+
+		if m.Propagation == "" {
+			continue
+		}
+		p := mount.Propagation(m.Propagation)
+		if !slices.Contains(mount.Propagations, p) {
+			e.log().WithField("target", m.Target).WithField("propagation", m.Propagation).Warn("ignoring unknown bind propagation mode for mount")
+			continue
+		}
+		out[i].BindOptions = &mount.BindOptions{Propagation: p}
 	}
 	return out
 }
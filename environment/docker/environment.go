@@ -79,6 +79,16 @@ func New(id string, m *Metadata, c *environment.Configuration) (*Environment, er
 	return e, nil
 }
 
+// dockerClient returns the Docker client to use for this environment. The shared
+// client is recreated if it stops responding, so always prefer that one and only
+// fall back to the client the environment was created with if it is unavailable.
+func (e *Environment) dockerClient() *client.Client {
+	if cli, err := environment.Docker(); err == nil {
+		return cli
+	}
+	return e.client
+}
+
 func (e *Environment) log() *log.Entry {
 	return log.WithField("environment", e.Type()).WithField("container_id", e.Id)
 }
@@ -0,0 +1,43 @@
+package docker
+
+import (
+	"context"
+
+	"emperror.dev/errors"
+	"github.com/docker/docker/api/types/events"
+	"github.com/docker/docker/api/types/filters"
+
+	"github.com/IvanX77/turbowings/environment"
+)
+
+// watchOomEvents listens for OOM events on the container and publishes an event
+// whenever the kernel kills a process within it for exceeding the memory limit.
+// Events are only published while the OOM killer is enabled for the server, as
+// no process is killed otherwise. This blocks until the context is canceled.
+func (e *Environment) watchOomEvents(ctx context.Context) {
+	msgs, errs := e.dockerClient().Events(ctx, events.ListOptions{
+		Filters: filters.NewArgs(
+			filters.Arg("type", string(events.ContainerEventType)),
+			filters.Arg("container", e.Id),
+			filters.Arg("event", string(events.ActionOOM)),
+		),
+	})
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case err := <-errs:
+			if err != nil && !errors.Is(err, context.Canceled) {
+				e.log().WithField("error", err).Warn("error while watching container for oom events")
+			}
+			return
+		case <-msgs:
+			if !e.Configuration.Limits().OOMKiller {
+				continue
+			}
+			e.log().Warn("container process was killed by the oom killer")
+			e.Events().Publish(environment.OomEvent, "")
+		}
+	}
+}
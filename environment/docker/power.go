@@ -25,7 +25,7 @@ import (
 // is running does not result in the server becoming un-bootable.
 func (e *Environment) OnBeforeStart(ctx context.Context) error {
 	// Always destroy and re-create the server container to ensure that synced data from the Panel is used.
-	if err := e.client.ContainerRemove(ctx, e.Id, container.RemoveOptions{RemoveVolumes: true}); err != nil {
+	if err := e.dockerClient().ContainerRemove(ctx, e.Id, container.RemoveOptions{RemoveVolumes: true}); err != nil {
 		if !client.IsErrNotFound(err) {
 			return errors.WrapIf(err, "environment/docker: failed to remove container during pre-boot")
 		}
@@ -118,10 +118,16 @@ func (e *Environment) Start(ctx context.Context) error {
 		return errors.WrapIf(err, "environment/docker: failed to attach to container")
 	}
 
-	if err := e.client.ContainerStart(actx, e.Id, container.StartOptions{}); err != nil {
+	if err := e.dockerClient().ContainerStart(actx, e.Id, container.StartOptions{}); err != nil {
 		return errors.WrapIf(err, "environment/docker: failed to start container")
 	}
 
+	// Bandwidth shaping is best-effort, a failure here should never prevent the server
+	// from booting.
+	if err := e.applyBandwidthLimits(actx); err != nil {
+		e.log().WithField("error", err).Warn("failed to apply bandwidth limits to container")
+	}
+
 	// No errors, good to continue through.
 	sawError = false
 	return nil
@@ -161,6 +167,8 @@ func (e *Environment) Stop(ctx context.Context) error {
 		return e.Terminate(ctx, signal)
 	}
 
+	e.disableRestartPolicy(ctx)
+
 	// If the process is already offline don't switch it back to stopping. Just leave it how
 	// it is and continue through to the stop handling for the process.
 	// I'm not certain if this should still be here but it seems to work with it and without it so I leave it here.
@@ -183,9 +191,12 @@ func (e *Environment) Stop(ctx context.Context) error {
 	//
 	// Using a negative timeout here will allow the container to stop gracefully,
 	// rather than forcefully terminating it.  Value is in seconds, but -1 is
-	// treated as indefinitely.
+	// treated as indefinitely. If the egg defines its own timeout, that is used instead.
 	timeout := -1
-	if err := e.client.ContainerStop(ctx, e.Id, container.StopOptions{Timeout: &timeout}); err != nil {
+	if s.Timeout > 0 {
+		timeout = s.Timeout
+	}
+	if err := e.dockerClient().ContainerStop(ctx, e.Id, container.StopOptions{Signal: s.Signal, Timeout: &timeout}); err != nil {
 		// If the container does not exist just mark the process as stopped and return without
 		// an error.
 		if client.IsErrNotFound(err) {
@@ -241,7 +252,7 @@ func (e *Environment) WaitForStop(ctx context.Context, duration time.Duration, t
 	// Block the return of this function until the container as been marked as no
 	// longer running. If this wait does not end by the time seconds have passed,
 	// attempt to terminate the container, or return an error.
-	ok, errChan := e.client.ContainerWait(tctx, e.Id, container.WaitConditionNotRunning)
+	ok, errChan := e.dockerClient().ContainerWait(tctx, e.Id, container.WaitConditionNotRunning)
 	select {
 	case <-ctx.Done():
 		if err := ctx.Err(); err != nil {
@@ -269,6 +280,24 @@ func (e *Environment) WaitForStop(ctx context.Context, duration time.Duration, t
 	return nil
 }
 
+// Pause freezes all of the processes in the container without stopping it. This
+// allows files to be modified while the server is guaranteed not to be reading
+// or writing them.
+func (e *Environment) Pause(ctx context.Context) error {
+	if err := e.dockerClient().ContainerPause(ctx, e.Id); err != nil {
+		return errors.WithStack(err)
+	}
+	return nil
+}
+
+// Unpause resumes all of the processes in a container that was paused.
+func (e *Environment) Unpause(ctx context.Context) error {
+	if err := e.dockerClient().ContainerUnpause(ctx, e.Id); err != nil {
+		return errors.WithStack(err)
+	}
+	return nil
+}
+
 // Terminate forcefully terminates the container using the signal provided.
 func (e *Environment) Terminate(ctx context.Context, signal string) error {
 	c, err := e.ContainerInspect(ctx)
@@ -295,9 +324,10 @@ func (e *Environment) Terminate(ctx context.Context, signal string) error {
 
 	// We set it to stopping then offline to prevent crash detection from being triggered.
 	e.SetState(environment.ProcessStoppingState)
+	e.disableRestartPolicy(ctx)
 
 	// Send the initial signal to the container.
-	if err := e.client.ContainerKill(ctx, e.Id, signal); err != nil && !client.IsErrNotFound(err) {
+	if err := e.dockerClient().ContainerKill(ctx, e.Id, signal); err != nil && !client.IsErrNotFound(err) {
 		return errors.WithStack(err)
 	}
 
@@ -332,7 +362,7 @@ func (e *Environment) Terminate(ctx context.Context, signal string) error {
 
 		case <-timeLimit:
 			// Timeout reached, send SIGKILL as a last resort.
-			if err := e.client.ContainerKill(ctx, e.Id, "SIGKILL"); err != nil && !client.IsErrNotFound(err) {
+			if err := e.dockerClient().ContainerKill(ctx, e.Id, "SIGKILL"); err != nil && !client.IsErrNotFound(err) {
 				return errors.WithStack(err)
 			}
 			e.log().WithFields(log.Fields{
@@ -345,3 +375,21 @@ func (e *Environment) Terminate(ctx context.Context, signal string) error {
 		}
 	}
 }
+
+// disableRestartPolicy switches the restart policy of the container to "no" so
+// that Docker does not start the container again after TurboWings has stopped
+// it, either immediately or once the Docker daemon restarts. The configured
+// policy is applied again when the container is re-created during the next boot.
+//
+// Failing to update the policy is not fatal, stopping the server takes priority.
+func (e *Environment) disableRestartPolicy(ctx context.Context) {
+	if p := e.Configuration.Limits().ContainerRestartPolicy(); p.IsNone() {
+		return
+	}
+	_, err := e.dockerClient().ContainerUpdate(ctx, e.Id, container.UpdateConfig{
+		RestartPolicy: container.RestartPolicy{Name: container.RestartPolicyDisabled},
+	})
+	if err != nil && !client.IsErrNotFound(err) {
+		e.log().WithField("error", err).Warn("failed to disable container restart policy before stopping")
+	}
+}
@@ -40,7 +40,7 @@ func (e *Environment) pollResources(ctx context.Context) error {
 	e.log().Info("starting resource polling for container")
 	defer e.log().Debug("stopped resource polling for container")
 
-	stats, err := e.client.ContainerStats(ctx, e.Id, true)
+	stats, err := e.dockerClient().ContainerStats(ctx, e.Id, true)
 	if err != nil {
 		return err
 	}
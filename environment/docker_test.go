@@ -0,0 +1,84 @@
+package environment
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types/network"
+	. "github.com/franela/goblin"
+
+	"github.com/IvanX77/turbowings/config"
+)
+
+func TestDockerNetwork(t *testing.T) {
+	g := Goblin(t)
+
+	g.Describe("applyNetwork", func() {
+		g.It("uses the IPv4 gateway of a bridge network as the interface", func() {
+			c := &config.Configuration{}
+			c.Docker.Network.Interface = "172.18.0.1"
+			applyNetwork(c, network.Inspect{
+				Driver: "bridge",
+				IPAM: network.IPAM{Config: []network.IPAMConfig{
+					{Subnet: "fdba:17c8:6c94::/64", Gateway: "fdba:17c8:6c94::1011"},
+					{Subnet: "172.20.0.0/16", Gateway: "172.20.0.1"},
+				}},
+			})
+			g.Assert(c.Docker.Network.Interface).Equal("172.20.0.1")
+			g.Assert(c.Docker.Network.ISPN).IsFalse()
+		})
+
+		g.It("uses the loopback address for host networks", func() {
+			c := &config.Configuration{}
+			applyNetwork(c, network.Inspect{Driver: "host"})
+			g.Assert(c.Docker.Network.Interface).Equal("127.0.0.1")
+		})
+
+		g.It("clears the interface for overlay networks", func() {
+			c := &config.Configuration{}
+			c.Docker.Network.Interface = "172.18.0.1"
+			applyNetwork(c, network.Inspect{Driver: "overlay"})
+			g.Assert(c.Docker.Network.Interface).Equal("")
+			g.Assert(c.Docker.Network.ISPN).IsTrue()
+		})
+	})
+}
+
+func TestDocker(t *testing.T) {
+	g := Goblin(t)
+
+	g.Describe("Docker", func() {
+		g.BeforeEach(func() {
+			t.Setenv("DOCKER_HOST", "unix://"+filepath.Join(t.TempDir(), "docker.sock"))
+		})
+
+		g.AfterEach(func() {
+			_cmu.Lock()
+			_client = nil
+			_cmu.Unlock()
+		})
+
+		g.It("replaces an unreachable client in the background", func() {
+			cli, err := Docker()
+			g.Assert(err).IsNil()
+
+			_cmu.Lock()
+			_lastChecked = time.Now().Add(-dockerHealthCheckInterval)
+			_cmu.Unlock()
+
+			// The existing client is returned while the health check runs.
+			c, err := Docker()
+			g.Assert(err).IsNil()
+			g.Assert(c == cli).IsTrue()
+
+			for i := 0; i < 100; i++ {
+				if c, _ = Docker(); c != cli {
+					break
+				}
+				time.Sleep(time.Millisecond * 50)
+			}
+			g.Assert(c != cli).IsTrue()
+		})
+	})
+}
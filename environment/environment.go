@@ -13,6 +13,7 @@ const (
 	DockerImagePullStarted   = "docker image pull started"
 	DockerImagePullStatus    = "docker image pull status"
 	DockerImagePullCompleted = "docker image pull completed"
+	OomEvent                 = "oom"
 )
 
 const (
@@ -73,6 +74,11 @@ type ProcessEnvironment interface {
 	// is a no-op if the server is already stopped.
 	Terminate(ctx context.Context, signal string) error
 
+	// Pause suspends all the processes of a running server instance without stopping
+	// it, and Unpause resumes them again.
+	Pause(ctx context.Context) error
+	Unpause(ctx context.Context) error
+
 	// Destroys the environment removing any containers that were created (in Docker
 	// environments at least).
 	Destroy() error
@@ -94,6 +100,11 @@ type ProcessEnvironment interface {
 	// Sends the provided command to the running server instance.
 	SendCommand(string) error
 
+	// Exec runs a command inside the running server instance, separate from the main
+	// server process, and waits for it to exit. The exit code and the combined output
+	// of the command are returned.
+	Exec(ctx context.Context, cmd []string) (int, []byte, error)
+
 	// Reads the log file for the process from the end backwards until the provided
 	// number of lines is met.
 	Readlog(int) ([]string, error)
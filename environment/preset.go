@@ -0,0 +1,62 @@
+package environment
+
+// Preset is a set of defaults for the resource limits of a server that are
+// tuned for a common type of game server. Any values that are explicitly set on
+// the server, or in the node configuration, always take precedence over the
+// values from the preset.
+type Preset string
+
+const (
+	// PresetGeneric uses the default behavior, and is used when no preset is set.
+	PresetGeneric Preset = "generic"
+	// PresetJava is tuned for servers running on the JVM, which uses a significant
+	// amount of memory outside the heap and performs poorly when its heap is
+	// swapped out.
+	PresetJava Preset = "java"
+	// PresetNode is tuned for Node.js servers, which commonly run headless
+	// Chromium and need a larger /dev/shm than Docker provides by default.
+	PresetNode Preset = "node"
+)
+
+// presetJavaSwappiness discourages the kernel from swapping out the JVM heap,
+// which causes long garbage collection pauses.
+const presetJavaSwappiness int64 = 0
+
+// presetNodeShmSize is the size of /dev/shm in mebibytes for Node.js servers.
+const presetNodeShmSize int64 = 256
+
+// overheadMultiplier returns the memory overhead multiplier for the preset, and
+// false if the preset does not change the default multiplier.
+func (p Preset) overheadMultiplier(memoryLimit int64) (float64, bool) {
+	switch p {
+	case PresetJava:
+		if memoryLimit <= 2048 {
+			return 1.20, true
+		} else if memoryLimit <= 4096 {
+			return 1.15, true
+		}
+		return 1.10, true
+	case PresetNode:
+		return 1.10, true
+	}
+	return 0, false
+}
+
+// memorySwappiness returns the memory swappiness for the preset, or nil if the
+// Docker default should be used.
+func (p Preset) memorySwappiness() *int64 {
+	if p == PresetJava {
+		v := presetJavaSwappiness
+		return &v
+	}
+	return nil
+}
+
+// shmSize returns the size of /dev/shm in mebibytes for the preset, or zero if
+// the Docker default should be used.
+func (p Preset) shmSize() int64 {
+	if p == PresetNode {
+		return presetNodeShmSize
+	}
+	return 0
+}
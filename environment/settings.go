@@ -3,10 +3,14 @@ package environment
 import (
 	"fmt"
 	"math"
+	"sort"
 	"strconv"
+	"strings"
 
+	"emperror.dev/errors"
 	"github.com/apex/log"
 	"github.com/docker/docker/api/types/container"
+	"github.com/goccy/go-json"
 
 	"github.com/IvanX77/turbowings/config"
 )
@@ -29,6 +33,10 @@ type Mount struct {
 	// Whether the directory is being mounted as read-only. It is up to the environment to
 	// handle this value correctly and ensure security expectations are met with its usage.
 	ReadOnly bool `json:"read_only"`
+
+	// The bind propagation mode for the mount, one of "private", "rprivate", "shared",
+	// "rshared", "slave", or "rslave". If empty the Docker default of "rprivate" is used.
+	Propagation string `json:"propagation"`
 }
 
 // Limits is the build settings for a given server that impact docker container
@@ -38,9 +46,16 @@ type Limits struct {
 	// use on the host system.
 	MemoryLimit int64 `json:"memory_limit"`
 
-	// The amount of additional swap space to be provided to a container instance.
+	// The amount of additional swap space in mebibytes to be provided to a container
+	// instance. A value of zero disables swap, and a negative value allows unlimited
+	// swap to be used.
 	Swap int64 `json:"swap"`
 
+	// The tendency of the kernel to swap out anonymous pages for this container, as a
+	// value between 0 and 100. Setting this to 0 discourages swapping which can help
+	// latency sensitive servers. If nil the Docker default is used.
+	MemorySwappiness *int64 `json:"memory_swappiness"`
+
 	// The relative weight for IO operations in a container. This is relative to other
 	// containers on the system and should be a value between 10 and 1000.
 	IoWeight uint16 `json:"io_weight"`
@@ -53,10 +68,68 @@ type Limits struct {
 	// The amount of disk space in mebibytes that a server is allowed to use.
 	DiskSpace int64 `json:"disk_space"`
 
+	// The maximum bandwidth in megabits per second that a container is allowed to
+	// receive (ingress) and send (egress). These are applied on a best-effort basis
+	// using traffic control on the host, a value of zero means no limit is applied.
+	IngressBandwidth int64 `json:"ingress_bandwidth"`
+	EgressBandwidth  int64 `json:"egress_bandwidth"`
+
+	// Additional DNS aliases that the container can be reached at by other containers
+	// on the same user-defined networks.
+	NetworkAliases []string `json:"network_aliases"`
+
+	// AdditionalNetworks is a list of existing Docker networks that the container
+	// is attached to in addition to the network configured for the node. These
+	// networks must already exist on the system, they will not be created.
+	AdditionalNetworks []string `json:"additional_networks"`
+
 	// Sets which CPU threads can be used by the docker instance.
 	Threads string `json:"threads"`
 
+	// The CPU real-time period and runtime in microseconds. These grant the container
+	// access to real-time CPU scheduling and are only applied when both are set.
+	CpuRealtimePeriod  int64 `json:"cpu_realtime_period"`
+	CpuRealtimeRuntime int64 `json:"cpu_realtime_runtime"`
+
 	OOMKiller bool `json:"oom_killer"`
+
+	// Additional Linux capabilities to grant to the container, for example "NET_ADMIN"
+	// for servers that need to manage their own network interfaces.
+	CapAdd []string `json:"cap_add"`
+
+	// Linux capabilities to drop from the container in addition to the ones that are
+	// always dropped by TurboWings.
+	CapDrop []string `json:"cap_drop"`
+
+	// Kernel parameters to set within the container, for example "net.core.somaxconn".
+	// Only namespaced sysctls are applied, anything that would modify the host kernel
+	// is ignored when the container is created.
+	Sysctls map[string]string `json:"sysctls"`
+
+	// The restart policy applied to the container by the Docker daemon. TurboWings'
+	// own power management takes precedence over this, the policy is disabled on
+	// the container whenever TurboWings stops or kills the server.
+	RestartPolicy RestartPolicy `json:"restart_policy"`
+
+	// The size of the /dev/shm mount in mebibytes. If not set the Docker default
+	// of 64MiB is used, which is not enough for some Chromium based workloads.
+	ShmSize int64 `json:"shm_size"`
+
+	// The preset used for any of the resource limits above that are not explicitly
+	// set, one of "generic", "java", or "node". If empty no preset is applied.
+	Preset Preset `json:"preset"`
+}
+
+// RestartPolicy defines the restart behavior the Docker daemon should apply to
+// a server container.
+type RestartPolicy struct {
+	// The name of the policy, one of "no", "on-failure", "always", or
+	// "unless-stopped". Defaults to "no" if not set.
+	Name string `json:"name"`
+
+	// The maximum number of times to restart the container. This is only used when
+	// the policy is "on-failure".
+	MaximumRetryCount int `json:"maximum_retry_count"`
 }
 
 // ConvertedCpuLimit converts the CPU limit for a server build into a number
@@ -74,31 +147,127 @@ func (l Limits) ConvertedCpuLimit() int64 {
 // than the amount of memory assigned to the server. If the memory limit for the
 // server is < 4G, use 10%, if less than 2G use 15%. This avoids unexpected
 // crashes from processes like Java which run over the limit.
+//
+// If the server uses a preset the multiplier for that preset is used instead,
+// unless the multipliers have been explicitly overridden for the node.
 func (l Limits) MemoryOverheadMultiplier() float64 {
-	return config.Get().Docker.Overhead.GetMultiplier(l.MemoryLimit)
+	o := config.Get().Docker.Overhead
+	if !o.Override {
+		if m, ok := l.Preset.overheadMultiplier(l.MemoryLimit); ok {
+			return m
+		}
+	}
+	return o.GetMultiplier(l.MemoryLimit)
 }
 
 func (l Limits) BoundedMemoryLimit() int64 {
 	return int64(math.Round(float64(l.MemoryLimit) * l.MemoryOverheadMultiplier() * 1024 * 1024))
 }
 
+// SwapUnlimited is the value of Limits.Swap that indicates a server is allowed
+// to make use of an unlimited amount of swap space. Any negative value is also
+// treated as unlimited.
+const SwapUnlimited int64 = -1
+
 // ConvertedSwap returns the amount of swap available as a total in bytes. This
 // is returned as the amount of memory available to the server initially, PLUS
 // the amount of additional swap to include which is the format used by Docker.
+//
+// A swap value of zero results in a total equal to the memory limit, which Docker
+// treats as no swap being available. A negative value (see SwapUnlimited) allows
+// unlimited swap.
 func (l Limits) ConvertedSwap() int64 {
 	if l.Swap < 0 {
-		return -1
+		return SwapUnlimited
 	}
 
 	return (l.Swap * 1024 * 1024) + l.BoundedMemoryLimit()
 }
 
+// ConvertedShmSize returns the size of /dev/shm in bytes. A value of zero will
+// cause Docker to fall back to its default size.
+func (l Limits) ConvertedShmSize() int64 {
+	size := l.ShmSize
+	if size <= 0 {
+		size = l.Preset.shmSize()
+	}
+	if size <= 0 {
+		return 0
+	}
+
+	return size * 1024 * 1024
+}
+
 // ProcessLimit returns the process limit for a container. This is currently
 // defined at a system level and not on a per-server basis.
 func (l Limits) ProcessLimit() int64 {
 	return config.Get().Docker.ContainerPidLimit
 }
 
+// namespacedSysctls are the sysctls that are namespaced by the kernel and can
+// therefore be safely set for an individual container without impacting the
+// host system. Any sysctl beginning with "net." or "fs.mqueue." is also
+// namespaced.
+//
+// @see https://docs.docker.com/reference/cli/docker/container/run/#sysctl
+var namespacedSysctls = map[string]struct{}{
+	"kernel.msgmax":          {},
+	"kernel.msgmnb":          {},
+	"kernel.msgmni":          {},
+	"kernel.sem":             {},
+	"kernel.shmall":          {},
+	"kernel.shmmax":          {},
+	"kernel.shmmni":          {},
+	"kernel.shm_rmid_forced": {},
+}
+
+// IsNamespacedSysctl determines if the given sysctl is namespaced, and is safe
+// to apply to a single container.
+func IsNamespacedSysctl(key string) bool {
+	if _, ok := namespacedSysctls[key]; ok {
+		return true
+	}
+	return strings.HasPrefix(key, "net.") || strings.HasPrefix(key, "fs.mqueue.")
+}
+
+// ContainerSysctls returns the sysctls that should be applied to the container.
+// Any sysctls that are not namespaced are dropped from the result and a warning
+// is logged, since applying them would modify the host kernel.
+func (l Limits) ContainerSysctls() map[string]string {
+	if len(l.Sysctls) == 0 {
+		return nil
+	}
+
+	out := make(map[string]string, len(l.Sysctls))
+	for k, v := range l.Sysctls {
+		if !IsNamespacedSysctl(k) {
+			log.WithField("sysctl", k).Warn("ignoring sysctl for container since it is not namespaced and would affect the host")
+			continue
+		}
+		out[k] = v
+	}
+
+	return out
+}
+
+// ContainerRestartPolicy returns the restart policy for the container in a format
+// that Docker understands. An empty or unknown policy name results in the "no"
+// policy being used.
+func (l Limits) ContainerRestartPolicy() container.RestartPolicy {
+	name := container.RestartPolicyMode(l.RestartPolicy.Name)
+	switch name {
+	case container.RestartPolicyOnFailure:
+		return container.RestartPolicy{Name: name, MaximumRetryCount: l.RestartPolicy.MaximumRetryCount}
+	case container.RestartPolicyAlways, container.RestartPolicyUnlessStopped:
+		return container.RestartPolicy{Name: name}
+	case container.RestartPolicyDisabled, "":
+	default:
+		log.WithField("restart_policy", l.RestartPolicy.Name).Warn("ignoring unknown container restart policy")
+	}
+
+	return container.RestartPolicy{Name: container.RestartPolicyDisabled}
+}
+
 // Helper function to create a pointer to a boolean value
 func boolPtr(b bool) *bool {
 	return &b
@@ -115,6 +284,10 @@ func (l Limits) AsContainerResources() container.Resources {
 		BlkioWeight:       l.IoWeight,
 		OomKillDisable:    boolPtr(!l.OOMKiller),
 		PidsLimit:         &pids,
+		MemorySwappiness:  l.MemorySwappiness,
+	}
+	if resources.MemorySwappiness == nil {
+		resources.MemorySwappiness = l.Preset.memorySwappiness()
 	}
 
 	// If the CPU Limit is not set, don't send any of these fields through. Providing
@@ -133,9 +306,64 @@ func (l Limits) AsContainerResources() container.Resources {
 		resources.CpusetCpus = l.Threads
 	}
 
+	// Real-time scheduling is an advanced opt-in, only send these values through when
+	// they have both been configured so that the daemon defaults are otherwise used.
+	if l.CpuRealtimePeriod > 0 && l.CpuRealtimeRuntime > 0 {
+		resources.CPURealtimePeriod = l.CpuRealtimePeriod
+		resources.CPURealtimeRuntime = l.CpuRealtimeRuntime
+	}
+
 	return resources
 }
 
+// EffectiveLimits are the computed resource limits that are sent to Docker for
+// a container, after any overhead and unit conversions have been applied.
+type EffectiveLimits struct {
+	Memory            int64  `json:"memory"`
+	MemoryReservation int64  `json:"memory_reservation"`
+	MemorySwap        int64  `json:"memory_swap"`
+	MemorySwappiness  *int64 `json:"memory_swappiness"`
+	CpuQuota          int64  `json:"cpu_quota"`
+	CpuPeriod         int64  `json:"cpu_period"`
+	CpuShares         int64  `json:"cpu_shares"`
+	CpusetCpus        string `json:"cpuset_cpus"`
+	IoWeight          uint16 `json:"io_weight"`
+	PidsLimit         int64  `json:"pids_limit"`
+	OomKillDisable    bool   `json:"oom_kill_disable"`
+	ShmSize           int64  `json:"shm_size"`
+}
+
+// Effective returns the fully computed resource limits for a container. This is
+// derived from the same values passed to Docker by AsContainerResources, and is
+// useful for understanding exactly what a server has been allocated.
+func (l Limits) Effective() EffectiveLimits {
+	r := l.AsContainerResources()
+	el := EffectiveLimits{
+		Memory:            r.Memory,
+		MemoryReservation: r.MemoryReservation,
+		MemorySwap:        r.MemorySwap,
+		MemorySwappiness:  r.MemorySwappiness,
+		CpuQuota:          r.CPUQuota,
+		CpuPeriod:         r.CPUPeriod,
+		CpuShares:         r.CPUShares,
+		CpusetCpus:        r.CpusetCpus,
+		IoWeight:          r.BlkioWeight,
+		ShmSize:           l.ConvertedShmSize(),
+	}
+	if r.PidsLimit != nil {
+		el.PidsLimit = *r.PidsLimit
+	}
+	if r.OomKillDisable != nil {
+		el.OomKillDisable = *r.OomKillDisable
+	}
+
+	return el
+}
+
+// ErrUnsupportedVariableType is returned when an environment variable is of a type
+// that cannot be converted into a string.
+var ErrUnsupportedVariableType = errors.Sentinel("environment: unsupported environment variable type")
+
 type Variables map[string]interface{}
 
 // Get is an ugly hacky function to handle environment variables that get passed
@@ -143,32 +371,77 @@ type Variables map[string]interface{}
 // strings, but that is a fragile idea and if a string wasn't passed through
 // you'd cause a crash or the server to become unavailable. For now try to
 // handle the most likely values from the JSON and hope for the best.
+//
+// Values that cannot be converted are handled according to the configured
+// policy for unknown environment variable types, if they cannot be converted
+// at all a warning is logged and an empty string is returned.
 func (v Variables) Get(key string) string {
+	val, err := v.Value(key)
+	if err != nil {
+		log.WithField("variable", key).WithField("error", err).Warn("failed to marshal environment variable into string")
+	}
+	return val
+}
+
+// Value returns the environment variable as a string. Missing and null values are
+// returned as an empty string. If the variable is of a type that cannot be
+// converted into a string it is encoded as JSON when the configured policy allows
+// it, otherwise ErrUnsupportedVariableType is returned.
+func (v Variables) Value(key string) (string, error) {
 	val, ok := v[key]
-	if !ok {
-		return ""
+	if !ok || val == nil {
+		return "", nil
 	}
 
-	switch val.(type) {
+	switch val := val.(type) {
 	case int:
-		return strconv.Itoa(val.(int))
+		return strconv.Itoa(val), nil
 	case int32:
-		return strconv.FormatInt(val.(int64), 10)
+		return strconv.FormatInt(int64(val), 10), nil
 	case int64:
-		return strconv.FormatInt(val.(int64), 10)
+		return strconv.FormatInt(val, 10), nil
 	case float32:
-		return fmt.Sprintf("%f", val.(float32))
+		return fmt.Sprintf("%f", val), nil
 	case float64:
-		return fmt.Sprintf("%f", val.(float64))
+		return fmt.Sprintf("%f", val), nil
 	case bool:
-		return strconv.FormatBool(val.(bool))
+		return strconv.FormatBool(val), nil
 	case string:
-		return val.(string)
+		return val, nil
 	}
 
-	// TODO: I think we can add a check for val == nil and return an empty string for those
-	//  and this warning should theoretically never happen?
-	log.Warn(fmt.Sprintf("failed to marshal environment variable \"%s\" of type %+v into string", key, val))
+	if config.Get().Docker.UnknownEnvironmentTypes == config.EnvironmentTypesJson {
+		b, err := json.Marshal(val)
+		if err != nil {
+			return "", errors.WrapIff(err, "environment: failed to encode environment variable \"%s\" as json", key)
+		}
+		return string(b), nil
+	}
+	return "", errors.WithMessagef(ErrUnsupportedVariableType, "\"%s\" of type %T", key, val)
+}
 
-	return ""
+// Validate returns an error listing every environment variable of a type that
+// cannot be converted into a string when the configured policy for unknown
+// environment variable types is "error". No error is returned for any other
+// policy since a value is always used for those variables.
+func (v Variables) Validate() error {
+	if config.Get().Docker.UnknownEnvironmentTypes != config.EnvironmentTypesError {
+		return nil
+	}
+	keys := make([]string, 0, len(v))
+	for k := range v {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var invalid []string
+	for _, k := range keys {
+		if _, err := v.Value(k); err != nil {
+			invalid = append(invalid, k)
+		}
+	}
+	if len(invalid) > 0 {
+		return errors.WithMessage(ErrUnsupportedVariableType, strings.Join(invalid, ", "))
+	}
+	return nil
 }
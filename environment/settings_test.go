@@ -0,0 +1,190 @@
+package environment
+
+import (
+	"testing"
+
+	"emperror.dev/errors"
+	"github.com/docker/docker/api/types/container"
+	. "github.com/franela/goblin"
+
+	"github.com/IvanX77/turbowings/config"
+)
+
+func TestLimits_ConvertedSwap(t *testing.T) {
+	g := Goblin(t)
+
+	config.Set(&config.Configuration{AuthenticationToken: "abc"})
+
+	g.Describe("ConvertedSwap", func() {
+		g.It("returns unlimited swap for negative values", func() {
+			l := Limits{MemoryLimit: 1024, Swap: SwapUnlimited}
+			g.Assert(l.ConvertedSwap()).Equal(int64(-1))
+
+			l.Swap = -50
+			g.Assert(l.ConvertedSwap()).Equal(int64(-1))
+		})
+
+		g.It("gives the container no swap when set to zero", func() {
+			r := Limits{MemoryLimit: 1024, Swap: 0}.AsContainerResources()
+			g.Assert(r.MemorySwap).Equal(r.Memory)
+		})
+
+		g.It("adds swap on top of the bounded memory limit", func() {
+			l := Limits{MemoryLimit: 1024, Swap: 512}
+			g.Assert(l.ConvertedSwap()).Equal(l.BoundedMemoryLimit() + 512*1024*1024)
+		})
+	})
+}
+
+func TestLimits_Effective(t *testing.T) {
+	g := Goblin(t)
+
+	config.Set(&config.Configuration{AuthenticationToken: "abc"})
+
+	g.Describe("Effective", func() {
+		g.It("matches the resources sent to Docker", func() {
+			l := Limits{MemoryLimit: 1024, Swap: 0, CpuLimit: 150, Threads: "0-1"}
+			e := l.Effective()
+
+			g.Assert(e.Memory).Equal(l.BoundedMemoryLimit())
+			g.Assert(e.MemorySwap).Equal(l.ConvertedSwap())
+			g.Assert(e.CpuQuota).Equal(int64(150_000))
+			g.Assert(e.CpuPeriod).Equal(int64(100_000))
+			g.Assert(e.CpusetCpus).Equal("0-1")
+			g.Assert(e.OomKillDisable).IsTrue()
+		})
+
+		g.It("does not set a cpu quota when there is no cpu limit", func() {
+			e := Limits{MemoryLimit: 1024}.Effective()
+
+			g.Assert(e.CpuQuota).Equal(int64(0))
+			g.Assert(e.CpuPeriod).Equal(int64(0))
+		})
+	})
+}
+
+func TestLimits_Preset(t *testing.T) {
+	g := Goblin(t)
+
+	g.Describe("Preset", func() {
+		g.BeforeEach(func() {
+			config.Set(&config.Configuration{AuthenticationToken: "abc"})
+		})
+
+		g.It("applies the defaults for the preset", func() {
+			l := Limits{MemoryLimit: 1024, Preset: PresetJava}
+			g.Assert(l.MemoryOverheadMultiplier()).Equal(1.20)
+			g.Assert(*l.AsContainerResources().MemorySwappiness).Equal(int64(0))
+
+			l = Limits{MemoryLimit: 1024, Preset: PresetNode}
+			g.Assert(l.MemoryOverheadMultiplier()).Equal(1.10)
+			g.Assert(l.ConvertedShmSize()).Equal(int64(256 * 1024 * 1024))
+
+			l = Limits{MemoryLimit: 1024, Preset: PresetGeneric}
+			g.Assert(l.MemoryOverheadMultiplier()).Equal(1.15)
+			g.Assert(l.AsContainerResources().MemorySwappiness == nil).IsTrue()
+		})
+
+		g.It("prefers explicitly set values over the preset", func() {
+			swappiness := int64(60)
+			l := Limits{MemoryLimit: 1024, ShmSize: 64, MemorySwappiness: &swappiness, Preset: PresetJava}
+			g.Assert(*l.AsContainerResources().MemorySwappiness).Equal(int64(60))
+
+			l.Preset = PresetNode
+			g.Assert(l.ConvertedShmSize()).Equal(int64(64 * 1024 * 1024))
+
+			config.Update(func(c *config.Configuration) {
+				c.Docker.Overhead = config.Overhead{Override: true, DefaultMultiplier: 1.5}
+			})
+			g.Assert(l.MemoryOverheadMultiplier()).Equal(1.5)
+		})
+	})
+}
+
+func TestVariables_Get(t *testing.T) {
+	g := Goblin(t)
+
+	g.Describe("Get", func() {
+		v := Variables{
+			"STRING": "value",
+			"NUMBER": float64(25565),
+			"INT32":  int32(10),
+			"BOOL":   true,
+			"NULL":   nil,
+			"LIST":   []interface{}{"a", float64(1)},
+		}
+
+		setPolicy := func(policy string) {
+			c := &config.Configuration{AuthenticationToken: "abc"}
+			c.Docker.UnknownEnvironmentTypes = policy
+			config.Set(c)
+		}
+
+		g.It("converts supported types into strings", func() {
+			setPolicy(config.EnvironmentTypesWarn)
+
+			g.Assert(v.Get("STRING")).Equal("value")
+			g.Assert(v.Get("NUMBER")).Equal("25565.000000")
+			g.Assert(v.Get("INT32")).Equal("10")
+			g.Assert(v.Get("BOOL")).Equal("true")
+		})
+
+		g.It("returns an empty string for missing and null values", func() {
+			setPolicy(config.EnvironmentTypesError)
+
+			for _, key := range []string{"MISSING", "NULL"} {
+				val, err := v.Value(key)
+				g.Assert(err).IsNil(key)
+				g.Assert(val).Equal("", key)
+			}
+		})
+
+		g.It("returns an empty string for unsupported types", func() {
+			setPolicy(config.EnvironmentTypesWarn)
+
+			val, err := v.Value("LIST")
+			g.Assert(errors.Is(err, ErrUnsupportedVariableType)).IsTrue()
+			g.Assert(val).Equal("")
+			g.Assert(v.Get("LIST")).Equal("")
+			g.Assert(v.Validate()).IsNil()
+		})
+
+		g.It("encodes unsupported types as json when configured", func() {
+			setPolicy(config.EnvironmentTypesJson)
+
+			g.Assert(v.Get("LIST")).Equal(`["a",1]`)
+			g.Assert(v.Validate()).IsNil()
+		})
+
+		g.It("returns an error listing unsupported types when configured", func() {
+			setPolicy(config.EnvironmentTypesError)
+
+			err := v.Validate()
+			g.Assert(errors.Is(err, ErrUnsupportedVariableType)).IsTrue()
+			g.Assert(err.Error()).Equal("LIST: environment: unsupported environment variable type")
+		})
+	})
+}
+
+func TestLimits_ContainerRestartPolicy(t *testing.T) {
+	g := Goblin(t)
+
+	g.Describe("ContainerRestartPolicy", func() {
+		g.It("restarts the container on failure", func() {
+			l := Limits{RestartPolicy: RestartPolicy{Name: "on-failure", MaximumRetryCount: 3}}
+			g.Assert(l.ContainerRestartPolicy()).Equal(container.RestartPolicy{Name: container.RestartPolicyOnFailure, MaximumRetryCount: 3})
+		})
+
+		g.It("always restarts the container", func() {
+			for _, name := range []container.RestartPolicyMode{container.RestartPolicyAlways, container.RestartPolicyUnlessStopped} {
+				l := Limits{RestartPolicy: RestartPolicy{Name: string(name), MaximumRetryCount: 3}}
+				g.Assert(l.ContainerRestartPolicy()).Equal(container.RestartPolicy{Name: name}, string(name))
+			}
+		})
+
+		g.It("does not restart the container by default", func() {
+			g.Assert(Limits{}.ContainerRestartPolicy()).Equal(container.RestartPolicy{Name: container.RestartPolicyDisabled})
+			g.Assert(Limits{RestartPolicy: RestartPolicy{Name: "sometimes"}}.ContainerRestartPolicy()).Equal(container.RestartPolicy{Name: container.RestartPolicyDisabled})
+		})
+	})
+}
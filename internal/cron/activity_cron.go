@@ -6,6 +6,7 @@ import (
 
 	"emperror.dev/errors"
 
+	"github.com/IvanX77/turbowings/config"
 	"github.com/IvanX77/turbowings/internal/database"
 	"github.com/IvanX77/turbowings/internal/models"
 	"github.com/IvanX77/turbowings/server"
@@ -68,6 +69,7 @@ func (ac *activityCron) Run(ctx context.Context) error {
 		return nil
 	}
 
+	setActivityTimezones(ac.manager, activities)
 	if err := ac.manager.Client().SendActivityLogs(ctx, activities); err != nil {
 		return errors.WrapIf(err, "cron: failed to send activity events to Panel")
 	}
@@ -97,3 +99,21 @@ func (ac *activityCron) Run(ctx context.Context) error {
 
 	return nil
 }
+
+// setActivityTimezones sets the effective timezone of the server that each of the
+// activity events belongs to, falling back to the system timezone for any servers
+// that no longer exist on this node.
+func setActivityTimezones(m *server.Manager, activity []models.Activity) {
+	zones := make(map[string]string)
+	for i, a := range activity {
+		tz, ok := zones[a.Server]
+		if !ok {
+			tz = config.Get().System.Timezone
+			if s, ok := m.Get(a.Server); ok {
+				tz = s.Timezone()
+			}
+			zones[a.Server] = tz
+		}
+		activity[i].Timezone = tz
+	}
+}
@@ -0,0 +1,73 @@
+package cron
+
+import (
+	"context"
+
+	"emperror.dev/errors"
+	"github.com/apex/log"
+
+	"github.com/IvanX77/turbowings/remote"
+	"github.com/IvanX77/turbowings/server"
+	"github.com/IvanX77/turbowings/server/backup"
+	"github.com/IvanX77/turbowings/system"
+)
+
+type backupVerifyCron struct {
+	mu        *system.AtomicBool
+	manager   *server.Manager
+	readLimit int
+	report    bool
+}
+
+// Run verifies the checksums of all the local backups for every server on the
+// node, logging any backups that no longer match the checksum recorded when they
+// were created. If enabled, failed backups are also reported to the Panel.
+//
+// Backups are verified one at a time and are read at a limited speed so that the
+// job does not hammer the disk while servers are running.
+func (bc *backupVerifyCron) Run(ctx context.Context) error {
+	if !bc.mu.SwapIf(true) {
+		return errors.WithStack(ErrCronRunning)
+	}
+	defer bc.mu.Store(false)
+
+	var verified, failed int
+	for _, s := range bc.manager.All() {
+		results, err := backup.VerifyLocal(ctx, s.ID(), bc.readLimit)
+		if err != nil {
+			if errors.Is(err, context.Canceled) {
+				return err
+			}
+			s.Log().WithField("error", err).Error("cron: failed to verify local backups for server")
+			continue
+		}
+		for _, v := range results {
+			verified++
+			if v.Valid() {
+				continue
+			}
+			failed++
+			s.Log().WithFields(log.Fields{
+				"backup":   v.Uuid,
+				"expected": v.Expected,
+				"actual":   v.Actual,
+			}).Error("cron: local backup checksum does not match the checksum recorded at creation")
+
+			if !bc.report {
+				continue
+			}
+			err := bc.manager.Client().SetBackupStatus(ctx, v.Uuid, remote.BackupRequest{
+				Checksum:     v.Actual,
+				ChecksumType: "sha1",
+				Size:         v.Size,
+				Successful:   false,
+			})
+			if err != nil {
+				s.Log().WithField("backup", v.Uuid).WithField("error", err).Warn("cron: failed to report failed backup verification to Panel")
+			}
+		}
+	}
+
+	log.WithField("cron", "backup_verify").WithFields(log.Fields{"verified": verified, "failed": failed}).Info("finished verifying local backups")
+	return nil
+}
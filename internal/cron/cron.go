@@ -90,5 +90,37 @@ func Scheduler(ctx context.Context, m *server.Manager) (gocron.Scheduler, error)
 		return nil, errors.Wrap(err, "cron: failed to create sftp job")
 	}
 
+	// Backup verification job
+	if backups := config.Get().System.Backups; backups.VerifyOnBoot || backups.VerifyInterval > 0 {
+		verify := backupVerifyCron{
+			mu:        system.NewAtomicBool(false),
+			manager:   m,
+			readLimit: backups.VerifyReadLimit,
+			report:    backups.ReportVerifyFailures,
+		}
+		task := gocron.NewTask(func() {
+			l.WithField("cron", "backup_verify").Info("verifying checksums of local backups")
+			if err := verify.Run(ctx); err != nil {
+				if errors.Is(err, ErrCronRunning) {
+					l.WithField("cron", "backup_verify").Warn("backup verification process is already running, skipping...")
+				} else {
+					l.WithField("cron", "backup_verify").WithField("error", err).Error("backup verification process failed to execute")
+				}
+			}
+		})
+
+		var definition gocron.JobDefinition = gocron.OneTimeJob(gocron.OneTimeJobStartImmediately())
+		if backups.VerifyInterval > 0 {
+			definition = gocron.DurationJob(time.Duration(backups.VerifyInterval) * time.Minute)
+		}
+		var opts []gocron.JobOption
+		if backups.VerifyOnBoot && backups.VerifyInterval > 0 {
+			opts = append(opts, gocron.WithStartAt(gocron.WithStartImmediately()))
+		}
+		if _, err := s.NewJob(definition, task, opts...); err != nil {
+			return nil, errors.Wrap(err, "cron: failed to create backup verification job")
+		}
+	}
+
 	return s, nil
 }
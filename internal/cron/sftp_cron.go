@@ -81,7 +81,9 @@ func (sc *sftpCron) Run(ctx context.Context) error {
 	if len(events.m) == 0 {
 		return nil
 	}
-	if err := sc.manager.Client().SendActivityLogs(ctx, events.Elements()); err != nil {
+	elements := events.Elements()
+	setActivityTimezones(sc.manager, elements)
+	if err := sc.manager.Client().SendActivityLogs(ctx, elements); err != nil {
 		return errors.Wrap(err, "failed to send sftp activity logs to Panel")
 	}
 
@@ -0,0 +1,107 @@
+// Package metrics exports the internal metrics of the daemon in the Prometheus
+// exposition format.
+package metrics
+
+import (
+	"net/http"
+
+	"emperror.dev/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+
+	"github.com/IvanX77/turbowings/internal/ufs"
+	"github.com/IvanX77/turbowings/parser"
+)
+
+var registry = prometheus.NewRegistry()
+
+// Parser is the collector for the configuration file parsers. It is registered
+// with the registry served by Handler and should be passed to parser.SetMetrics.
+var Parser = newParserMetrics()
+
+func init() {
+	registry.MustRegister(Parser.parses, Parser.replacements, Parser.errors)
+}
+
+// Handler returns the handler that serves the metrics for the daemon.
+func Handler() http.Handler {
+	return promhttp.HandlerFor(registry, promhttp.HandlerOpts{})
+}
+
+// ParserMetrics counts the configuration files that are parsed by type, the
+// outcome of the replacements that were processed, and the parse errors.
+type ParserMetrics struct {
+	parses       *prometheus.CounterVec
+	replacements *prometheus.CounterVec
+	errors       *prometheus.CounterVec
+}
+
+func newParserMetrics() *ParserMetrics {
+	return &ParserMetrics{
+		parses: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "turbowings",
+			Subsystem: "parser",
+			Name:      "parses_total",
+			Help:      "The number of configuration files parsed, by parser and outcome.",
+		}, []string{"parser", "outcome"}),
+		replacements: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "turbowings",
+			Subsystem: "parser",
+			Name:      "replacements_total",
+			Help:      "The number of replacements processed, by parser and outcome.",
+		}, []string{"parser", "outcome"}),
+		errors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "turbowings",
+			Subsystem: "parser",
+			Name:      "errors_total",
+			Help:      "The number of configuration files that failed to parse, by parser and error.",
+		}, []string{"parser", "error"}),
+	}
+}
+
+// ObserveParse implements parser.Metrics.
+func (m *ParserMetrics) ObserveParse(p parser.ConfigurationParser, result parser.ParseResult, err error) {
+	name := string(p)
+	outcome := "parsed"
+	if err != nil {
+		outcome = "errored"
+		m.errors.WithLabelValues(name, parseErrorKind(err)).Inc()
+	} else if result.Unchanged {
+		outcome = "unchanged"
+	}
+	m.parses.WithLabelValues(name, outcome).Inc()
+
+	for o, n := range map[string]int{
+		"created": result.Created,
+		"updated": result.Updated,
+		"skipped": result.Skipped,
+		"errored": result.Errored,
+	} {
+		if n > 0 {
+			m.replacements.WithLabelValues(name, o).Add(float64(n))
+		}
+	}
+}
+
+// parseErrorKind returns a label describing the cause of a parse error, keeping
+// the number of distinct label values small.
+func parseErrorKind(err error) string {
+	var rerr *parser.ReplacementError
+	var serr *parser.SchemaError
+	switch {
+	case errors.Is(err, parser.ErrFileTooLarge):
+		return "too_large"
+	case errors.Is(err, ufs.ErrBadPathResolution):
+		return "outside_root"
+	case errors.Is(err, parser.ErrUnsupportedParser):
+		return "unsupported"
+	case errors.Is(err, parser.ErrParseSyntax):
+		return "syntax"
+	case errors.As(err, &serr):
+		return "schema"
+	case errors.Is(err, parser.ErrReplacementLookup), errors.As(err, &rerr):
+		return "replacement"
+	default:
+		return "other"
+	}
+}
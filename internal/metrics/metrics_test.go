@@ -0,0 +1,34 @@
+package metrics
+
+import (
+	"fmt"
+	"testing"
+
+	"emperror.dev/errors"
+	. "github.com/franela/goblin"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"github.com/IvanX77/turbowings/parser"
+)
+
+func TestParserMetrics_ObserveParse(t *testing.T) {
+	g := Goblin(t)
+
+	g.Describe("ParserMetrics", func() {
+		g.It("counts parses, replacements and errors", func() {
+			m := newParserMetrics()
+
+			m.ObserveParse(parser.Properties, parser.ParseResult{Updated: 2, Skipped: 1}, nil)
+			m.ObserveParse(parser.Properties, parser.ParseResult{Unchanged: true}, nil)
+			m.ObserveParse(parser.Json, parser.ParseResult{Errored: 3}, errors.WithStack(fmt.Errorf("%w: 10 bytes", parser.ErrFileTooLarge)))
+
+			g.Assert(testutil.ToFloat64(m.parses.WithLabelValues("properties", "parsed"))).Equal(float64(1))
+			g.Assert(testutil.ToFloat64(m.parses.WithLabelValues("properties", "unchanged"))).Equal(float64(1))
+			g.Assert(testutil.ToFloat64(m.parses.WithLabelValues("json", "errored"))).Equal(float64(1))
+			g.Assert(testutil.ToFloat64(m.replacements.WithLabelValues("properties", "updated"))).Equal(float64(2))
+			g.Assert(testutil.ToFloat64(m.replacements.WithLabelValues("properties", "skipped"))).Equal(float64(1))
+			g.Assert(testutil.ToFloat64(m.replacements.WithLabelValues("json", "errored"))).Equal(float64(3))
+			g.Assert(testutil.ToFloat64(m.errors.WithLabelValues("json", "too_large"))).Equal(float64(1))
+		})
+	})
+}
@@ -34,6 +34,10 @@ type Activity struct {
 	// internal system IP.
 	IP        string    `gorm:"not null" json:"ip"`
 	Timestamp time.Time `gorm:"not null" json:"timestamp"`
+	// Timezone is the effective timezone of the server at the time the event is sent to
+	// the Panel. The timestamp is always stored as UTC, this allows the Panel to display
+	// it in the timezone of the server. This is not stored in the database.
+	Timezone string `gorm:"-" json:"timezone,omitempty"`
 }
 
 // SetUser sets the current user that performed the action. If an empty string is provided
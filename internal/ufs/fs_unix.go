@@ -590,6 +590,28 @@ func (fs *UnixFS) Symlink(oldpath, newpath string) error {
 	return nil
 }
 
+// Readlinkat returns the destination of the named symbolic link, relative to
+// the given directory file descriptor. The destination is returned as-is and
+// may point outside of the filesystem.
+//
+// If there is an error, it will be of type *PathError.
+func (fs *UnixFS) Readlinkat(dirfd int, name string) (string, error) {
+	for n := 128; ; n *= 2 {
+		b := make([]byte, n)
+		var l int
+		if err := ignoringEINTR(func() error {
+			var err error
+			l, err = unix.Readlinkat(dirfd, name, b)
+			return err
+		}); err != nil {
+			return "", ensurePathError(err, "readlinkat", name)
+		}
+		if l < n {
+			return string(b[0:l]), nil
+		}
+	}
+}
+
 // Touch will attempt to open a file for reading and/or writing. If the file
 // does not exist it will be created, and any missing parent directories will
 // also be created. The opened file may be truncated, only if `flag` has
@@ -0,0 +1,67 @@
+// SPDX-License-Identifier: MIT
+// SPDX-FileCopyrightText: Copyright (c) 2024 Matthew Penner
+
+//go:build unix
+
+package ufs
+
+import (
+	"io"
+	"os"
+	"strconv"
+
+	"golang.org/x/sys/unix"
+)
+
+// ResolvePath returns the absolute path of the open file, with any symlinks
+// resolved, as reported by the kernel. Unlike the name the file was opened with
+// this cannot be influenced by the path used to reach the file.
+func ResolvePath(f File) (string, error) {
+	p, err := os.Readlink("/proc/self/fd/" + strconv.Itoa(int(f.Fd())))
+	if err != nil {
+		return "", ensurePathError(err, "resolve", f.Name())
+	}
+	return p, nil
+}
+
+// Rewrite replaces the entire contents of f with data while preserving the
+// mode the file had before it was rewritten.
+//
+// The file is truncated and written in place rather than being replaced by a
+// new file, so its ownership, any hard links, and any open descriptors are
+// left untouched. The kernel clears the setuid and setgid bits of a file that
+// is written to by an unprivileged process, so the original mode is captured
+// up front and restored once the write has completed.
+func Rewrite(f File, data []byte) error {
+	fd := int(f.Fd())
+
+	var st unix.Stat_t
+	if err := ignoringEINTR(func() error {
+		return unix.Fstat(fd, &st)
+	}); err != nil {
+		return ensurePathError(err, "rewrite", f.Name())
+	}
+
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	if err := f.Truncate(0); err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		return err
+	}
+
+	var after unix.Stat_t
+	if err := ignoringEINTR(func() error {
+		return unix.Fstat(fd, &after)
+	}); err != nil {
+		return ensurePathError(err, "rewrite", f.Name())
+	}
+	if after.Mode&07777 == st.Mode&07777 {
+		return nil
+	}
+	return ensurePathError(ignoringEINTR(func() error {
+		return unix.Fchmod(fd, st.Mode&07777)
+	}), "rewrite", f.Name())
+}
@@ -0,0 +1,51 @@
+// SPDX-License-Identifier: MIT
+// SPDX-FileCopyrightText: Copyright (c) 2024 Matthew Penner
+
+//go:build unix
+
+package ufs_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/IvanX77/turbowings/internal/ufs"
+)
+
+func TestRewrite(t *testing.T) {
+	p := filepath.Join(t.TempDir(), "config")
+	if err := os.WriteFile(p, []byte("a much longer original value"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	// Explicitly set the mode so the umask does not interfere.
+	if err := os.Chmod(p, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := os.OpenFile(p, os.O_RDWR, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	if err := ufs.Rewrite(f, []byte("short")); err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := os.ReadFile(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != "short" {
+		t.Errorf("expected file contents to be %q, got %q", "short", string(b))
+	}
+
+	st, err := os.Stat(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st.Mode().Perm() != 0o600 {
+		t.Errorf("expected file mode to be %v, got %v", os.FileMode(0o600), st.Mode().Perm())
+	}
+}
@@ -0,0 +1,60 @@
+package parser
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+
+	"emperror.dev/errors"
+
+	"github.com/IvanX77/turbowings/config"
+)
+
+// gzipMagic is the header every gzip compressed file starts with.
+var gzipMagic = []byte{0x1f, 0x8b}
+
+// decompressible reports whether the contents should be decompressed before
+// they are parsed. Only the structured formats are decompressed, the plain file
+// parser is often pointed at arbitrary files which are left as they are.
+func (f *ConfigurationFile) decompressible(input []byte) bool {
+	return f.Parser != File && bytes.HasPrefix(input, gzipMagic)
+}
+
+// gunzip decompresses the gzip compressed contents, returning the header of the
+// original contents so that they can be compressed in the same way once parsed.
+// The decompressed contents are subject to the same size limit as any other
+// configuration file.
+func gunzip(input []byte) ([]byte, gzip.Header, error) {
+	r, err := gzip.NewReader(bytes.NewReader(input))
+	if err != nil {
+		return nil, gzip.Header{}, newSyntaxError(err)
+	}
+	defer r.Close()
+
+	var src io.Reader = r
+	if limit := config.Get().System.MaxConfigFileSize; limit > 0 {
+		src = io.LimitReader(r, limit*1024*1024+1)
+	}
+	out, err := io.ReadAll(src)
+	if err != nil {
+		return nil, gzip.Header{}, newSyntaxError(err)
+	}
+	if err := checkSize(int64(len(out))); err != nil {
+		return nil, gzip.Header{}, err
+	}
+	return out, r.Header, nil
+}
+
+// gzipBytes compresses the contents using the given header.
+func gzipBytes(input []byte, header gzip.Header) ([]byte, error) {
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	w.Header = header
+	if _, err := w.Write(input); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if err := w.Close(); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return buf.Bytes(), nil
+}
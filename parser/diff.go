@@ -0,0 +1,128 @@
+package parser
+
+import (
+	"bytes"
+	"strings"
+
+	"github.com/pmezard/go-difflib/difflib"
+)
+
+// DiffContext is the number of unchanged lines included around each change in
+// a diff.
+const DiffContext = 3
+
+// DiffOperation is the way a line differs between the two sides of a diff.
+type DiffOperation string
+
+const (
+	DiffEqual  DiffOperation = "equal"
+	DiffInsert DiffOperation = "insert"
+	DiffDelete DiffOperation = "delete"
+)
+
+// DiffLine is a single line within a hunk of a diff. The line numbers start at 1
+// and are only set for the sides of the diff that the line is present in.
+type DiffLine struct {
+	Operation DiffOperation `json:"operation"`
+	OldLine   int           `json:"old_line,omitempty"`
+	NewLine   int           `json:"new_line,omitempty"`
+	Content   string        `json:"content"`
+}
+
+// DiffHunk is a group of changed lines along with the unchanged lines around
+// them, the same as a hunk in a unified diff.
+type DiffHunk struct {
+	OldStart int        `json:"old_start"`
+	OldLines int        `json:"old_lines"`
+	NewStart int        `json:"new_start"`
+	NewLines int        `json:"new_lines"`
+	Lines    []DiffLine `json:"lines"`
+}
+
+// Diff is a line-oriented diff between the contents of a configuration file
+// before and after it was parsed. A diff without any hunks means the contents
+// were not changed.
+type Diff struct {
+	Hunks []DiffHunk `json:"hunks"`
+}
+
+// Changed reports whether there are any differences in the diff.
+func (d Diff) Changed() bool {
+	return len(d.Hunks) > 0
+}
+
+// Diff applies the replacements for the configuration file to the given contents
+// in the same way as ParseBytes, returning the differences between the contents
+// and the parsed result rather than the result itself. Gzip compressed contents
+// are compared after being decompressed.
+func (f *ConfigurationFile) Diff(input []byte) (Diff, error) {
+	out, err := f.ParseBytes(input)
+	if err != nil {
+		return Diff{}, err
+	}
+	if f.decompressible(input) {
+		if input, _, err = gunzip(input); err != nil {
+			return Diff{}, err
+		}
+		if f.decompressible(out) {
+			if out, _, err = gunzip(out); err != nil {
+				return Diff{}, err
+			}
+		}
+	}
+	return DiffBytes(input, out), nil
+}
+
+// DiffBytes returns the line-oriented differences between the original and the
+// updated contents of a file.
+func DiffBytes(original, updated []byte) Diff {
+	d := Diff{Hunks: []DiffHunk{}}
+	if bytes.Equal(original, updated) {
+		return d
+	}
+
+	a, b := splitLines(original), splitLines(updated)
+	m := difflib.NewMatcherWithJunk(a, b, false, nil)
+	for _, group := range m.GetGroupedOpCodes(DiffContext) {
+		first, last := group[0], group[len(group)-1]
+		h := DiffHunk{
+			OldStart: first.I1 + 1,
+			OldLines: last.I2 - first.I1,
+			NewStart: first.J1 + 1,
+			NewLines: last.J2 - first.J1,
+		}
+		for _, c := range group {
+			if c.Tag == 'e' {
+				for i := c.I1; i < c.I2; i++ {
+					h.Lines = append(h.Lines, DiffLine{Operation: DiffEqual, OldLine: i + 1, NewLine: c.J1 + i - c.I1 + 1, Content: a[i]})
+				}
+				continue
+			}
+			if c.Tag == 'r' || c.Tag == 'd' {
+				for i := c.I1; i < c.I2; i++ {
+					h.Lines = append(h.Lines, DiffLine{Operation: DiffDelete, OldLine: i + 1, Content: a[i]})
+				}
+			}
+			if c.Tag == 'r' || c.Tag == 'i' {
+				for j := c.J1; j < c.J2; j++ {
+					h.Lines = append(h.Lines, DiffLine{Operation: DiffInsert, NewLine: j + 1, Content: b[j]})
+				}
+			}
+		}
+		d.Hunks = append(d.Hunks, h)
+	}
+	return d
+}
+
+// splitLines splits the contents into lines without their line endings. A final
+// line ending does not result in an additional empty line.
+func splitLines(b []byte) []string {
+	if len(b) == 0 {
+		return nil
+	}
+	lines := strings.Split(strings.TrimSuffix(string(b), "\n"), "\n")
+	for i, l := range lines {
+		lines[i] = strings.TrimSuffix(l, "\r")
+	}
+	return lines
+}
@@ -0,0 +1,77 @@
+package parser
+
+import (
+	"bytes"
+	"encoding/binary"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// textEncoding is the detected encoding of a configuration file, based on the
+// byte order mark present at the start of the file.
+type textEncoding int
+
+const (
+	encodingUTF8 textEncoding = iota
+	encodingUTF8BOM
+	encodingUTF16LE
+	encodingUTF16BE
+)
+
+var (
+	bomUTF8    = []byte{0xEF, 0xBB, 0xBF}
+	bomUTF16LE = []byte{0xFF, 0xFE}
+	bomUTF16BE = []byte{0xFE, 0xFF}
+)
+
+// decodeText strips any byte order mark from the start of the given content and
+// transcodes UTF-16 content into UTF-8 so that it can be parsed normally. The
+// detected encoding is returned so that the content can be written back in the
+// same format using encodeText.
+func decodeText(b []byte) ([]byte, textEncoding) {
+	switch {
+	case bytes.HasPrefix(b, bomUTF8):
+		return b[len(bomUTF8):], encodingUTF8BOM
+	case bytes.HasPrefix(b, bomUTF16LE):
+		return decodeUTF16(b[len(bomUTF16LE):], binary.LittleEndian), encodingUTF16LE
+	case bytes.HasPrefix(b, bomUTF16BE):
+		return decodeUTF16(b[len(bomUTF16BE):], binary.BigEndian), encodingUTF16BE
+	}
+	return b, encodingUTF8
+}
+
+// encodeText converts UTF-8 content back into the given encoding, including the
+// byte order mark if one was originally present.
+func encodeText(b []byte, enc textEncoding) []byte {
+	switch enc {
+	case encodingUTF8BOM:
+		return append(append([]byte{}, bomUTF8...), b...)
+	case encodingUTF16LE:
+		return append(append([]byte{}, bomUTF16LE...), encodeUTF16(b, binary.LittleEndian)...)
+	case encodingUTF16BE:
+		return append(append([]byte{}, bomUTF16BE...), encodeUTF16(b, binary.BigEndian)...)
+	}
+	return b
+}
+
+func decodeUTF16(b []byte, order binary.ByteOrder) []byte {
+	u := make([]uint16, len(b)/2)
+	for i := range u {
+		u[i] = order.Uint16(b[i*2:])
+	}
+
+	out := make([]byte, 0, len(b))
+	for _, r := range utf16.Decode(u) {
+		out = utf8.AppendRune(out, r)
+	}
+	return out
+}
+
+func encodeUTF16(b []byte, order binary.ByteOrder) []byte {
+	u := utf16.Encode([]rune(string(b)))
+	out := make([]byte, len(u)*2)
+	for i, v := range u {
+		order.PutUint16(out[i*2:], v)
+	}
+	return out
+}
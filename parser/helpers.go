@@ -2,6 +2,7 @@ package parser
 
 import (
 	"bytes"
+	"fmt"
 	"regexp"
 	"strconv"
 	"strings"
@@ -10,7 +11,10 @@ import (
 	"github.com/Jeffail/gabs/v2"
 	"github.com/apex/log"
 	"github.com/buger/jsonparser"
+	"github.com/goccy/go-json"
 	"github.com/iancoleman/strcase"
+
+	"github.com/IvanX77/turbowings/config"
 )
 
 // Regex to match anything that has a value matching the format of {{ config.$1 }} which
@@ -35,6 +39,27 @@ var configMatchRegex = regexp.MustCompile(`{{\s?config\.([\w.-]+)\s?}}`)
 // noinspection RegExpRedundantEscape
 var xmlValueMatchRegex = regexp.MustCompile(`^\[([\w]+)='(.*)'\]$`)
 
+// Regex used to check if an XML match ends with the name of an attribute to target,
+// for example the "@target" in "Configuration.Appenders.Console@target". Attribute
+// predicates within the path, such as "Appenders.Console[@name='x']", do not match
+// since the attribute name must come at the very end.
+var xmlAttributeMatchRegex = regexp.MustCompile(`^(.+)@([\w:-]+)$`)
+
+// Regex used to check that the first segment of an XML match is a plain element name
+// that can be used to create the root element of an empty document. Wildcards and
+// predicates cannot be used to create an element.
+var xmlElementNameRegex = regexp.MustCompile(`^[A-Za-z_][\w:-]*$`)
+
+// splitXmlAttribute splits an XML match into the path of the element and the name
+// of the attribute being targeted on that element, if any.
+func splitXmlAttribute(match string) (string, string) {
+	m := xmlAttributeMatchRegex.FindStringSubmatch(match)
+	if m == nil {
+		return match, ""
+	}
+	return m[1], m[2]
+}
+
 // Gets the value of a key based on the value type defined.
 func (cfr *ConfigurationFileReplacement) getKeyValue(value string) interface{} {
 	if cfr.ReplaceWith.Type() == jsonparser.Boolean {
@@ -62,16 +87,54 @@ func (cfr *ConfigurationFileReplacement) getKeyValue(value string) interface{} {
 // This does not currently support nested wildcard matches. For example, foo.*.bar
 // will work, however foo.*.bar.*.baz will not, since we'll only be splitting at the
 // first wildcard, and not subsequent ones.
+//
+// Elements of an array can also be targeted by the value of one of their fields
+// using a predicate, for example worlds[name=world].seed will only update the seed
+// of the objects in the worlds array that have a name of "world".
 func (f *ConfigurationFile) IterateOverJson(data []byte) (*gabs.Container, error) {
 	parsed, err := gabs.ParseJSON(data)
 	if err != nil {
-		return nil, err
+		return nil, newSyntaxError(err)
+	}
+
+	// Apply the merge patch to the document before any of the individual replacements,
+	// allowing the replacements to further refine the patched document.
+	if len(f.MergePatch) > 0 {
+		patch, err := gabs.ParseJSON(f.MergePatch)
+		if err != nil {
+			return nil, errors.WithMessage(err, "parser: failed to parse merge patch")
+		}
+		parsed = gabs.Wrap(applyMergePatch(parsed.Data(), patch.Data()))
 	}
 
 	for _, v := range f.Replace {
 		value, err := f.LookupConfigurationValue(v)
 		if err != nil {
-			return nil, err
+			return nil, newReplacementError(v.Match, err)
+		}
+
+		// Check for an array predicate, and if found only apply the replacement to the
+		// elements of the array that have a matching field value.
+		if matches := checkForArrayPredicate.FindStringSubmatch(v.Match); len(matches) == 5 {
+			o := outcomeSkipped
+			for _, child := range childrenAtPath(parsed, matches[1]) {
+				if !predicateMatches(child, matches[2], matches[3]) {
+					continue
+				}
+				co, err := v.setAtPathway(child, strings.TrimPrefix(matches[4], "."), value)
+				if err != nil {
+					if errors.Is(err, gabs.ErrNotFound) {
+						continue
+					}
+					return nil, newReplacementError(v.Match, errors.WithMessage(err, "failed to set config value of matched array element"))
+				}
+				o = max(o, co)
+			}
+			if o == outcomeSkipped {
+				f.warnNoMatch(v.Match)
+			}
+			f.result.record(o)
+			continue
 		}
 
 		// Check for a wildcard character, and if found split the key on that value to
@@ -84,28 +147,66 @@ func (f *ConfigurationFile) IterateOverJson(data []byte) (*gabs.Container, error
 			//
 			// If the child is a null value, nothing will happen. Seems reasonable as of the
 			// time this code is being written.
+			o := outcomeSkipped
 			for _, child := range parsed.Path(strings.Trim(parts[0], ".")).Children() {
-				if err := v.SetAtPathway(child, strings.Trim(parts[1], "."), value); err != nil {
+				co, err := v.setAtPathway(child, strings.Trim(parts[1], "."), value)
+				if err != nil {
 					if errors.Is(err, gabs.ErrNotFound) {
 						continue
 					}
-					return nil, errors.WithMessage(err, "failed to set config value of array child")
+					return nil, newReplacementError(v.Match, errors.WithMessage(err, "failed to set config value of array child"))
 				}
+				o = max(o, co)
+			}
+			if o == outcomeSkipped {
+				f.warnNoMatch(v.Match)
 			}
+			f.result.record(o)
 			continue
 		}
 
-		if err := v.SetAtPathway(parsed, v.Match, value); err != nil {
+		o, err := v.setAtPathway(parsed, v.Match, value)
+		if err != nil {
 			if errors.Is(err, gabs.ErrNotFound) {
+				f.warnNoMatch(v.Match)
+				f.result.record(outcomeSkipped)
 				continue
 			}
-			return nil, errors.WithMessage(err, "unable to set config value at pathway: "+v.Match)
+			return nil, newReplacementError(v.Match, errors.WithMessage(err, "unable to set config value at pathway"))
 		}
+		f.result.record(o)
 	}
 
 	return parsed, nil
 }
 
+// Regex used to check if the given pathway filters the elements of an array using a
+// predicate, such as "worlds[name=world].seed". The trailing pathway is required since
+// it is the value within each matched element that will be updated.
+var checkForArrayPredicate = regexp.MustCompile(`^([^\[\]]*)\[([^\[\]=]+)=([^\[\]]*)](\..+)$`)
+
+// childrenAtPath returns the children of the container at the given path, or the
+// children of the container itself if the path is empty.
+func childrenAtPath(c *gabs.Container, path string) []*gabs.Container {
+	if path == "" {
+		return c.Children()
+	}
+	return c.Path(path).Children()
+}
+
+// predicateMatches determines if the value at the given key of the container is
+// equal to the expected value. Non-string values are compared using their JSON
+// representation, so "enabled=true" or "id=5" will work as expected.
+func predicateMatches(c *gabs.Container, key string, expected string) bool {
+	if !c.ExistsP(key) {
+		return false
+	}
+	if v, ok := c.Path(key).Data().(string); ok {
+		return v == expected
+	}
+	return c.Path(key).String() == expected
+}
+
 // Regex used to check if there is an array element present in the given pathway by looking for something
 // along the lines of "something[1]" or "something[1].nestedvalue" as the path.
 var checkForArrayElement = regexp.MustCompile(`^([^\[\]]+)\[([\d]+)](\..+)?$`)
@@ -181,37 +282,93 @@ func setValueAtPath(c *gabs.Container, path string, value interface{}) error {
 // Sets the value at a specific pathway, but checks if we were looking for a specific
 // value or not before doing it.
 func (cfr *ConfigurationFileReplacement) SetAtPathway(c *gabs.Container, path string, value string) error {
+	_, err := cfr.setAtPathway(c, path, value)
+	return err
+}
+
+// setAtPathway sets the value at the given pathway, returning the outcome of the
+// replacement so that the caller can determine if anything was changed.
+func (cfr *ConfigurationFileReplacement) setAtPathway(c *gabs.Container, path string, value string) (outcome, error) {
+	existed := c.ExistsP(path)
+
+	if cfr.Append {
+		applied, err := cfr.appendAtPathway(c, path, cfr.getKeyValue(value))
+		return outcomeOf(applied, existed), err
+	}
+
+	if cfr.Merge {
+		fragment, err := cfr.mergeFragment(value)
+		if err != nil {
+			return outcomeSkipped, err
+		}
+		var current interface{}
+		if existed {
+			current = c.Path(path).Data()
+		}
+		return outcomeOf(true, existed), setValueAtPath(c, path, applyMergePatch(current, fragment))
+	}
+
 	if cfr.IfValue == "" {
-		return setValueAtPath(c, path, cfr.getKeyValue(value))
+		return outcomeOf(true, existed), setValueAtPath(c, path, cfr.getKeyValue(value))
 	}
 
 	// Check if we are replacing instead of overwriting.
 	if strings.HasPrefix(cfr.IfValue, "regex:") {
 		// Doing a regex replacement requires an existing value.
 		// TODO: Do we try passing an empty string to the regex?
-		if c.ExistsP(path) {
-			return gabs.ErrNotFound
+		if existed {
+			return outcomeSkipped, gabs.ErrNotFound
 		}
 
 		r, err := regexp.Compile(strings.TrimPrefix(cfr.IfValue, "regex:"))
 		if err != nil {
 			log.WithFields(log.Fields{"if_value": strings.TrimPrefix(cfr.IfValue, "regex:"), "error": err}).
 				Warn("configuration if_value using invalid regexp, cannot perform replacement")
-			return nil
+			return outcomeSkipped, nil
 		}
 
 		v := strings.Trim(c.Path(path).String(), "\"")
 		if r.Match([]byte(v)) {
-			return setValueAtPath(c, path, r.ReplaceAllString(v, value))
+			return outcomeOf(true, existed), setValueAtPath(c, path, r.ReplaceAllString(v, value))
 		}
-		return nil
+		return outcomeSkipped, nil
 	}
 
-	if c.ExistsP(path) && !bytes.Equal(c.Bytes(), []byte(cfr.IfValue)) {
-		return nil
+	if existed && !bytes.Equal(c.Bytes(), []byte(cfr.IfValue)) {
+		return outcomeSkipped, nil
 	}
 
-	return setValueAtPath(c, path, cfr.getKeyValue(value))
+	return outcomeOf(true, existed), setValueAtPath(c, path, cfr.getKeyValue(value))
+}
+
+// Appends the value to the array at the given path, creating the array if it does
+// not yet exist. If the replacement is deduplicated the value is not appended when
+// it is already present in the array.
+func (cfr *ConfigurationFileReplacement) appendAtPathway(c *gabs.Container, path string, value interface{}) (bool, error) {
+	if c.ExistsP(path) {
+		existing, ok := c.Path(path).Data().([]interface{})
+		if !ok {
+			log.WithField("match", cfr.Match).Warn("cannot append configuration value to a key that is not an array")
+			return false, nil
+		}
+
+		if cfr.Deduplicate {
+			b, err := json.Marshal(value)
+			if err != nil {
+				return false, errors.WithStack(err)
+			}
+			for _, v := range existing {
+				if eb, err := json.Marshal(v); err == nil && bytes.Equal(eb, b) {
+					return false, nil
+				}
+			}
+		}
+	}
+
+	if err := c.ArrayAppendP(value, path); err != nil {
+		return false, errors.WithMessage(err, "failed to append value at config path: "+path)
+	}
+	return true, nil
 }
 
 // Looks up a configuration value on the Daemon given a dot-notated syntax.
@@ -223,32 +380,69 @@ func (f *ConfigurationFile) LookupConfigurationValue(cfr ConfigurationFileReplac
 		return cfr.ReplaceWith.String(), nil
 	}
 
-	// If there is a match, lookup the value in the configuration for the Daemon. If no key
-	// is found, just return the string representation, otherwise use the value from the
-	// daemon configuration here.
-	huntPath := configMatchRegex.ReplaceAllString(
-		configMatchRegex.FindString(cfr.ReplaceWith.String()), "$1",
-	)
+	// Lookup the value of each placeholder in the configuration for the Daemon, replacing
+	// the placeholder with the value from the daemon configuration.
+	var lookupErr error
+	var missing bool
+	value := configMatchRegex.ReplaceAllStringFunc(cfr.ReplaceWith.String(), func(placeholder string) string {
+		if lookupErr != nil || missing {
+			return placeholder
+		}
 
-	var path []string
-	for _, value := range strings.Split(huntPath, ".") {
-		path = append(path, strcase.ToSnake(value))
-	}
+		var path []string
+		for _, value := range strings.Split(configMatchRegex.FindStringSubmatch(placeholder)[1], ".") {
+			path = append(path, strcase.ToSnake(value))
+		}
 
-	// Look for the key in the configuration file, and if found return that value to the
-	// calling function.
-	match, _, _, err := jsonparser.Get(f.configuration, path...)
-	if err != nil {
+		match, _, _, err := jsonparser.Get(f.configuration, path...)
+		if err == nil {
+			return string(match)
+		}
+		// If the replacement provides a default use that in place of the value that
+		// could not be found, rather than failing or leaving the value empty. Any other
+		// placeholders are still replaced with their own values.
+		if cfr.Default != nil {
+			log.WithFields(log.Fields{"path": path, "filename": f.FileName, "error": err}).Debug("failed to load a configuration value, using the replacement default")
+			return *cfr.Default
+		}
 		if err != jsonparser.KeyPathNotFoundError {
-			return string(match), err
+			lookupErr = errors.WithStack(fmt.Errorf("%w: %w", ErrReplacementLookup, err))
+		} else {
+			log.WithFields(log.Fields{"path": path, "filename": f.FileName}).Debug("attempted to load a configuration value that does not exist")
+			missing = true
 		}
+		return placeholder
+	})
+	if lookupErr != nil {
+		return "", lookupErr
+	}
+	// If there is no key, and no default, the value is left empty.
+	if missing {
+		return "", nil
+	}
+	return value, nil
+}
 
-		log.WithFields(log.Fields{"path": path, "filename": f.FileName}).Debug("attempted to load a configuration value that does not exist")
+// ResolveReplacement returns the value the replacement resolves to when it is
+// applied to a configuration file, including any values substituted from the
+// current TurboWings configuration. Nothing is read from or written to the disk.
+func ResolveReplacement(cfr ConfigurationFileReplacement) (string, error) {
+	cfg, err := json.Marshal(config.Get())
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+	f := ConfigurationFile{configuration: cfg}
+	return f.LookupConfigurationValue(cfr)
+}
 
-		// If there is no key, keep the original value intact, that way it is obvious there
-		// is a replace issue at play.
-		return string(match), nil
-	} else {
-		return configMatchRegex.ReplaceAllString(cfr.ReplaceWith.String(), string(match)), nil
+// warnNoMatch logs a warning that a replacement did not match anything within the
+// configuration file, and was therefore not applied. This is only logged when the
+// daemon is running in debug mode to avoid flooding the logs, since plenty of eggs
+// have replacements that only apply to some versions of a game.
+func (f *ConfigurationFile) warnNoMatch(match string) {
+	if !f.debug {
+		return
 	}
+	log.WithFields(log.Fields{"file": f.FileName, "match": match, "parser": f.Parser.String()}).
+		Warn("configuration file replacement did not match any values")
 }
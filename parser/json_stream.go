@@ -0,0 +1,126 @@
+package parser
+
+import (
+	"bytes"
+	"strings"
+	"unicode"
+
+	"emperror.dev/errors"
+	"github.com/buger/jsonparser"
+	"github.com/goccy/go-json"
+)
+
+// streamJsonFile applies the replacements for a JSON file by editing the keys in
+// place rather than decoding the entire document, leaving the rest of the file
+// untouched. This is only possible when every replacement is a plain path to a key
+// within nested objects, if any replacement requires the document to be parsed,
+// such as a wildcard, array, or predicate match, false is returned and the file
+// is left for the regular parser to handle.
+func (f *ConfigurationFile) streamJsonFile(input []byte) ([]byte, bool, error) {
+	if len(f.MergePatch) > 0 {
+		return nil, false, nil
+	}
+	if _, t, _, err := jsonparser.Get(input); err != nil || t != jsonparser.Object {
+		return nil, false, nil
+	}
+
+	data := input
+	outcomes := make([]outcome, 0, len(f.Replace))
+	for _, v := range f.Replace {
+		path, ok := v.streamPath()
+		if !ok {
+			return nil, false, nil
+		}
+		// Every parent of the key must either be an object or not exist yet, anything
+		// else is left to the regular parser to deal with.
+		depth := 0
+		for ; depth < len(path)-1; depth++ {
+			_, t, _, err := jsonparser.Get(data, path[:depth+1]...)
+			if err == jsonparser.KeyPathNotFoundError {
+				break
+			}
+			if err != nil || t != jsonparser.Object {
+				return nil, false, nil
+			}
+		}
+
+		value, err := f.LookupConfigurationValue(v)
+		if err != nil {
+			return nil, false, newReplacementError(v.Match, err)
+		}
+		b, err := json.Marshal(v.getKeyValue(value))
+		if err != nil {
+			return nil, false, newReplacementError(v.Match, errors.WithStack(err))
+		}
+
+		_, _, _, err = jsonparser.Get(data, path...)
+		existed := err == nil
+		if existed {
+			data, err = jsonparser.Set(data, b, path...)
+		} else {
+			data, err = insertJsonKey(data, path[:depth], path[depth:], b)
+		}
+		if err != nil {
+			return nil, false, newReplacementError(v.Match, errors.WithMessage(err, "unable to set config value at pathway"))
+		}
+		outcomes = append(outcomes, outcomeOf(true, existed))
+	}
+
+	for _, o := range outcomes {
+		f.result.record(o)
+	}
+	return data, true, nil
+}
+
+// insertJsonKey adds the keys to the end of the object at the parent path, with
+// any keys after the first being created as nested objects around the value.
+func insertJsonKey(data []byte, parent []string, keys []string, value []byte) ([]byte, error) {
+	obj, _, end, err := jsonparser.Get(data, parent...)
+	if err != nil {
+		return nil, err
+	}
+
+	var insert bytes.Buffer
+	// Insert the keys directly after the last value in the object, or the opening
+	// brace if it is empty, so that the closing brace keeps its formatting.
+	at := end - len(obj) + len(bytes.TrimRightFunc(obj[:len(obj)-1], unicode.IsSpace))
+	if data[at-1] != '{' {
+		insert.WriteByte(',')
+	}
+	for i, k := range keys {
+		key, err := json.Marshal(k)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		insert.Write(key)
+		insert.WriteByte(':')
+		if i < len(keys)-1 {
+			insert.WriteByte('{')
+		}
+	}
+	insert.Write(value)
+	insert.Write(bytes.Repeat([]byte{'}'}, len(keys)-1))
+
+	out := make([]byte, 0, len(data)+insert.Len())
+	out = append(out, data[:at]...)
+	out = append(out, insert.Bytes()...)
+	return append(out, data[at:]...), nil
+}
+
+// streamPath returns the keys of the path matched by the replacement if it can be
+// applied by editing a JSON file in place.
+func (cfr *ConfigurationFileReplacement) streamPath() ([]string, bool) {
+	if cfr.Append || cfr.Merge || cfr.IfValue != "" {
+		return nil, false
+	}
+	if cfr.Match == "" || strings.ContainsAny(cfr.Match, "*[]~\"\\") {
+		return nil, false
+	}
+	path := strings.Split(cfr.Match, ".")
+	for _, p := range path {
+		if p == "" {
+			return nil, false
+		}
+	}
+	return path, true
+}
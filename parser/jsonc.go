@@ -0,0 +1,55 @@
+package parser
+
+// stripJsonComments removes any line (//) and block (/* */) comments from a
+// JSONC document, along with any trailing commas before a closing brace or
+// bracket, leaving plain JSON that can be parsed normally. Anything within a
+// string is left untouched.
+//
+// The comments are not written back to the disk once the file is updated.
+func stripJsonComments(input []byte) []byte {
+	out := make([]byte, 0, len(input))
+	// The index in the output of a comma that may turn out to be trailing, or -1
+	// if there is no such comma.
+	comma := -1
+	for i := 0; i < len(input); i++ {
+		c := input[i]
+		switch {
+		case c == '"':
+			comma = -1
+			start := i
+			for i++; i < len(input) && input[i] != '"'; i++ {
+				if input[i] == '\\' {
+					i++
+				}
+			}
+			end := min(i+1, len(input))
+			out = append(out, input[start:end]...)
+		case c == '/' && i+1 < len(input) && input[i+1] == '/':
+			for i < len(input) && input[i] != '\n' {
+				i++
+			}
+			i--
+		case c == '/' && i+1 < len(input) && input[i+1] == '*':
+			i += 2
+			for i+1 < len(input) && !(input[i] == '*' && input[i+1] == '/') {
+				i++
+			}
+			i++
+		case c == ',':
+			comma = len(out)
+			out = append(out, c)
+		case c == '}' || c == ']':
+			if comma >= 0 {
+				out = append(out[:comma], out[comma+1:]...)
+				comma = -1
+			}
+			out = append(out, c)
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			out = append(out, c)
+		default:
+			comma = -1
+			out = append(out, c)
+		}
+	}
+	return out
+}
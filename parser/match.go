@@ -0,0 +1,77 @@
+package parser
+
+import (
+	"strconv"
+	"strings"
+
+	"emperror.dev/errors"
+)
+
+// validateMatch checks that the match of a replacement is well-formed for the
+// given parser. This only catches obvious mistakes, such as unbalanced brackets or
+// empty segments in a dotted path, a match that passes may still fail to resolve
+// to anything when the file is parsed.
+func validateMatch(p ConfigurationParser, match string) error {
+	if strings.TrimSpace(match) == "" {
+		return errors.New("match is empty")
+	}
+
+	switch p {
+	case File, Properties:
+		return nil
+	case Csv, Tsv:
+		row, _, ok := strings.Cut(match, ".")
+		if !ok {
+			return errors.New("match must be in the format \"row.column\"")
+		}
+		if n, err := strconv.Atoi(row); err != nil || n < 0 {
+			return errors.New("row of the match must be a non-negative number")
+		}
+		return nil
+	}
+
+	segments, err := splitMatch(match)
+	if err != nil {
+		return err
+	}
+	// Only the section and key are split for ini files, any further dots are
+	// considered to be a part of the key.
+	if p == Ini && len(segments) > 2 {
+		segments = []string{segments[0], strings.Join(segments[1:], ".")}
+	}
+	for _, s := range segments {
+		if s == "" {
+			return errors.New("match contains an empty path segment")
+		}
+	}
+	return nil
+}
+
+// splitMatch splits a dotted match into its segments, ignoring any dots within
+// brackets. An error is returned if the brackets in the match are not balanced.
+func splitMatch(match string) ([]string, error) {
+	var (
+		segments []string
+		depth    int
+		start    int
+	)
+	for i, c := range match {
+		switch c {
+		case '[':
+			depth++
+		case ']':
+			if depth--; depth < 0 {
+				return nil, errors.New("match contains a closing bracket without an opening bracket")
+			}
+		case '.':
+			if depth == 0 {
+				segments = append(segments, match[start:i])
+				start = i + 1
+			}
+		}
+	}
+	if depth != 0 {
+		return nil, errors.New("match contains an unclosed bracket")
+	}
+	return append(segments, match[start:]), nil
+}
@@ -0,0 +1,50 @@
+package parser
+
+import (
+	"emperror.dev/errors"
+	"github.com/buger/jsonparser"
+	"github.com/goccy/go-json"
+	"gopkg.in/yaml.v3"
+)
+
+// applyMergePatch applies a JSON merge patch to the target document as defined
+// by RFC 7386, returning the patched document. Objects in the patch are merged
+// recursively into the target, a null value removes the key from the target, and
+// any other value replaces the value in the target entirely.
+func applyMergePatch(target, patch interface{}) interface{} {
+	p, ok := patch.(map[string]interface{})
+	if !ok {
+		return patch
+	}
+	t, ok := target.(map[string]interface{})
+	if !ok {
+		t = make(map[string]interface{}, len(p))
+	}
+	for k, v := range p {
+		if v == nil {
+			delete(t, k)
+			continue
+		}
+		t[k] = applyMergePatch(t[k], v)
+	}
+	return t
+}
+
+// mergeFragment returns the document that should be merged into the existing
+// value for the replacement. The replacement value can either be an object, or a
+// string containing a YAML or JSON document, which must itself be an object.
+func (cfr *ConfigurationFileReplacement) mergeFragment(value string) (map[string]interface{}, error) {
+	var fragment interface{}
+	if cfr.ReplaceWith.Type() == jsonparser.Object {
+		if err := json.Unmarshal(cfr.ReplaceWith.Value(), &fragment); err != nil {
+			return nil, errors.WithMessage(err, "failed to parse value to merge")
+		}
+	} else if err := yaml.Unmarshal([]byte(value), &fragment); err != nil {
+		return nil, errors.WithMessage(err, "failed to parse value to merge")
+	}
+	m, ok := fragment.(map[string]interface{})
+	if !ok {
+		return nil, errors.New("value to merge must be an object")
+	}
+	return m, nil
+}
@@ -0,0 +1,37 @@
+package parser
+
+import "sync/atomic"
+
+// Metrics receives the outcome of every configuration file that is parsed so
+// that it can be exported to a monitoring system, for example as Prometheus
+// counters of parses by type, replacements applied, and parse errors.
+//
+// Implementations must be safe for concurrent use as configuration files are
+// parsed in parallel when a server boots.
+type Metrics interface {
+	// ObserveParse is called exactly once for every configuration file that is
+	// parsed and written back to the disk, including files that were skipped as
+	// unchanged or could not be read. Dry runs are not reported. The result
+	// contains the outcome of the replacements that were processed, and err is
+	// the error returned by the parser, if any.
+	ObserveParse(parser ConfigurationParser, result ParseResult, err error)
+}
+
+type metricsHolder struct {
+	Metrics
+}
+
+var metrics atomic.Value
+
+// SetMetrics sets the Metrics implementation that parse outcomes are reported
+// to. Passing nil stops reporting.
+func SetMetrics(m Metrics) {
+	metrics.Store(metricsHolder{m})
+}
+
+// observeParse reports the outcome of a parse to the configured Metrics.
+func observeParse(parser ConfigurationParser, result ParseResult, err error) {
+	if h, ok := metrics.Load().(metricsHolder); ok && h.Metrics != nil {
+		h.ObserveParse(parser, result, err)
+	}
+}
@@ -3,7 +3,14 @@ package parser
 import (
 	"bufio"
 	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
+	"fmt"
 	"io"
+	"path/filepath"
+	"slices"
 	"strconv"
 	"strings"
 
@@ -21,6 +28,51 @@ import (
 	"github.com/IvanX77/turbowings/internal/ufs"
 )
 
+var (
+	// ErrFileTooLarge is returned when a configuration file exceeds the maximum size
+	// that the parser is configured to process.
+	ErrFileTooLarge = errors.Sentinel("parser: configuration file exceeds maximum size")
+
+	// ErrParseSyntax is returned when the contents of a configuration file are not
+	// valid for the parser being used, such as malformed JSON.
+	ErrParseSyntax = errors.Sentinel("parser: configuration file contains invalid syntax")
+
+	// ErrReplacementLookup is returned when the value for a replacement could not be
+	// looked up from the configuration of the daemon.
+	ErrReplacementLookup = errors.Sentinel("parser: failed to lookup replacement value")
+
+	// ErrUnsupportedParser is returned when a configuration file uses a parser that
+	// does not exist.
+	ErrUnsupportedParser = errors.Sentinel("parser: unsupported configuration file parser")
+)
+
+// newSyntaxError marks the error as being caused by the configuration file having
+// invalid syntax, while keeping the original error intact.
+func newSyntaxError(err error) error {
+	return errors.WithStack(fmt.Errorf("%w: %w", ErrParseSyntax, err))
+}
+
+// ReplacementError is returned when a specific replacement could not be applied
+// to a configuration file, allowing callers to report which replacement failed.
+type ReplacementError struct {
+	Match string
+	err   error
+}
+
+// newReplacementError wraps the error as having been caused by the replacement
+// with the given match.
+func newReplacementError(match string, err error) error {
+	return errors.WithStack(&ReplacementError{Match: match, err: err})
+}
+
+func (e *ReplacementError) Error() string {
+	return "parser: failed to apply replacement for \"" + e.Match + "\": " + e.err.Error()
+}
+
+func (e *ReplacementError) Unwrap() error {
+	return e.err
+}
+
 // The file parsing options that are available for a server configuration file.
 const (
 	File       = "file"
@@ -28,9 +80,18 @@ const (
 	Properties = "properties"
 	Ini        = "ini"
 	Json       = "json"
+	Jsonc      = "jsonc"
 	Xml        = "xml"
+	Csv        = "csv"
+	Tsv        = "tsv"
 )
 
+// SupportedParsers returns all the parsers that can be used for a configuration
+// file by this build, including any aliases that are accepted in their place.
+func SupportedParsers() []string {
+	return []string{File, Yaml, "yml", Properties, Ini, Json, Jsonc, Xml, Csv, Tsv}
+}
+
 type ReplaceValue struct {
 	value     []byte
 	valueType jsonparser.ValueType
@@ -97,6 +158,128 @@ func (cv *ReplaceValue) Bytes() []byte {
 
 type ConfigurationParser string
 
+// extensions are the file extensions handled by each parser when the replacements
+// for a configuration file are applied to every file within a directory.
+var extensions = map[ConfigurationParser][]string{
+	Yaml:       {".yaml", ".yml"},
+	"yml":      {".yaml", ".yml"},
+	Properties: {".properties"},
+	Ini:        {".ini"},
+	Json:       {".json"},
+	Jsonc:      {".jsonc", ".json"},
+	Xml:        {".xml"},
+	Csv:        {".csv"},
+	Tsv:        {".tsv"},
+}
+
+// Handles reports whether a file with the given name is handled by the parser
+// when the replacements are applied to every file within a directory. The plain
+// file parser handles files with any name.
+func (p ConfigurationParser) Handles(name string) bool {
+	if p == File {
+		return true
+	}
+	return slices.Contains(extensions[p], strings.ToLower(filepath.Ext(name)))
+}
+
+// JsonFormat defines how a JSON configuration file is formatted when it is
+// written back to the disk.
+type JsonFormat string
+
+const (
+	// JsonFormatPretty writes the file indented with four spaces, this is the
+	// default behavior.
+	JsonFormatPretty JsonFormat = "pretty"
+	// JsonFormatCompact writes the file without any indentation or newlines.
+	JsonFormatCompact JsonFormat = "compact"
+	// JsonFormatAuto writes the file compact if the original file was minified,
+	// and pretty otherwise.
+	JsonFormatAuto JsonFormat = "auto"
+)
+
+// XmlIndent defines how an XML configuration file is indented when it is written
+// back to the disk. Besides the options below, this can be set to the number of
+// spaces to indent each level with. If unset, two spaces are used.
+type XmlIndent string
+
+const (
+	// XmlIndentTabs indents each level of the file with a single tab.
+	XmlIndentTabs XmlIndent = "tabs"
+	// XmlIndentPreserve keeps the original formatting of the file rather than
+	// re-indenting the entire file. Any elements created by a replacement are
+	// not indented.
+	XmlIndentPreserve XmlIndent = "preserve"
+)
+
+// UnmarshalJSON allows the number of spaces to be provided as either a number
+// or a string.
+func (x *XmlIndent) UnmarshalJSON(data []byte) error {
+	if len(data) > 0 && data[0] != '"' {
+		if _, err := strconv.Atoi(string(data)); err != nil {
+			return errors.WithStack(err)
+		}
+		*x = XmlIndent(data)
+		return nil
+	}
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	*x = XmlIndent(s)
+	return nil
+}
+
+// BooleanStyle defines how boolean replacement values are written to ini and
+// properties files, since games disagree on how a boolean should be represented.
+// If unset, BooleanTrueFalse is used.
+type BooleanStyle string
+
+const (
+	// BooleanTrueFalse writes booleans as "true" or "false".
+	BooleanTrueFalse BooleanStyle = "truefalse"
+	// BooleanOnOff writes booleans as "on" or "off".
+	BooleanOnOff BooleanStyle = "onoff"
+	// BooleanYesNo writes booleans as "yes" or "no".
+	BooleanYesNo BooleanStyle = "yesno"
+	// BooleanOneZero writes booleans as "1" or "0".
+	BooleanOneZero BooleanStyle = "10"
+)
+
+// UnmarshalJSON allows the "10" style to be provided as either a number or a
+// string.
+func (b *BooleanStyle) UnmarshalJSON(data []byte) error {
+	if len(data) > 0 && data[0] != '"' {
+		if string(data) != string(BooleanOneZero) {
+			return errors.Errorf("parser: invalid boolean style: %s", data)
+		}
+		*b = BooleanOneZero
+		return nil
+	}
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	*b = BooleanStyle(s)
+	return nil
+}
+
+// Format returns the representation of the boolean value in this style.
+func (b BooleanStyle) Format(v bool) string {
+	t, f := "true", "false"
+	switch b {
+	case BooleanOnOff:
+		t, f = "on", "off"
+	case BooleanYesNo:
+		t, f = "yes", "no"
+	case BooleanOneZero:
+		t, f = "1", "0"
+	}
+	if v {
+		return t
+	}
+	return f
+}
+
 func (cp ConfigurationParser) String() string {
 	return string(cp)
 }
@@ -108,10 +291,82 @@ type ConfigurationFile struct {
 	Parser          ConfigurationParser            `json:"parser"`
 	Replace         []ConfigurationFileReplacement `json:"replace"`
 	AllowCreateFile bool                           `json:"create_file"` // assumed true by unmarshal as it was the original behaviour
+	JsonFormat      JsonFormat                     `json:"json_format"`
+	XmlIndent       XmlIndent                      `json:"xml_indent"`
+
+	// MergePatch is a JSON merge patch (RFC 7386) that is applied to JSON and YAML
+	// files before any of the replacements. This allows many values, including
+	// nested ones, to be set at once and keys to be removed by setting them to null.
+	MergePatch json.RawMessage `json:"merge_patch"`
+
+	// Schema is a JSON Schema that JSON and YAML files must match once all the
+	// replacements have been applied. If the file does not match, parsing fails
+	// with a SchemaError listing the problems rather than leaving the server with
+	// an invalid configuration. References to definitions within the schema are
+	// supported, but references to other documents are not.
+	Schema json.RawMessage `json:"schema"`
+
+	// Root is the directory that the configuration file must be within, typically
+	// the data directory of the server. When set, the parser refuses to touch a
+	// file that resolves to a location outside of this directory.
+	Root string `json:"-"`
+
+	// IniDelimiter is the delimiter between keys and values used when reading and
+	// writing ini files. If unset either "=" or ":" is accepted when reading the
+	// file, and "=" is always used when writing it.
+	IniDelimiter string `json:"ini_delimiter"`
+
+	// IniRequireSection prevents any values from being written to the default
+	// section of an ini file, for tools that require every key to be within a
+	// named section. Every match must then include the section of the key.
+	IniRequireSection bool `json:"ini_require_section"`
+
+	// RawUtf8 causes UTF-8 characters in properties files to be written as-is rather
+	// than being escaped, for games that cannot handle the escaped representation.
+	RawUtf8 bool `json:"raw_utf8"`
+
+	// CommentPrefix is the marker that starts a comment line in a properties file,
+	// for games that use something like ";" or "//" rather than "#". Comments at the
+	// start of the file are kept, any others are removed. Defaults to "#".
+	CommentPrefix string `json:"comment_prefix"`
+
+	// BooleanStyle is how replacements with a boolean value are written to ini and
+	// properties files. Defaults to "truefalse".
+	BooleanStyle BooleanStyle `json:"boolean_style"`
+
+	// JsonStream causes the replacements for a JSON file to be applied by editing
+	// the targeted keys in place rather than decoding the entire document, which
+	// uses far less memory for large files. The rest of the file, including its
+	// formatting, is left untouched and json_format is ignored. If any replacement
+	// uses a wildcard, array, or predicate match, or the file has a merge patch,
+	// the file is parsed as usual.
+	JsonStream bool `json:"json_stream"`
+
+	// Recursive causes the replacements to also be applied to the files within any
+	// subdirectories when the file name refers to a directory. Otherwise only the
+	// files directly within the directory are parsed.
+	Recursive bool `json:"recursive"`
+
+	// Fingerprint is the fingerprint of the file from the last time it was parsed.
+	// When set, parsing is skipped if neither the file nor its replacements have
+	// changed since then. It is only used, and updated each time the file is parsed,
+	// when skipping unchanged configuration files is enabled for the node.
+	Fingerprint string `json:"-"`
+
+	// The definition the configuration file was unmarshaled from, used when
+	// computing the fingerprint of the file.
+	definition []byte
 
 	// Tracks TurboWings' configuration so that we can quickly get values
 	// out of it when variables request it.
 	configuration []byte
+
+	// Whether the daemon is running in debug mode, used to determine if more
+	// verbose warnings should be logged while parsing.
+	debug bool
+
+	// Tracks the outcome of each replacement while the file is being parsed.
+	result ParseResult
 }
 
 // UnmarshalJSON is a custom unmarshaler for configuration files. If there is an
@@ -124,6 +379,8 @@ func (f *ConfigurationFile) UnmarshalJSON(data []byte) error {
 		return err
 	}
 
+	f.definition = append([]byte(nil), data...)
+
 	if err := json.Unmarshal(*m["file"], &f.FileName); err != nil {
 		return err
 	}
@@ -138,6 +395,15 @@ func (f *ConfigurationFile) UnmarshalJSON(data []byte) error {
 		f.Replace = []ConfigurationFileReplacement{}
 	}
 
+	// Warn about any replacement matches that are obviously malformed so that they
+	// are caught when the egg is loaded rather than when the file is parsed.
+	for _, r := range f.Replace {
+		if err := validateMatch(f.Parser, r.Match); err != nil {
+			log.WithFields(log.Fields{"file": f.FileName, "parser": f.Parser.String(), "match": r.Match, "error": err}).
+				Warn("configuration file replacement match is not well-formed")
+		}
+	}
+
 	// test if "create_file" exists, if not just assume true
 	if val, exists := m["create_file"]; exists && val != nil {
 		if err := json.Unmarshal(*val, &f.AllowCreateFile); err != nil {
@@ -149,6 +415,82 @@ func (f *ConfigurationFile) UnmarshalJSON(data []byte) error {
 		f.AllowCreateFile = true
 	}
 
+	if val, exists := m["json_format"]; exists && val != nil {
+		if err := json.Unmarshal(*val, &f.JsonFormat); err != nil {
+			log.WithField("file", f.FileName).WithField("error", err).Warn("json_format unmarshal failed")
+			f.JsonFormat = ""
+		}
+	}
+
+	if val, exists := m["xml_indent"]; exists && val != nil {
+		if err := json.Unmarshal(*val, &f.XmlIndent); err != nil {
+			log.WithField("file", f.FileName).WithField("error", err).Warn("xml_indent unmarshal failed")
+			f.XmlIndent = ""
+		}
+	}
+
+	if val, exists := m["merge_patch"]; exists && val != nil && string(*val) != "null" {
+		f.MergePatch = *val
+	}
+
+	if val, exists := m["schema"]; exists && val != nil && string(*val) != "null" {
+		f.Schema = *val
+		switch f.Parser {
+		case Json, Jsonc, Yaml, "yml":
+		default:
+			log.WithField("file", f.FileName).WithField("parser", f.Parser.String()).Warn("schema is only used for json and yaml configuration files")
+		}
+	}
+
+	if val, exists := m["ini_delimiter"]; exists && val != nil {
+		if err := json.Unmarshal(*val, &f.IniDelimiter); err != nil {
+			log.WithField("file", f.FileName).WithField("error", err).Warn("ini_delimiter unmarshal failed")
+			f.IniDelimiter = ""
+		}
+	}
+
+	if val, exists := m["ini_require_section"]; exists && val != nil {
+		if err := json.Unmarshal(*val, &f.IniRequireSection); err != nil {
+			log.WithField("file", f.FileName).WithField("error", err).Warn("ini_require_section unmarshal failed")
+			f.IniRequireSection = false
+		}
+	}
+
+	if val, exists := m["recursive"]; exists && val != nil {
+		if err := json.Unmarshal(*val, &f.Recursive); err != nil {
+			log.WithField("file", f.FileName).WithField("error", err).Warn("recursive unmarshal failed")
+			f.Recursive = false
+		}
+	}
+
+	if val, exists := m["raw_utf8"]; exists && val != nil {
+		if err := json.Unmarshal(*val, &f.RawUtf8); err != nil {
+			log.WithField("file", f.FileName).WithField("error", err).Warn("raw_utf8 unmarshal failed")
+			f.RawUtf8 = false
+		}
+	}
+
+	if val, exists := m["comment_prefix"]; exists && val != nil {
+		if err := json.Unmarshal(*val, &f.CommentPrefix); err != nil {
+			log.WithField("file", f.FileName).WithField("error", err).Warn("comment_prefix unmarshal failed")
+			f.CommentPrefix = ""
+		}
+	}
+
+	if val, exists := m["boolean_style"]; exists && val != nil {
+		if err := json.Unmarshal(*val, &f.BooleanStyle); err != nil {
+			log.WithField("file", f.FileName).WithField("error", err).Warn("boolean_style unmarshal failed")
+			f.BooleanStyle = ""
+		}
+	}
+
+	if val, exists := m["json_stream"]; exists && val != nil {
+		if err := json.Unmarshal(*val, &f.JsonStream); err != nil {
+			log.WithField("file", f.FileName).WithField("error", err).Warn("json_stream unmarshal failed")
+			f.JsonStream = false
+		}
+	}
+
 	return nil
 }
 
@@ -158,6 +500,26 @@ type ConfigurationFileReplacement struct {
 	Match       string       `json:"match"`
 	IfValue     string       `json:"if_value"`
 	ReplaceWith ReplaceValue `json:"replace_with"`
+
+	// Append causes the value to be added to the end of an existing array rather
+	// than replacing it. This only applies to JSON and YAML files.
+	Append bool `json:"append"`
+
+	// Deduplicate prevents a value from being appended to an array if the array
+	// already contains that value.
+	Deduplicate bool `json:"deduplicate"`
+
+	// Merge causes the value, which must be an object or a string containing a YAML
+	// or JSON document, to be deep merged into the existing value at the path rather
+	// than replacing it. Objects are merged recursively, arrays and other values are
+	// replaced, and a null value removes the key. This only applies to JSON and YAML
+	// files.
+	Merge bool `json:"merge"`
+
+	// Default is the value used in place of a {{config.*}} placeholder when the
+	// referenced configuration value cannot be found. When unset a failed lookup
+	// behaves as it always has.
+	Default *string `json:"default"`
 }
 
 // UnmarshalJSON handles unmarshaling the JSON representation into a struct that
@@ -197,45 +559,268 @@ func (cfr *ConfigurationFileReplacement) UnmarshalJSON(data []byte) error {
 		valueType: dt,
 	}
 
+	// Both of these are optional, and default to false when not provided.
+	if cfr.Append, err = jsonparser.GetBoolean(data, "append"); err != nil && err != jsonparser.KeyPathNotFoundError {
+		return err
+	}
+	if cfr.Deduplicate, err = jsonparser.GetBoolean(data, "deduplicate"); err != nil && err != jsonparser.KeyPathNotFoundError {
+		return err
+	}
+	if cfr.Merge, err = jsonparser.GetBoolean(data, "merge"); err != nil && err != jsonparser.KeyPathNotFoundError {
+		return err
+	}
+
+	// The default is optional, a null value is treated the same as it being missing.
+	dv, dvt, _, err := jsonparser.Get(data, "default")
+	if err != nil && err != jsonparser.KeyPathNotFoundError {
+		return err
+	}
+	if err == nil && dvt != jsonparser.Null {
+		d := string(dv)
+		if dvt == jsonparser.String {
+			if d, err = jsonparser.ParseString(dv); err != nil {
+				return err
+			}
+		}
+		cfr.Default = &d
+	}
+
 	return nil
 }
 
 // Parse parses a given configuration file and updates all the values within
 // as defined in the API response from the Panel.
 func (f *ConfigurationFile) Parse(file ufs.File) error {
-	//log.WithField("path", path).WithField("parser", f.Parser.String()).Debug("parsing server configuration file")
+	_, err := f.ParseWithResult(file)
+	return err
+}
 
-	// What the fuck is going on here?
-	if mb, err := json.Marshal(config.Get()); err != nil {
+// ParseWithResult parses the given configuration file in the same way as Parse,
+// but also returns a summary of which replacements were applied to the file. If
+// an error is encountered, any replacements that were not processed are counted
+// as having errored.
+func (f *ConfigurationFile) ParseWithResult(file ufs.File) (ParseResult, error) {
+	f.result = ParseResult{}
+	err := f.parseFile(file)
+	if err != nil {
+		f.result.Errored = max(len(f.Replace)-f.result.Total(), 0)
+	}
+	observeParse(f.Parser, f.result, err)
+	return f.result, err
+}
+
+// DryRun applies the replacements to the given configuration file in the same
+// way as ParseWithResult, but never writes the result back to the disk. This
+// allows the configuration files for a server to be checked without changing
+// them.
+func (f *ConfigurationFile) DryRun(file ufs.File) (ParseResult, error) {
+	f.result = ParseResult{}
+	input, err := f.readFile(file)
+	if err == nil {
+		_, err = f.ParseBytes(input)
+	}
+	if err != nil {
+		f.result.Errored = max(len(f.Replace)-f.result.Total(), 0)
+	}
+	return f.result, err
+}
+
+// parseFile reads the entire file into memory, applies the replacements to it
+// and then writes the result back to the disk. The file is left untouched if
+// none of the replacements resulted in a change to the contents.
+func (f *ConfigurationFile) parseFile(file ufs.File) error {
+	if err := f.checkWithinRoot(file); err != nil {
+		return err
+	}
+
+	input, err := f.readFile(file)
+	if err != nil {
+		return err
+	}
+	// Computing the fingerprint requires hashing the entire configuration for the
+	// node, so don't bother unless it is actually going to be used.
+	skip := config.Get().System.SkipUnchangedConfigurationFiles
+	if skip && f.Fingerprint != "" {
+		if fp, err := f.fingerprint(input); err != nil {
+			return err
+		} else if fp == f.Fingerprint {
+			f.result.Unchanged = true
+			return nil
+		}
+	}
+	out, err := f.ParseBytes(input)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(input, out) {
+		if err := ufs.Rewrite(file, out); err != nil {
+			return err
+		}
+	}
+	if skip {
+		f.Fingerprint, err = f.fingerprint(out)
+	}
+	return err
+}
+
+// fingerprint returns a hash of the definition of the configuration file, the
+// configuration that the replacements are able to read values from, and the
+// given contents of the file. If any of these change the fingerprint changes,
+// so the file needs to be parsed again. An empty string is returned if the file
+// was not unmarshaled from a definition.
+func (f *ConfigurationFile) fingerprint(content []byte) (string, error) {
+	if f.definition == nil {
+		return "", nil
+	}
+	cfg, err := json.Marshal(config.Get())
+	if err != nil {
+		return "", err
+	}
+	h := sha256.New()
+	for _, b := range [][]byte{f.definition, cfg, content} {
+		h.Write(b)
+		h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// checkWithinRoot ensures that the file resolves to a location within the root
+// directory of the configuration file, if one is set. The filesystem for the server
+// should already prevent this, but as configuration files are written to without
+// any user interaction it is checked again before anything is written.
+func (f *ConfigurationFile) checkWithinRoot(file ufs.File) error {
+	if f.Root == "" {
+		return nil
+	}
+	root, err := filepath.EvalSymlinks(f.Root)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	p, err := ufs.ResolvePath(file)
+	if err != nil {
 		return err
+	}
+	if rel, err := filepath.Rel(root, p); err != nil || rel == ".." || strings.HasPrefix(rel, "../") {
+		return &ufs.PathError{Op: "parse", Path: file.Name(), Err: ufs.ErrBadPathResolution}
+	}
+	return nil
+}
+
+// readFile reads the entire contents of the file into memory, refusing to do so
+// if the file is larger than the configured limit.
+func (f *ConfigurationFile) readFile(file ufs.File) ([]byte, error) {
+	// Avoid reading huge files entirely into memory, most of the parsers below need
+	// the complete file contents to work with.
+	if config.Get().System.MaxConfigFileSize > 0 {
+		st, err := file.Stat()
+		if err != nil {
+			return nil, err
+		}
+		if err := checkSize(st.Size()); err != nil {
+			return nil, err
+		}
+	}
+	return io.ReadAll(file)
+}
+
+// checkSize returns an error if a configuration file of the given size in bytes
+// is larger than the configured limit.
+func checkSize(size int64) error {
+	if limit := config.Get().System.MaxConfigFileSize; limit > 0 && size > limit*1024*1024 {
+		return errors.WithStack(fmt.Errorf("%w: %d bytes exceeds the limit of %d MiB", ErrFileTooLarge, size, limit))
+	}
+	return nil
+}
+
+// ParseBytes applies the replacements for the configuration file to the given
+// contents entirely in memory and returns the updated contents. This allows
+// callers that already have the contents of a file available to use the parsers
+// without needing to go through the disk.
+func (f *ConfigurationFile) ParseBytes(input []byte) ([]byte, error) {
+	// What the fuck is going on here?
+	cfg := config.Get()
+	if mb, err := json.Marshal(cfg); err != nil {
+		return nil, err
 	} else {
 		f.configuration = mb
 	}
+	f.debug = cfg.Debug
+	f.result = ParseResult{}
+
+	// Gzip compressed files are decompressed before the replacements are applied
+	// and compressed again afterwards, this is based on the contents of the file
+	// rather than its extension.
+	compressed, original := f.decompressible(input), input
+	var header gzip.Header
+	if compressed {
+		var err error
+		if input, header, err = gunzip(input); err != nil {
+			return nil, err
+		}
+	}
+	decompressed := input
+
+	// Treat a file containing nothing but whitespace as being empty so that the
+	// structured parsers start from an empty document of their own type rather
+	// than failing to parse it. Plain text files are left exactly as they are.
+	if f.Parser != File && len(bytes.TrimSpace(input)) == 0 {
+		input = nil
+	}
 
-	var err error
+	out, err := f.parseBytes(input)
+	if err == nil {
+		err = f.validateSchema(out)
+	}
+	if err == nil && compressed {
+		// Return the original contents if nothing changed so that the file is not
+		// rewritten just because it was compressed differently.
+		if bytes.Equal(out, decompressed) {
+			out = original
+		} else {
+			out, err = gzipBytes(out, header)
+		}
+	}
+	return out, err
+}
 
+// parseBytes passes the input through to the parser for the file type.
+func (f *ConfigurationFile) parseBytes(input []byte) ([]byte, error) {
 	switch f.Parser {
 	case Properties:
-		err = f.parsePropertiesFile(file)
+		return f.parsePropertiesFile(input)
 	case File:
-		err = f.parseTextFile(file)
+		return f.parseTextFile(input)
 	case Yaml, "yml":
-		err = f.parseYamlFile(file)
+		return f.parseYamlFile(input)
 	case Json:
-		err = f.parseJsonFile(file)
+		return f.parseJsonFile(input)
+	case Jsonc:
+		return f.parseJsonFile(stripJsonComments(input))
 	case Ini:
-		err = f.parseIniFile(file)
+		return f.parseIniFile(input)
 	case Xml:
-		err = f.parseXmlFile(file)
+		return f.parseXmlFile(input)
+	case Csv:
+		return f.parseCsvFile(input, ',')
+	case Tsv:
+		return f.parseCsvFile(input, '\t')
 	}
-	return err
+	return nil, errors.WithStack(fmt.Errorf("%w: %s", ErrUnsupportedParser, f.Parser))
 }
 
 // Parses an xml file.
-func (f *ConfigurationFile) parseXmlFile(file ufs.File) error {
+func (f *ConfigurationFile) parseXmlFile(input []byte) ([]byte, error) {
+	// The entire document tree is held in memory while the replacements are applied,
+	// which uses many times more memory than the size of the file itself. Contents
+	// passed directly to ParseBytes never go through the file size check, so check
+	// the size again before building the tree.
+	if err := checkSize(int64(len(input))); err != nil {
+		return nil, err
+	}
+
 	doc := etree.NewDocument()
-	if _, err := doc.ReadFrom(file); err != nil {
-		return err
+	if err := doc.ReadFromBytes(input); err != nil {
+		return nil, newSyntaxError(err)
 	}
 
 	// If there is no root we should create a basic start to the file. This isn't required though,
@@ -247,22 +832,37 @@ func (f *ConfigurationFile) parseXmlFile(file ufs.File) error {
 	for i, replacement := range f.Replace {
 		value, err := f.LookupConfigurationValue(replacement)
 		if err != nil {
-			return err
+			return nil, newReplacementError(replacement.Match, err)
 		}
 
+		// An attribute of the element can be targeted directly by suffixing the path with
+		// the attribute name, such as "Configuration.Appenders.Console@target".
+		match, attr := splitXmlAttribute(replacement.Match)
+
 		// If this is the first item and there is no root element, create that root now and apply
-		// it for future use.
+		// it for future use. A match with a single segment, such as "port", creates the root
+		// element itself. If the first segment is not a plain element name, such as a wildcard
+		// or predicate, there is no way to know what the root element should be called.
 		if i == 0 && doc.Root() == nil {
-			parts := strings.SplitN(replacement.Match, ".", 2)
-			doc.SetRoot(doc.CreateElement(parts[0]))
+			root, _, _ := strings.Cut(match, ".")
+			if !xmlElementNameRegex.MatchString(root) {
+				return nil, newReplacementError(replacement.Match, errors.Errorf("parser: cannot create root element \"%s\" for empty xml document", root))
+			}
+			doc.SetRoot(doc.CreateElement(root))
 		}
 
-		path := "./" + strings.Replace(replacement.Match, ".", "/", -1)
+		path := "./" + strings.Replace(match, ".", "/", -1)
+		existed := doc.FindElement(path) != nil
+		if attr != "" {
+			existed = slices.ContainsFunc(doc.FindElements(path), func(e *etree.Element) bool {
+				return e.SelectAttr(attr) != nil
+			})
+		}
 
 		// If we're not doing a wildcard replacement go ahead and create the
 		// missing element if we cannot find it yet.
 		if !strings.Contains(path, "*") {
-			parts := strings.Split(replacement.Match, ".")
+			parts := strings.Split(match, ".")
 
 			// Set the initial element to be the root element, and then work from there.
 			element := doc.Root()
@@ -281,8 +881,15 @@ func (f *ConfigurationFile) parseXmlFile(file ufs.File) error {
 		}
 
 		// Iterate over the elements we found and update their values.
-		for _, element := range doc.FindElements(path) {
-			if xmlValueMatchRegex.MatchString(value) {
+		elements := doc.FindElements(path)
+		if len(elements) == 0 {
+			f.warnNoMatch(replacement.Match)
+		}
+		f.result.record(outcomeOf(len(elements) > 0, existed))
+		for _, element := range elements {
+			if attr != "" {
+				element.CreateAttr(attr, value)
+			} else if xmlValueMatchRegex.MatchString(value) {
 				k := xmlValueMatchRegex.ReplaceAllString(value, "$1")
 				v := xmlValueMatchRegex.ReplaceAllString(value, "$2")
 
@@ -293,29 +900,124 @@ func (f *ConfigurationFile) parseXmlFile(file ufs.File) error {
 		}
 	}
 
-	if _, err := file.Seek(0, io.SeekStart); err != nil {
-		return err
+	// Ensure the XML is indented properly.
+	f.indentXml(doc)
+
+	// Write the XML to the file.
+	return doc.WriteToBytes()
+}
+
+// indentXml indents the XML document using the configured indentation for the
+// file, falling back to two spaces if it is unset or invalid.
+func (f *ConfigurationFile) indentXml(doc *etree.Document) {
+	switch f.XmlIndent {
+	case XmlIndentPreserve:
+		return
+	case XmlIndentTabs:
+		doc.IndentTabs()
+		return
 	}
-	if err := file.Truncate(0); err != nil {
-		return err
+	if n, err := strconv.Atoi(string(f.XmlIndent)); err == nil && n >= 0 {
+		doc.Indent(n)
+		return
 	}
-
-	// Ensure the XML is indented properly.
 	doc.Indent(2)
+}
 
-	// Write the XML to the file.
-	if _, err := doc.WriteTo(file); err != nil {
-		return err
+// Parses a csv (or tsv) file. The match for each replacement is in the format of
+// "row.column", where row is the zero-indexed row in the file and column is either
+// the zero-indexed column or the name of a column in the header (first) row. Rows
+// and columns that do not exist yet are created as empty cells.
+func (f *ConfigurationFile) parseCsvFile(input []byte, delimiter rune) ([]byte, error) {
+	r := csv.NewReader(bytes.NewReader(input))
+	r.Comma = delimiter
+	r.FieldsPerRecord = -1
+	r.LazyQuotes = true
+	records, err := r.ReadAll()
+	if err != nil {
+		return nil, newSyntaxError(err)
 	}
-	return nil
+
+	for _, replacement := range f.Replace {
+		parts := strings.SplitN(replacement.Match, ".", 2)
+		if len(parts) != 2 {
+			log.WithFields(log.Fields{"file": f.FileName, "match": replacement.Match}).Warn("invalid csv match, expected \"row.column\"")
+			f.result.record(outcomeSkipped)
+			continue
+		}
+
+		row, err := strconv.Atoi(parts[0])
+		if err != nil || row < 0 {
+			log.WithFields(log.Fields{"file": f.FileName, "match": replacement.Match}).Warn("invalid csv match, row must be a non-negative number")
+			f.result.record(outcomeSkipped)
+			continue
+		}
+
+		col, err := strconv.Atoi(parts[1])
+		if err != nil {
+			col = -1
+			if len(records) > 0 {
+				col = slices.Index(records[0], parts[1])
+			}
+		}
+		if col < 0 {
+			f.warnNoMatch(replacement.Match)
+			f.result.record(outcomeSkipped)
+			continue
+		}
+
+		value, err := f.LookupConfigurationValue(replacement)
+		if err != nil {
+			return nil, newReplacementError(replacement.Match, err)
+		}
+
+		existed := row < len(records) && col < len(records[row])
+		for len(records) <= row {
+			records = append(records, []string{})
+		}
+		for len(records[row]) <= col {
+			records[row] = append(records[row], "")
+		}
+		if replacement.IfValue != "" && records[row][col] != replacement.IfValue {
+			f.result.record(outcomeSkipped)
+			continue
+		}
+		records[row][col] = value
+		f.result.record(outcomeOf(true, existed))
+	}
+
+	var b bytes.Buffer
+	w := csv.NewWriter(&b)
+	w.Comma = delimiter
+	if err := w.WriteAll(records); err != nil {
+		return nil, errors.Wrap(err, "parser: failed to encode csv file")
+	}
+	return b.Bytes(), nil
+}
+
+// formatBoolean returns the value of a replacement with a boolean value in the
+// boolean style of the file, any other values are returned unchanged.
+func (f *ConfigurationFile) formatBoolean(cfr ConfigurationFileReplacement, value string) string {
+	if cfr.ReplaceWith.Type() != jsonparser.Boolean {
+		return value
+	}
+	v, err := strconv.ParseBool(value)
+	if err != nil {
+		return value
+	}
+	return f.BooleanStyle.Format(v)
 }
 
 // Parses an ini file.
-func (f *ConfigurationFile) parseIniFile(file ufs.File) error {
-	// Wrap the file in a NopCloser so the ini package doesn't close the file.
-	cfg, err := ini.Load(io.NopCloser(file))
+func (f *ConfigurationFile) parseIniFile(input []byte) ([]byte, error) {
+	var opts ini.LoadOptions
+	if f.IniDelimiter != "" {
+		opts.KeyValueDelimiters = f.IniDelimiter
+		opts.KeyValueDelimiterOnWrite = f.IniDelimiter
+	}
+	cfg, err := ini.LoadSources(opts, input)
 	if err != nil {
-		return err
+		return nil, newSyntaxError(err)
 	}
 
 	for _, replacement := range f.Replace {
@@ -343,10 +1045,15 @@ func (f *ConfigurationFile) parseIniFile(file ufs.File) error {
 		}
 		path = append(path, string(v))
 
+		if len(path) == 1 && f.IniRequireSection {
+			return nil, newReplacementError(replacement.Match, errors.New("match must include the section of the key, values cannot be written to the default section"))
+		}
+
 		value, err := f.LookupConfigurationValue(replacement)
 		if err != nil {
-			return err
+			return nil, newReplacementError(replacement.Match, err)
 		}
+		value = f.formatBoolean(replacement, value)
 
 		k := path[0]
 		s := cfg.Section("")
@@ -361,7 +1068,7 @@ func (f *ConfigurationFile) parseIniFile(file ufs.File) error {
 		if s == nil {
 			s, err = cfg.NewSection(path[0])
 			if err != nil {
-				return err
+				return nil, newReplacementError(replacement.Match, err)
 			}
 		}
 
@@ -369,65 +1076,89 @@ func (f *ConfigurationFile) parseIniFile(file ufs.File) error {
 		// create it in the section.
 		if s.HasKey(k) {
 			s.Key(k).SetValue(value)
+			f.result.record(outcomeUpdated)
 		} else {
 			if _, err := s.NewKey(k, value); err != nil {
-				return err
+				return nil, newReplacementError(replacement.Match, err)
 			}
+			f.result.record(outcomeCreated)
 		}
 	}
 
-	if _, err := file.Seek(0, io.SeekStart); err != nil {
-		return err
-	}
-	if err := file.Truncate(0); err != nil {
-		return err
-	}
-
-	if _, err := cfg.WriteTo(file); err != nil {
-		return err
+	var b bytes.Buffer
+	if _, err := cfg.WriteTo(&b); err != nil {
+		return nil, err
 	}
-	return nil
+	return b.Bytes(), nil
 }
 
 // Parses a json file updating any matching key/value pairs. If a match is not found, the
 // value is set regardless in the file. See the commentary in parseYamlFile for more details
 // about what is happening during this process.
-func (f *ConfigurationFile) parseJsonFile(file ufs.File) error {
-	b, err := io.ReadAll(file)
-	if err != nil {
-		return err
+func (f *ConfigurationFile) parseJsonFile(input []byte) ([]byte, error) {
+	// An empty file is treated as an empty object so that the replacements can
+	// populate it from scratch.
+	if len(input) == 0 {
+		input = []byte("{}")
 	}
 
-	data, err := f.IterateOverJson(b)
-	if err != nil {
-		return err
+	if f.JsonStream {
+		if data, ok, err := f.streamJsonFile(input); err != nil || ok {
+			return data, err
+		}
 	}
 
-	if _, err := file.Seek(0, io.SeekStart); err != nil {
-		return err
+	data, err := f.IterateOverJson(input)
+	if err != nil {
+		return nil, err
 	}
-	if err := file.Truncate(0); err != nil {
-		return err
+
+	if f.writeCompactJson(input) {
+		return data.Bytes(), nil
 	}
+	return data.BytesIndent("", "    "), nil
+}
 
-	// Write the data to the file.
-	if _, err := io.Copy(file, bytes.NewReader(data.BytesIndent("", "    "))); err != nil {
-		return errors.Wrap(err, "parser: failed to write properties file to disk")
+// writeCompactJson returns true if a JSON file should be written back to the disk
+// without any indentation. When set to auto-detect, the file is considered to be
+// minified if the original contents did not span more than a single line.
+func (f *ConfigurationFile) writeCompactJson(original []byte) bool {
+	switch f.JsonFormat {
+	case JsonFormatCompact:
+		return true
+	case JsonFormatAuto:
+		original = bytes.TrimSpace(original)
+		return len(original) > 0 && !bytes.ContainsRune(original, '\n')
+	default:
+		return false
 	}
-	return nil
 }
 
 // Parses a yaml file and updates any matching key/value pairs before persisting
 // it back to the disk.
-func (f *ConfigurationFile) parseYamlFile(file ufs.File) error {
-	b, err := io.ReadAll(file)
-	if err != nil {
-		return err
+//
+// The replacements are applied to a JSON representation of the file so that the
+// same matching logic is used for both formats, and the changes are then merged
+// back into the original YAML node tree. Only the values that actually changed are
+// touched, so anchors, aliases, and comments in the file are preserved.
+func (f *ConfigurationFile) parseYamlFile(input []byte) ([]byte, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(input, &doc); err != nil {
+		return nil, newSyntaxError(err)
+	}
+	// An empty file has no document at all, and a document containing only "null"
+	// (or "~") would otherwise be written back to the disk as "null".
+	if doc.Kind != yaml.DocumentNode || len(doc.Content) == 0 {
+		doc = yaml.Node{Kind: yaml.DocumentNode, Content: []*yaml.Node{{}}}
+	}
+	root := doc.Content[0]
+	if root.Kind == 0 || (root.Kind == yaml.ScalarNode && root.Tag == "!!null") {
+		*root = yaml.Node{Kind: yaml.MappingNode, Tag: "!!map", HeadComment: root.HeadComment, FootComment: root.FootComment}
 	}
 
-	i := make(map[string]interface{})
-	if err := yaml.Unmarshal(b, &i); err != nil {
-		return err
+	var i interface{}
+	if err := root.Decode(&i); err != nil {
+		return nil, err
 	}
 
 	// Unmarshal the yaml data into a JSON interface such that we can work with
@@ -435,47 +1166,50 @@ func (f *ConfigurationFile) parseYamlFile(file ufs.File) error {
 	// makes working with unknown JSON significantly easier.
 	jsonBytes, err := json.Marshal(dyno.ConvertMapI2MapS(i))
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	// Now that the data is converted, treat it just like JSON and pass it to the
 	// iterator function to update values as necessary.
 	data, err := f.IterateOverJson(jsonBytes)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	// Remarshal the JSON into YAML format before saving it back to the disk.
-	marshaled, err := yaml.Marshal(data.Data())
-	if err != nil {
-		return err
+	if err := mergeYamlNode(root, data.Data()); err != nil {
+		return nil, errors.WithMessage(err, "parser: failed to apply changes to yaml document")
 	}
 
-	if _, err := file.Seek(0, io.SeekStart); err != nil {
-		return err
+	// Write the document back out using the same indentation as the original file
+	// so that the only differences are the values that were actually changed.
+	var b bytes.Buffer
+	enc := yaml.NewEncoder(&b)
+	enc.SetIndent(detectYamlIndent(input))
+	if err := enc.Encode(&doc); err != nil {
+		return nil, errors.WithStack(err)
 	}
-	if err := file.Truncate(0); err != nil {
-		return err
+	if err := enc.Close(); err != nil {
+		return nil, errors.WithStack(err)
 	}
-
-	// Write the data to the file.
-	if _, err := io.Copy(file, bytes.NewReader(marshaled)); err != nil {
-		return errors.Wrap(err, "parser: failed to write properties file to disk")
-	}
-	return nil
+	return b.Bytes(), nil
 }
 
 // Parses a text file using basic find and replace. This is a highly inefficient method of
 // scanning a file and performing a replacement. You should attempt to use anything other
 // than this function where possible.
-func (f *ConfigurationFile) parseTextFile(file ufs.File) error {
+func (f *ConfigurationFile) parseTextFile(input []byte) ([]byte, error) {
+	// Strip any byte order mark so that it does not prevent the first line from
+	// matching, it is added back when the file is written.
+	content, enc := decodeText(input)
+
 	b := bytes.NewBuffer(nil)
-	s := bufio.NewScanner(file)
+	s := bufio.NewScanner(bytes.NewReader(content))
+	matched := make([]bool, len(f.Replace))
 	var replaced bool
 	for s.Scan() {
 		line := s.Bytes()
 		replaced = false
-		for _, replace := range f.Replace {
+		for i, replace := range f.Replace {
 			// If this line doesn't match what we expect for the replacement, move on to the next
 			// line. Otherwise, update the line to have the replacement value.
 			if !bytes.HasPrefix(line, []byte(replace.Match)) {
@@ -483,25 +1217,21 @@ func (f *ConfigurationFile) parseTextFile(file ufs.File) error {
 			}
 			b.Write(replace.ReplaceWith.Bytes())
 			replaced = true
+			matched[i] = true
 		}
 		if !replaced {
 			b.Write(line)
 		}
 		b.WriteByte('\n')
 	}
-
-	if _, err := file.Seek(0, io.SeekStart); err != nil {
-		return err
-	}
-	if err := file.Truncate(0); err != nil {
-		return err
+	for i, m := range matched {
+		if !m {
+			f.warnNoMatch(f.Replace[i].Match)
+		}
+		f.result.record(outcomeOf(m, true))
 	}
 
-	// Write the data to the file.
-	if _, err := io.Copy(file, b); err != nil {
-		return errors.Wrap(err, "parser: failed to write properties file to disk")
-	}
-	return nil
+	return encodeText(b.Bytes(), enc), nil
 }
 
 // parsePropertiesFile parses a properties file and updates the values within it
@@ -530,51 +1260,72 @@ func (f *ConfigurationFile) parseTextFile(file ufs.File) error {
 //
 // @see https://github.com/pterodactyl/panel/issues/2308 (original)
 // @see https://github.com/pterodactyl/panel/issues/3009 ("bug" introduced as result)
-func (f *ConfigurationFile) parsePropertiesFile(file ufs.File) error {
-	b, err := io.ReadAll(file)
-	if err != nil {
-		return err
+//
+// Since there is no way to tell which representation a game expects, the file can
+// opt in to writing the raw UTF-8 value by setting "raw_utf8". Values are still
+// escaped by default.
+//
+// Values that are continued across multiple lines with a trailing backslash are
+// read as a single logical value, and are always written back out on a single
+// line. Any line breaks within a value are escaped as "\n" so that the value
+// can never be split across lines when it is written.
+//
+// Comments are lines starting with "#" unless a different "comment_prefix" is set
+// for the file. Lines starting with a custom prefix are removed before the file is
+// loaded, otherwise they would be read as keys.
+func (f *ConfigurationFile) parsePropertiesFile(input []byte) ([]byte, error) {
+	b, enc := decodeText(input)
+	prefix := []byte(f.CommentPrefix)
+	if len(prefix) == 0 {
+		prefix = []byte("#")
 	}
 
 	s := bytes.NewBuffer(nil)
 	scanner := bufio.NewScanner(bytes.NewReader(b))
 	// Scan until we hit a line that is not a comment that actually has content
-	// on it. Keep appending the comments until that time.
+	// on it. Keep appending the comments until that time. A comment is never
+	// continued onto the next line, even if it ends with a backslash.
 	for scanner.Scan() {
 		text := scanner.Bytes()
-		if len(text) > 0 && text[0] != '#' {
+		if len(text) > 0 && !bytes.HasPrefix(text, prefix) {
 			break
 		}
 		s.Write(text)
 		s.WriteByte('\n')
 	}
 	if err := scanner.Err(); err != nil {
-		return errors.WithStackIf(err)
+		return nil, errors.WithStackIf(err)
+	}
+	if string(prefix) != "#" && string(prefix) != "!" {
+		b = stripCommentLines(b, prefix)
 	}
 
 	p, err := properties.Load(b, properties.UTF8)
 	if err != nil {
-		return errors.Wrap(err, "parser: could not load properties file for configuration update")
+		return nil, newSyntaxError(err)
 	}
 
 	// Replace any values that need to be replaced.
 	for _, replace := range f.Replace {
 		data, err := f.LookupConfigurationValue(replace)
 		if err != nil {
-			return errors.Wrap(err, "parser: failed to lookup configuration value")
+			return nil, newReplacementError(replace.Match, errors.WithMessage(err, "failed to lookup configuration value"))
 		}
+		data = f.formatBoolean(replace, data)
 
 		v, ok := p.Get(replace.Match)
 		// Don't attempt to replace the value if we're looking for a specific value and
 		// it does not match. If there was no match at all in the file for this key but
 		// we're doing an IfValue match, do nothing.
 		if replace.IfValue != "" && (!ok || (ok && v != replace.IfValue)) {
+			f.result.record(outcomeSkipped)
 			continue
 		}
 
 		if _, _, err := p.Set(replace.Match, data); err != nil {
-			return errors.Wrap(err, "parser: failed to set replacement value")
+			return nil, newReplacementError(replace.Match, err)
 		}
+		f.result.record(outcomeOf(true, ok))
 	}
 
 	// Add the new file content to the string builder.
@@ -587,19 +1338,26 @@ func (f *ConfigurationFile) parsePropertiesFile(file ufs.File) error {
 		//
 		// See the docblock for this function for more details, do not change this
 		// or you'll cause a flood of new issue reports no one wants to deal with.
-		s.WriteString(key + "=" + strings.Trim(strconv.QuoteToASCII(value), "\"") + "\n")
+		quoted := strconv.QuoteToASCII(value)
+		if f.RawUtf8 {
+			// Control characters and line breaks are still escaped so the value
+			// remains on a single line.
+			quoted = strconv.Quote(value)
+		}
+		s.WriteString(key + "=" + strings.Trim(quoted, "\"") + "\n")
 	}
 
-	if _, err := file.Seek(0, io.SeekStart); err != nil {
-		return err
-	}
-	if err := file.Truncate(0); err != nil {
-		return err
-	}
+	return encodeText(s.Bytes(), enc), nil
+}
 
-	// Write the data to the file.
-	if _, err := io.Copy(file, s); err != nil {
-		return errors.Wrap(err, "parser: failed to write properties file to disk")
+// stripCommentLines removes every line that starts with the given comment prefix,
+// ignoring any leading whitespace, leaving an empty line in its place.
+func stripCommentLines(b []byte, prefix []byte) []byte {
+	lines := bytes.Split(b, []byte("\n"))
+	for i, l := range lines {
+		if bytes.HasPrefix(bytes.TrimLeft(l, " \t\f"), prefix) {
+			lines[i] = nil
+		}
 	}
-	return nil
+	return bytes.Join(lines, []byte("\n"))
 }
@@ -0,0 +1,966 @@
+package parser
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"emperror.dev/errors"
+	. "github.com/franela/goblin"
+	"github.com/goccy/go-json"
+
+	"github.com/IvanX77/turbowings/config"
+	"github.com/IvanX77/turbowings/internal/ufs"
+)
+
+// newConfigurationFile unmarshals a configuration file definition in the same
+// format the Panel sends it through.
+func newConfigurationFile(t testing.TB, parser string, replace string) ConfigurationFile {
+	var f ConfigurationFile
+	data := fmt.Sprintf(`{"file":"config","parser":%q,"replace":%s}`, parser, replace)
+	if err := json.Unmarshal([]byte(data), &f); err != nil {
+		t.Fatal(err)
+	}
+	return f
+}
+
+// openFile writes the given contents to a temporary file and opens it for
+// reading and writing.
+func openFile(t testing.TB, contents []byte) ufs.File {
+	p := filepath.Join(t.TempDir(), "config")
+	if err := os.WriteFile(p, contents, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.OpenFile(p, os.O_RDWR, 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = f.Close() })
+	return f
+}
+
+// readFile returns the contents of the file from the beginning.
+func readFile(t testing.TB, file ufs.File) string {
+	b, err := os.ReadFile(file.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
+
+func TestConfigurationFile_Parse(t *testing.T) {
+	g := Goblin(t)
+
+	g.Describe("Parse", func() {
+		g.BeforeEach(func() {
+			config.Set(&config.Configuration{
+				AuthenticationToken: "abc",
+				System: config.SystemConfiguration{
+					MaxConfigFileSize: 1,
+				},
+			})
+		})
+
+		g.It("updates values in a json file", func() {
+			f := newConfigurationFile(t, Json, `[{"match":"server.port","replace_with":"25565"}]`)
+			file := openFile(t, []byte(`{"server":{"port":1}}`))
+
+			err := f.Parse(file)
+			g.Assert(err).IsNil()
+			g.Assert(readFile(t, file)).Equal("{\n    \"server\": {\n        \"port\": 25565\n    }\n}")
+		})
+
+		g.It("parses contents in memory", func() {
+			f := newConfigurationFile(t, Properties, `[{"match":"server-port","replace_with":"25565"}]`)
+
+			out, err := f.ParseBytes([]byte("server-port=1\nmotd=hello\n"))
+			g.Assert(err).IsNil()
+			g.Assert(string(out)).Equal("server-port=25565\nmotd=hello\n")
+		})
+
+		g.It("treats empty files as an empty document", func() {
+			for parser, expected := range map[ConfigurationParser]string{
+				Json:       "{\n    \"server\": {\n        \"port\": 25565\n    }\n}",
+				Yaml:       "server:\n    port: 25565\n",
+				Ini:        "[server]\nport = 25565\n",
+				Properties: "server.port=25565\n",
+			} {
+				for _, input := range []string{"", " \n\t\n", "null"} {
+					if input == "null" && parser != Yaml && parser != Json {
+						continue
+					}
+					f := newConfigurationFile(t, string(parser), `[{"match":"server.port","replace_with":"25565"}]`)
+
+					out, err := f.ParseBytes([]byte(input))
+					g.Assert(err).IsNil(string(parser))
+					g.Assert(string(out)).Equal(expected, string(parser))
+				}
+			}
+		})
+
+		g.It("updates values in a jsonc file", func() {
+			f := newConfigurationFile(t, Jsonc, `[{"match":"server.port","replace_with":"25565"}]`)
+
+			out, err := f.ParseBytes([]byte(`{
+    // The server settings.
+    "server": {
+        "port": 1, /* the port */
+        "url": "http://localhost//",
+    },
+}`))
+			g.Assert(err).IsNil()
+			g.Assert(string(out)).Equal("{\n    \"server\": {\n        \"port\": 25565,\n        \"url\": \"http://localhost//\"\n    }\n}")
+		})
+
+		g.It("writes compact json when requested", func() {
+			f := newConfigurationFile(t, Json, `[{"match":"server.port","replace_with":"25565"}]`)
+			f.JsonFormat = JsonFormatCompact
+			file := openFile(t, []byte("{\n  \"server\": {\"port\": 1}\n}"))
+
+			err := f.Parse(file)
+			g.Assert(err).IsNil()
+			g.Assert(readFile(t, file)).Equal(`{"server":{"port":25565}}`)
+		})
+
+		g.It("matches the formatting of the original json file", func() {
+			f := newConfigurationFile(t, Json, `[{"match":"server.port","replace_with":"25565"}]`)
+			f.JsonFormat = JsonFormatAuto
+
+			file := openFile(t, []byte(`{"server":{"port":1}}`))
+			g.Assert(f.Parse(file)).IsNil()
+			g.Assert(readFile(t, file)).Equal(`{"server":{"port":25565}}`)
+
+			file = openFile(t, []byte("{\n\t\"server\": {\"port\": 1}\n}"))
+			g.Assert(f.Parse(file)).IsNil()
+			g.Assert(readFile(t, file)).Equal("{\n    \"server\": {\n        \"port\": 25565\n    }\n}")
+		})
+
+		g.It("edits json files in place when streaming is enabled", func() {
+			f := newConfigurationFile(t, Json, `[{"match":"server.port","replace_with":"25565"},{"match":"server.motd.text","replace_with":"hello"},{"match":"debug","replace_with":true}]`)
+			f.JsonStream = true
+
+			out, err := f.ParseBytes([]byte("{\n  \"server\": {\n    \"port\": 1,\n    \"name\": \"foo\"\n  },\n  \"debug\": false\n}\n"))
+			g.Assert(err).IsNil()
+			g.Assert(string(out)).Equal("{\n  \"server\": {\n    \"port\": 25565,\n    \"name\": \"foo\",\"motd\":{\"text\":\"hello\"}\n  },\n  \"debug\": true\n}\n")
+			g.Assert(f.result.Updated).Equal(2)
+			g.Assert(f.result.Created).Equal(1)
+		})
+
+		g.It("falls back to parsing the entire json file when a replacement cannot be streamed", func() {
+			for _, replace := range []string{
+				`[{"match":"server.port","replace_with":"25565"},{"match":"worlds.*.seed","replace_with":"1"}]`,
+				`[{"match":"server.port","replace_with":"25565"},{"match":"worlds[0].seed","replace_with":"1"}]`,
+				`[{"match":"server.port","replace_with":"25565"},{"match":"ops","replace_with":"notch","append":true}]`,
+			} {
+				plain := newConfigurationFile(t, Json, replace)
+				expected, err := plain.ParseBytes([]byte(`{"server":{"port":1},"worlds":[{"seed":0}]}`))
+				g.Assert(err).IsNil()
+
+				f := newConfigurationFile(t, Json, replace)
+				f.JsonStream = true
+				out, err := f.ParseBytes([]byte(`{"server":{"port":1},"worlds":[{"seed":0}]}`))
+				g.Assert(err).IsNil(replace)
+				g.Assert(string(out)).Equal(string(expected), replace)
+			}
+		})
+
+		g.It("updates values in json array elements matching a predicate", func() {
+			f := newConfigurationFile(t, Json, `[{"match":"worlds[name=world].seed","replace_with":"1234"}]`)
+			file := openFile(t, []byte(`{"worlds":[{"name":"world","seed":0},{"name":"nether","seed":0}]}`))
+
+			err := f.Parse(file)
+			g.Assert(err).IsNil()
+
+			var out map[string][]map[string]interface{}
+			g.Assert(json.Unmarshal([]byte(readFile(t, file)), &out)).IsNil()
+			g.Assert(out["worlds"][0]["seed"]).Equal(float64(1234))
+			g.Assert(out["worlds"][1]["seed"]).Equal(float64(0))
+		})
+
+		g.It("appends values to a json array", func() {
+			f := newConfigurationFile(t, Json, `[{"match":"ops","replace_with":"notch","append":true,"deduplicate":true},{"match":"ops","replace_with":"jeb","append":true,"deduplicate":true}]`)
+			file := openFile(t, []byte(`{"ops":["notch"]}`))
+
+			err := f.Parse(file)
+			g.Assert(err).IsNil()
+
+			var out map[string][]string
+			g.Assert(json.Unmarshal([]byte(readFile(t, file)), &out)).IsNil()
+			g.Assert(out["ops"]).Equal([]string{"notch", "jeb"})
+		})
+
+		g.It("applies a merge patch to json and yaml files", func() {
+			var f ConfigurationFile
+			err := json.Unmarshal([]byte(`{"file":"config","parser":"json","replace":[{"match":"server.port","replace_with":"25565"}],"merge_patch":{"server":{"host":"0.0.0.0","debug":null},"tags":["a"]}}`), &f)
+			g.Assert(err).IsNil()
+
+			out, err := f.ParseBytes([]byte(`{"server":{"port":1,"debug":true},"tags":["x","y"]}`))
+			g.Assert(err).IsNil()
+			g.Assert(string(out)).Equal("{\n    \"server\": {\n        \"host\": \"0.0.0.0\",\n        \"port\": 25565\n    },\n    \"tags\": [\n        \"a\"\n    ]\n}")
+
+			f.Parser = Yaml
+			out, err = f.ParseBytes([]byte("# settings\nserver:\n  port: 1 # the port\n  debug: true\ntags: [x, y]\n"))
+			g.Assert(err).IsNil()
+			g.Assert(string(out)).Equal("# settings\nserver:\n  port: 25565 # the port\n  host: 0.0.0.0\ntags: [a]\n")
+		})
+
+		g.It("merges a document into the value at a path", func() {
+			f := newConfigurationFile(t, Json, `[{"match":"server","merge":true,"replace_with":{"host":"0.0.0.0","limits":{"players":20},"tags":["a"],"debug":null}}]`)
+
+			out, err := f.ParseBytes([]byte(`{"server":{"port":1,"debug":true,"limits":{"players":10,"view":8},"tags":["x","y"]}}`))
+			g.Assert(err).IsNil()
+			var res map[string]interface{}
+			g.Assert(json.Unmarshal(out, &res)).IsNil()
+			g.Assert(res["server"]).Equal(map[string]interface{}{
+				"port":   float64(1),
+				"host":   "0.0.0.0",
+				"limits": map[string]interface{}{"players": float64(20), "view": float64(8)},
+				"tags":   []interface{}{"a"},
+			})
+
+			f = newConfigurationFile(t, Yaml, `[{"match":"server.limits","merge":true,"replace_with":"players: 20\nspawn: true\n"}]`)
+			out, err = f.ParseBytes([]byte("server:\n  limits:\n    players: 10\n    view: 8\n"))
+			g.Assert(err).IsNil()
+			g.Assert(string(out)).Equal("server:\n  limits:\n    players: 20\n    view: 8\n    spawn: true\n")
+
+			f = newConfigurationFile(t, Json, `[{"match":"server","merge":true,"replace_with":"[1, 2]"}]`)
+			_, err = f.ParseBytes([]byte(`{"server":{}}`))
+			var rerr *ReplacementError
+			g.Assert(errors.As(err, &rerr)).IsTrue()
+		})
+
+		g.It("preserves anchors and aliases in yaml files", func() {
+			f := newConfigurationFile(t, Yaml, `[{"match":"servers.lobby.port","replace_with":"25566"}]`)
+
+			out, err := f.ParseBytes([]byte(`defaults: &defaults
+    motd: hello
+    port: 25565
+servers:
+    lobby:
+        <<: *defaults
+    survival: *defaults
+`))
+			g.Assert(err).IsNil()
+			g.Assert(string(out)).Equal(`defaults: &defaults
+    motd: hello
+    port: 25565
+servers:
+    lobby:
+        <<: *defaults
+        port: 25566
+    survival: *defaults
+`)
+		})
+
+		g.It("preserves comments in yaml files", func() {
+			f := newConfigurationFile(t, Yaml, `[{"match":"server.port","replace_with":"25565"},{"match":"server.host","replace_with":"0.0.0.0"}]`)
+
+			out, err := f.ParseBytes([]byte(`# Server settings
+server:
+  # don't change unless you know what you're doing
+  port: 1 # the port to bind to
+  host: "127.0.0.1"
+  # end of the server settings
+
+# end of file
+`))
+			g.Assert(err).IsNil()
+			g.Assert(string(out)).Equal(`# Server settings
+server:
+  # don't change unless you know what you're doing
+  port: 25565 # the port to bind to
+  host: "0.0.0.0"
+  # end of the server settings
+
+# end of file
+`)
+		})
+
+		g.It("sets attributes on xml elements", func() {
+			f := newConfigurationFile(t, Xml, `[{"match":"Configuration.Appenders.Console@target","replace_with":"SYSTEM_ERR"},{"match":"Configuration.Appenders.Console[@name='Console'].PatternLayout@pattern","replace_with":"%msg%n"}]`)
+
+			out, err := f.ParseBytes([]byte(`<Configuration><Appenders><Console name="Console" target="SYSTEM_OUT"><PatternLayout pattern="%d %msg%n"/></Console></Appenders></Configuration>`))
+			g.Assert(err).IsNil()
+			g.Assert(string(out)).Equal(`<Configuration>
+  <Appenders>
+    <Console name="Console" target="SYSTEM_ERR">
+      <PatternLayout pattern="%msg%n"/>
+    </Console>
+  </Appenders>
+</Configuration>
+`)
+		})
+
+		g.It("uses the replacement default when a config value does not exist", func() {
+			f := newConfigurationFile(t, Properties, `[
+				{"match":"token","replace_with":"{{config.missing_key}}","default":"none"},
+				{"match":"auth","replace_with":"Bearer {{config.token}}","default":"none"}
+			]`)
+
+			out, err := f.ParseBytes([]byte("token=1\nauth=2\n"))
+			g.Assert(err).IsNil()
+			g.Assert(string(out)).Equal("token=none\nauth=Bearer abc\n")
+		})
+
+		g.It("only uses the replacement default for the config values that do not exist", func() {
+			f := newConfigurationFile(t, Properties, `[
+				{"match":"auth","replace_with":"{{config.missing_key}}:{{config.token}}","default":"none"}
+			]`)
+
+			out, err := f.ParseBytes([]byte("auth=1\n"))
+			g.Assert(err).IsNil()
+			g.Assert(string(out)).Equal("auth=none:abc\n")
+		})
+
+		g.It("resolves the value of a replacement without parsing a file", func() {
+			var cfr ConfigurationFileReplacement
+			g.Assert(json.Unmarshal([]byte(`{"match":"auth","replace_with":"Bearer {{config.token}}"}`), &cfr)).IsNil()
+
+			value, err := ResolveReplacement(cfr)
+			g.Assert(err).IsNil()
+			g.Assert(value).Equal("Bearer abc")
+		})
+
+		g.It("reports the outcome of each parse to the metrics", func() {
+			m := &testMetrics{}
+			SetMetrics(m)
+			defer SetMetrics(nil)
+
+			f := newConfigurationFile(t, Properties, `[{"match":"server-port","replace_with":"25565"}]`)
+			_, err := f.ParseWithResult(openFile(t, []byte("server-port=1\n")))
+			g.Assert(err).IsNil()
+
+			f = newConfigurationFile(t, "toml", `[]`)
+			_, err = f.ParseWithResult(openFile(t, []byte("")))
+			g.Assert(err == nil).IsFalse()
+
+			config.Set(&config.Configuration{AuthenticationToken: "abc", System: config.SystemConfiguration{MaxConfigFileSize: 1}})
+			f = newConfigurationFile(t, Properties, `[{"match":"server-port","replace_with":"25565"}]`)
+			_, err = f.ParseWithResult(openFile(t, bytes.Repeat([]byte("a"), 2*1024*1024)))
+			g.Assert(errors.Is(err, ErrFileTooLarge)).IsTrue()
+
+			// Neither dry runs nor parsing contents directly are reported.
+			_, err = f.DryRun(openFile(t, []byte("server-port=1\n")))
+			g.Assert(err).IsNil()
+			_, err = f.ParseBytes([]byte("server-port=1\n"))
+			g.Assert(err).IsNil()
+
+			g.Assert(m.parsers).Equal([]ConfigurationParser{Properties, "toml", Properties})
+			g.Assert(m.applied).Equal(1)
+			g.Assert(m.errors).Equal(2)
+		})
+
+		g.It("does not write to the file during a dry run", func() {
+			f := newConfigurationFile(t, Properties, `[{"match":"server-port","replace_with":"25565"}]`)
+			file := openFile(t, []byte("server-port=1\n"))
+
+			res, err := f.DryRun(file)
+			g.Assert(err).IsNil()
+			g.Assert(res.Updated).Equal(1)
+			g.Assert(readFile(t, file)).Equal("server-port=1\n")
+
+			f = newConfigurationFile(t, Json, `[{"match":"server.port","replace_with":"25565"}]`)
+			_, err = f.DryRun(openFile(t, []byte("{broken")))
+			g.Assert(errors.Is(err, ErrParseSyntax)).IsTrue()
+		})
+
+		g.It("keeps the configured delimiter in ini files", func() {
+			f := newConfigurationFile(t, Ini, `[{"match":"server.port","replace_with":"25565"},{"match":"server.url","replace_with":"http://localhost"}]`)
+			f.IniDelimiter = ":"
+
+			out, err := f.ParseBytes([]byte("[server]\nport: 1\nurl: http://example.com\n"))
+			g.Assert(err).IsNil()
+			g.Assert(string(out)).Equal("[server]\nport : 25565\nurl  : http://localhost\n")
+		})
+
+		g.It("creates the root element of an empty xml file", func() {
+			f := newConfigurationFile(t, Xml, `[{"match":"port","replace_with":"25565"},{"match":"port@bind","replace_with":"0.0.0.0"}]`)
+
+			out, err := f.ParseBytes(nil)
+			g.Assert(err).IsNil()
+			g.Assert(string(out)).Equal("<?xml version=\"1.0\" encoding=\"utf-8\"?>\n<port bind=\"0.0.0.0\">25565</port>\n")
+
+			for _, match := range []string{"*", "", ".port", "Server[@name='x'].port"} {
+				f := newConfigurationFile(t, Xml, fmt.Sprintf(`[{"match":%q,"replace_with":"25565"}]`, match))
+
+				_, err := f.ParseBytes(nil)
+				var rerr *ReplacementError
+				g.Assert(errors.As(err, &rerr)).IsTrue(match)
+				g.Assert(rerr.Match).Equal(match)
+			}
+		})
+
+		g.It("uses the configured indentation for xml files", func() {
+			input := []byte("<server>\n\t<port>1</port>   <motd>hi</motd>\n</server>")
+			for indent, expected := range map[string]string{
+				`4`:          "<server>\n    <port>25565</port>\n    <motd>hi</motd>\n</server>\n",
+				`"tabs"`:     "<server>\n\t<port>25565</port>\n\t<motd>hi</motd>\n</server>\n",
+				`"preserve"`: "<server>\n\t<port>25565</port>   <motd>hi</motd>\n</server>",
+			} {
+				var f ConfigurationFile
+				err := json.Unmarshal([]byte(`{"file":"config","parser":"xml","replace":[{"match":"server.port","replace_with":"25565"}],"xml_indent":`+indent+`}`), &f)
+				g.Assert(err).IsNil()
+
+				out, err := f.ParseBytes(input)
+				g.Assert(err).IsNil()
+				g.Assert(string(out)).Equal(expected, indent)
+			}
+		})
+
+		g.It("updates cells in a csv file", func() {
+			f := newConfigurationFile(t, Csv, `[{"match":"1.name","replace_with":"Steve, Jr."},{"match":"2.0","replace_with":"3"}]`)
+			file := openFile(t, []byte("id,name\n1,Alex\n2,Notch\n"))
+
+			err := f.Parse(file)
+			g.Assert(err).IsNil()
+			g.Assert(readFile(t, file)).Equal("id,name\n1,\"Steve, Jr.\"\n3,Notch\n")
+		})
+
+		g.It("returns a summary of the applied replacements", func() {
+			f := newConfigurationFile(t, Properties, `[{"match":"server-port","replace_with":"25565"},{"match":"motd","replace_with":"hello"},{"match":"pvp","if_value":"false","replace_with":"true"}]`)
+			file := openFile(t, []byte("server-port=1\npvp=true\n"))
+
+			res, err := f.ParseWithResult(file)
+			g.Assert(err).IsNil()
+			g.Assert(res).Equal(ParseResult{Created: 1, Updated: 1, Skipped: 1})
+			g.Assert(res.Applied()).Equal(2)
+			g.Assert(res.Total()).Equal(3)
+		})
+
+		g.It("handles values continued across lines in properties files", func() {
+			f := newConfigurationFile(t, Properties, `[{"match":"server-port","replace_with":"25565"},{"match":"description","replace_with":"line one\nline two"}]`)
+
+			out, err := f.ParseBytes([]byte("# header \\\nmotd=hello \\\n    world, \\\n  again\nserver-port=1\\\n  0\ndescription=\n"))
+			g.Assert(err).IsNil()
+			g.Assert(string(out)).Equal("# header \\\nmotd=hello world, again\nserver-port=25565\ndescription=line one\\nline two\n")
+		})
+
+		g.It("refuses to write to the default section of ini files when a section is required", func() {
+			f := newConfigurationFile(t, Ini, `[{"match":"server.port","replace_with":"25565"},{"match":"motd","replace_with":"hello"}]`)
+			f.IniRequireSection = true
+
+			_, err := f.ParseBytes([]byte("[server]\nport=1\n"))
+			var rerr *ReplacementError
+			g.Assert(errors.As(err, &rerr)).IsTrue()
+			g.Assert(rerr.Match).Equal("motd")
+
+			f = newConfigurationFile(t, Ini, `[{"match":"server.port","replace_with":"25565"}]`)
+			f.IniRequireSection = true
+			out, err := f.ParseBytes([]byte("[server]\nport=1\n"))
+			g.Assert(err).IsNil()
+			g.Assert(string(out)).Equal("[server]\nport = 25565\n")
+		})
+
+		g.It("escapes UTF-8 characters in properties files unless raw_utf8 is set", func() {
+			f := newConfigurationFile(t, Properties, `[{"match":"motd","replace_with":"héllo\nwörld"}]`)
+
+			out, err := f.ParseBytes([]byte("motd=hello\n"))
+			g.Assert(err).IsNil()
+			g.Assert(string(out)).Equal("motd=h\\u00e9llo\\nw\\u00f6rld\n")
+
+			f.RawUtf8 = true
+			out, err = f.ParseBytes([]byte("motd=hello\n"))
+			g.Assert(err).IsNil()
+			g.Assert(string(out)).Equal("motd=héllo\\nwörld\n")
+		})
+
+		g.It("writes boolean values in the boolean style of the file", func() {
+			f := newConfigurationFile(t, Ini, `[{"match":"server.pvp","replace_with":true},{"match":"server.name","replace_with":"true"}]`)
+			f.BooleanStyle = BooleanOnOff
+
+			out, err := f.ParseBytes([]byte("[server]\npvp = off\nname = test\n"))
+			g.Assert(err).IsNil()
+			g.Assert(string(out)).Equal("[server]\npvp  = on\nname = true\n")
+
+			f = newConfigurationFile(t, Properties, `[{"match":"pvp","replace_with":false}]`)
+			f.BooleanStyle = BooleanOneZero
+			out, err = f.ParseBytes([]byte("pvp=1\n"))
+			g.Assert(err).IsNil()
+			g.Assert(string(out)).Equal("pvp=0\n")
+		})
+
+		g.It("accepts the boolean style as a number", func() {
+			var s BooleanStyle
+			g.Assert(json.Unmarshal([]byte(`10`), &s)).IsNil()
+			g.Assert(s).Equal(BooleanOneZero)
+			g.Assert(json.Unmarshal([]byte(`2`), &s) == nil).IsFalse()
+		})
+
+		g.It("recognizes a custom comment prefix in properties files", func() {
+			f := newConfigurationFile(t, Properties, `[{"match":"server-port","replace_with":"25565"}]`)
+			f.CommentPrefix = ";"
+
+			out, err := f.ParseBytes([]byte("; Server settings\n; generated\nserver-port=1\n  ; a comment\nmotd=hello\n"))
+			g.Assert(err).IsNil()
+			g.Assert(string(out)).Equal("; Server settings\n; generated\nserver-port=25565\nmotd=hello\n")
+		})
+
+		g.It("preserves a byte order mark in properties files", func() {
+			f := newConfigurationFile(t, Properties, `[{"match":"server-port","replace_with":"25565"}]`)
+			file := openFile(t, []byte("\xEF\xBB\xBFserver-port=1\n"))
+
+			err := f.Parse(file)
+			g.Assert(err).IsNil()
+			g.Assert(readFile(t, file)).Equal("\xEF\xBB\xBFserver-port=25565\n")
+		})
+
+		g.It("decompresses and recompresses gzip compressed files", func() {
+			compress := func(b []byte) []byte {
+				var buf bytes.Buffer
+				w := gzip.NewWriter(&buf)
+				w.Name = "server.properties"
+				_, _ = w.Write(b)
+				_ = w.Close()
+				return buf.Bytes()
+			}
+			input := compress([]byte("server-port=1\nmotd=hello\n"))
+
+			f := newConfigurationFile(t, Properties, `[{"match":"server-port","replace_with":"25565"}]`)
+			file := openFile(t, input)
+			g.Assert(f.Parse(file)).IsNil()
+
+			r, err := gzip.NewReader(strings.NewReader(readFile(t, file)))
+			g.Assert(err).IsNil()
+			out, err := io.ReadAll(r)
+			g.Assert(err).IsNil()
+			g.Assert(string(out)).Equal("server-port=25565\nmotd=hello\n")
+			g.Assert(r.Name).Equal("server.properties")
+
+			// Contents that do not change are returned exactly as they were.
+			f = newConfigurationFile(t, Properties, `[{"match":"motd","replace_with":"hello"}]`)
+			unchanged, err := f.ParseBytes(input)
+			g.Assert(err).IsNil()
+			g.Assert(unchanged).Equal(input)
+		})
+
+		g.It("refuses to parse files larger than the configured limit", func() {
+			f := newConfigurationFile(t, Json, `[{"match":"server.port","replace_with":"25565"}]`)
+			file := openFile(t, bytes.Repeat([]byte(" "), 1024*1024+1))
+
+			err := f.Parse(file)
+			g.Assert(errors.Is(err, ErrFileTooLarge)).IsTrue()
+		})
+
+		g.It("refuses to parse xml documents larger than the configured limit", func() {
+			config.Set(&config.Configuration{AuthenticationToken: "abc", System: config.SystemConfiguration{MaxConfigFileSize: 1}})
+			defer config.Set(&config.Configuration{AuthenticationToken: "abc"})
+
+			f := newConfigurationFile(t, Xml, `[{"match":"Settings.Port","replace_with":"25565"}]`)
+			_, err := f.ParseBytes(append([]byte("<Settings>"), bytes.Repeat([]byte(" "), 1024*1024)...))
+			g.Assert(errors.Is(err, ErrFileTooLarge)).IsTrue()
+		})
+
+		g.It("validates that replacement matches are well-formed", func() {
+			for _, c := range []struct {
+				parser ConfigurationParser
+				match  string
+				valid  bool
+			}{
+				{Json, "server.port", true},
+				{Json, "worlds[name=world.nether].seed", true},
+				{Json, "servers.*.address", true},
+				{Yaml, "server..port", false},
+				{Json, ".server", false},
+				{Json, "worlds[0.seed", false},
+				{Xml, "Settings]Port", false},
+				{Ini, "[server].port", true},
+				{Ini, "section.key.with.dots", true},
+				{Ini, "section.", false},
+				{Csv, "0.name", true},
+				{Tsv, "name", false},
+				{Csv, "-1.name", false},
+				{Properties, "server..port", true},
+				{File, "", false},
+			} {
+				err := validateMatch(c.parser, c.match)
+				g.Assert(err == nil).Equal(c.valid, string(c.parser)+": "+c.match)
+			}
+		})
+
+		g.It("returns distinguishable errors", func() {
+			f := newConfigurationFile(t, Json, `[{"match":"server.port","replace_with":"25565"}]`)
+			_, err := f.ParseBytes([]byte(`{"server":`))
+			g.Assert(errors.Is(err, ErrParseSyntax)).IsTrue()
+
+			f = newConfigurationFile(t, "toml", `[{"match":"server.port","replace_with":"25565"}]`)
+			_, err = f.ParseBytes([]byte(`port = 1`))
+			g.Assert(errors.Is(err, ErrUnsupportedParser)).IsTrue()
+		})
+
+		g.It("supports every parser that is advertised", func() {
+			for _, p := range SupportedParsers() {
+				f := newConfigurationFile(t, p, `[]`)
+				_, err := f.ParseBytes(nil)
+				g.Assert(errors.Is(err, ErrUnsupportedParser)).IsFalse(p)
+			}
+		})
+
+		g.It("does not modify files using an unsupported parser", func() {
+			f := newConfigurationFile(t, "propperties", `[{"match":"server-port","replace_with":"25565"}]`)
+			file := openFile(t, []byte("server-port=1\n"))
+
+			err := f.Parse(file)
+			g.Assert(errors.Is(err, ErrUnsupportedParser)).IsTrue()
+			g.Assert(strings.Contains(err.Error(), "propperties")).IsTrue()
+			g.Assert(readFile(t, file)).Equal("server-port=1\n")
+		})
+
+		g.It("skips parsing files that have not changed since they were last parsed", func() {
+			c := &config.Configuration{AuthenticationToken: "abc"}
+			c.System.SkipUnchangedConfigurationFiles = true
+			config.Set(c)
+
+			f := newConfigurationFile(t, Properties, `[{"match":"server-port","replace_with":"25565"}]`)
+			file := openFile(t, []byte("server-port=1\n"))
+
+			res, err := f.ParseWithResult(file)
+			g.Assert(err).IsNil()
+			g.Assert(res.Unchanged).IsFalse()
+			g.Assert(f.Fingerprint == "").IsFalse()
+
+			// Nothing has changed, so the file is not parsed again.
+			fingerprint := f.Fingerprint
+			file = openFile(t, []byte(readFile(t, file)))
+			res, err = f.ParseWithResult(file)
+			g.Assert(err).IsNil()
+			g.Assert(res.Unchanged).IsTrue()
+			g.Assert(f.Fingerprint).Equal(fingerprint)
+
+			// The file was modified, so the replacements need to be applied again.
+			file = openFile(t, []byte("server-port=2\n"))
+			res, err = f.ParseWithResult(file)
+			g.Assert(err).IsNil()
+			g.Assert(res.Unchanged).IsFalse()
+			g.Assert(readFile(t, file)).Equal("server-port=25565\n")
+			g.Assert(f.Fingerprint).Equal(fingerprint)
+
+			// The replacements changed, so the file is parsed again.
+			changed := newConfigurationFile(t, Properties, `[{"match":"server-port","replace_with":"25566"}]`)
+			changed.Fingerprint = fingerprint
+			res, err = changed.ParseWithResult(openFile(t, []byte("server-port=25565\n")))
+			g.Assert(err).IsNil()
+			g.Assert(res.Unchanged).IsFalse()
+		})
+
+		g.It("does not fingerprint files unless skipping unchanged files is enabled", func() {
+			f := newConfigurationFile(t, Properties, `[{"match":"server-port","replace_with":"25565"}]`)
+			f.Fingerprint = "previous"
+
+			res, err := f.ParseWithResult(openFile(t, []byte("server-port=1\n")))
+			g.Assert(err).IsNil()
+			g.Assert(res.Unchanged).IsFalse()
+			g.Assert(f.Fingerprint).Equal("previous")
+		})
+
+		g.It("refuses to write to a file outside of the root", func() {
+			root := t.TempDir()
+			outside := openFile(t, []byte("server-port=1\n"))
+			g.Assert(os.Symlink(outside.Name(), filepath.Join(root, "server.properties"))).IsNil()
+			linked, err := os.OpenFile(filepath.Join(root, "server.properties"), os.O_RDWR, 0)
+			g.Assert(err).IsNil()
+			defer linked.Close()
+
+			f := newConfigurationFile(t, Properties, `[{"match":"server-port","replace_with":"25565"}]`)
+			f.Root = root
+			for _, file := range []ufs.File{outside, linked} {
+				err := f.Parse(file)
+				g.Assert(errors.Is(err, ufs.ErrBadPathResolution)).IsTrue(err)
+			}
+			g.Assert(readFile(t, outside)).Equal("server-port=1\n")
+
+			inside := openFile(t, []byte("server-port=1\n"))
+			f.Root = filepath.Dir(inside.Name())
+			g.Assert(f.Parse(inside)).IsNil()
+			g.Assert(readFile(t, inside)).Equal("server-port=25565\n")
+		})
+
+		g.It("preserves the permissions of the file", func() {
+			for _, tc := range []struct {
+				parser  ConfigurationParser
+				replace string
+				input   string
+			}{
+				{Properties, `[{"match":"server-port","replace_with":"25565"}]`, "server-port=1\n"},
+				{File, `[{"match":"server-port=","replace_with":"server-port=25565"}]`, "server-port=1\n"},
+				{Yaml, `[{"match":"server.port","replace_with":"25565"}]`, "server:\n  port: 1\n"},
+				{Json, `[{"match":"server.port","replace_with":"25565"}]`, `{"server":{"port":1}}`},
+				{Ini, `[{"match":"server.port","replace_with":"25565"}]`, "[server]\nport=1\n"},
+				{Xml, `[{"match":"server.port","replace_with":"25565"}]`, "<server><port>1</port></server>"},
+				{Csv, `[{"match":"1.port","replace_with":"25565"}]`, "name,port\nserver,1\n"},
+			} {
+				f := newConfigurationFile(t, string(tc.parser), tc.replace)
+				file := openFile(t, []byte(tc.input))
+				g.Assert(os.Chmod(file.Name(), 0o600)).IsNil()
+
+				g.Assert(f.Parse(file)).IsNil(string(tc.parser))
+				out := readFile(t, file)
+				g.Assert(out != tc.input).IsTrue(string(tc.parser))
+				g.Assert(strings.Contains(out, "25565")).IsTrue(string(tc.parser))
+
+				st, err := os.Stat(file.Name())
+				g.Assert(err).IsNil()
+				g.Assert(st.Mode().Perm()).Equal(os.FileMode(0o600), string(tc.parser))
+			}
+		})
+
+		g.It("validates the parsed file against its schema", func() {
+			schema := `{"type":"object","required":["server"],"properties":{"server":{"type":"object","properties":{"port":{"type":"integer","minimum":1,"maximum":65535},"motd":{"type":"string","maxLength":5}}}}}`
+			for parser, input := range map[ConfigurationParser]string{
+				Json: `{"server":{"port":1,"motd":"hello"}}`,
+				Yaml: "server:\n  port: 1\n  motd: hello\n",
+			} {
+				var f ConfigurationFile
+				g.Assert(json.Unmarshal([]byte(`{"file":"config","parser":"`+string(parser)+`","replace":[{"match":"server.port","replace_with":"70000"},{"match":"server.motd","replace_with":"hello world"}],"schema":`+schema+`}`), &f)).IsNil()
+				file := openFile(t, []byte(input))
+
+				err := f.Parse(file)
+				var serr *SchemaError
+				g.Assert(errors.As(err, &serr)).IsTrue(string(parser))
+				g.Assert(serr.Violations).Equal([]string{
+					"/server/motd: maxLength: got 11, want 5",
+					"/server/port: maximum: got 70,000, want 65,535",
+				}, string(parser))
+				g.Assert(readFile(t, file)).Equal(input, string(parser))
+			}
+		})
+
+		g.It("writes files that match their schema", func() {
+			var f ConfigurationFile
+			g.Assert(json.Unmarshal([]byte(`{"file":"config","parser":"json","replace":[{"match":"server.port","replace_with":"25565"}],"schema":{"properties":{"server":{"additionalProperties":false,"properties":{"port":{"enum":[25565,25566]}}}}}}`), &f)).IsNil()
+
+			out, err := f.ParseBytes([]byte(`{"server":{"port":1}}`))
+			g.Assert(err).IsNil()
+			g.Assert(string(out)).Equal("{\n    \"server\": {\n        \"port\": 25565\n    }\n}")
+		})
+
+		g.It("resolves references to definitions within the schema", func() {
+			var f ConfigurationFile
+			g.Assert(json.Unmarshal([]byte(`{"file":"config","parser":"json","replace":[{"match":"server.port","replace_with":"70000"}],"schema":{"$defs":{"port":{"type":"integer","maximum":65535}},"properties":{"server":{"properties":{"port":{"$ref":"#/$defs/port"}}}}}}`), &f)).IsNil()
+
+			_, err := f.ParseBytes([]byte(`{"server":{"port":1}}`))
+			var serr *SchemaError
+			g.Assert(errors.As(err, &serr)).IsTrue()
+			g.Assert(serr.Violations).Equal([]string{"/server/port: maximum: got 70,000, want 65,535"})
+		})
+
+		g.It("refuses schemas that reference other documents", func() {
+			var f ConfigurationFile
+			g.Assert(json.Unmarshal([]byte(`{"file":"config","parser":"json","replace":[{"match":"server.port","replace_with":"1"}],"schema":{"$ref":"file:///etc/passwd"}}`), &f)).IsNil()
+
+			_, err := f.ParseBytes([]byte(`{"server":{"port":1}}`))
+			g.Assert(err).IsNotNil()
+			var serr *SchemaError
+			g.Assert(errors.As(err, &serr)).IsFalse()
+		})
+	})
+}
+
+type testMetrics struct {
+	parsers []ConfigurationParser
+	applied int
+	errors  int
+}
+
+func (m *testMetrics) ObserveParse(parser ConfigurationParser, result ParseResult, err error) {
+	m.parsers = append(m.parsers, parser)
+	m.applied += result.Applied()
+	if err != nil {
+		m.errors++
+	}
+}
+
+func TestConfigurationFile_Diff(t *testing.T) {
+	g := Goblin(t)
+
+	g.Describe("Diff", func() {
+		g.BeforeEach(func() {
+			config.Set(&config.Configuration{AuthenticationToken: "abc"})
+		})
+
+		g.It("returns the changed lines with their surrounding context", func() {
+			input := []byte("a=1\nb=2\nc=3\nd=4\ne=5\nf=6\ng=7\nserver-port=1\n")
+			f := newConfigurationFile(t, Properties, `[{"match":"server-port","replace_with":"25565"}]`)
+
+			d, err := f.Diff(input)
+			g.Assert(err).IsNil()
+			g.Assert(d.Changed()).IsTrue()
+			g.Assert(len(d.Hunks)).Equal(1)
+
+			h := d.Hunks[0]
+			g.Assert(h.OldStart).Equal(5)
+			g.Assert(h.OldLines).Equal(4)
+			g.Assert(h.NewStart).Equal(5)
+			g.Assert(h.NewLines).Equal(4)
+			g.Assert(h.Lines[2]).Equal(DiffLine{Operation: DiffEqual, OldLine: 7, NewLine: 7, Content: "g=7"})
+			g.Assert(h.Lines[3]).Equal(DiffLine{Operation: DiffDelete, OldLine: 8, Content: "server-port=1"})
+			g.Assert(h.Lines[4]).Equal(DiffLine{Operation: DiffInsert, NewLine: 8, Content: "server-port=25565"})
+		})
+
+		g.It("returns an empty diff if nothing changed", func() {
+			f := newConfigurationFile(t, Properties, `[{"match":"server-port","replace_with":"1"}]`)
+
+			d, err := f.Diff([]byte("server-port=1\n"))
+			g.Assert(err).IsNil()
+			g.Assert(d.Changed()).IsFalse()
+		})
+
+		g.It("compares gzip compressed files after decompressing them", func() {
+			var buf bytes.Buffer
+			w := gzip.NewWriter(&buf)
+			_, _ = w.Write([]byte("server-port=1\n"))
+			_ = w.Close()
+
+			f := newConfigurationFile(t, Properties, `[{"match":"server-port","replace_with":"25565"}]`)
+			d, err := f.Diff(buf.Bytes())
+			g.Assert(err).IsNil()
+			g.Assert(d.Hunks[0].Lines).Equal([]DiffLine{
+				{Operation: DiffDelete, OldLine: 1, Content: "server-port=1"},
+				{Operation: DiffInsert, NewLine: 1, Content: "server-port=25565"},
+			})
+		})
+	})
+}
+
+func BenchmarkConfigurationFile_Parse(b *testing.B) {
+	config.Set(&config.Configuration{AuthenticationToken: "abc"})
+
+	// Build a large JSON document with a few thousand nested objects to approximate
+	// the size of the largest configuration files seen in the wild.
+	data := map[string]interface{}{}
+	for i := 0; i < 5000; i++ {
+		data[fmt.Sprintf("key_%d", i)] = map[string]interface{}{"enabled": true, "value": i, "name": "foo"}
+	}
+	contents, err := json.Marshal(data)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	f := newConfigurationFile(b, Json, `[{"match":"key_1.value","replace_with":"10"},{"match":"key_*.enabled","replace_with":false}]`)
+	file := openFile(b, contents)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		b.StopTimer()
+		if err := os.WriteFile(file.Name(), contents, 0o644); err != nil {
+			b.Fatal(err)
+		}
+		if _, err := file.Seek(0, 0); err != nil {
+			b.Fatal(err)
+		}
+		b.StartTimer()
+
+		if err := f.Parse(file); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkConfigurationFile_Parsers(b *testing.B) {
+	config.Set(&config.Configuration{AuthenticationToken: "abc"})
+
+	// Build an input for each parser with a few thousand entries, with the value
+	// being replaced near the end of the file.
+	var yml, ini, properties, file, xml, csv strings.Builder
+	xml.WriteString("<server>\n")
+	csv.WriteString("id,name,value\n")
+	for i := 0; i < 5000; i++ {
+		fmt.Fprintf(&yml, "key_%d:\n  enabled: true\n  value: %d\n", i, i)
+		fmt.Fprintf(&ini, "[section_%d]\nenabled=true\nvalue=%d\n", i, i)
+		fmt.Fprintf(&properties, "key-%d=%d\n", i, i)
+		fmt.Fprintf(&file, "key_%d %d\n", i, i)
+		fmt.Fprintf(&xml, "  <key_%d enabled=\"true\">%d</key_%d>\n", i, i, i)
+		fmt.Fprintf(&csv, "%d,name_%d,%d\n", i, i, i)
+	}
+	xml.WriteString("</server>\n")
+
+	for _, tc := range []struct {
+		parser  string
+		replace string
+		input   string
+	}{
+		{Yaml, `[{"match":"key_4999.value","replace_with":"10"}]`, yml.String()},
+		{Ini, `[{"match":"section_4999.value","replace_with":"10"}]`, ini.String()},
+		{Properties, `[{"match":"key-4999","replace_with":"10"}]`, properties.String()},
+		{File, `[{"match":"key_4999 ","replace_with":"key_4999 10"}]`, file.String()},
+		{Xml, `[{"match":"server.key_4999","replace_with":"10"}]`, xml.String()},
+		{Csv, `[{"match":"4999.value","replace_with":"10"}]`, csv.String()},
+	} {
+		b.Run(tc.parser, func(b *testing.B) {
+			f := newConfigurationFile(b, tc.parser, tc.replace)
+			contents := []byte(tc.input)
+			if out, err := f.ParseBytes(contents); err != nil {
+				b.Fatal(err)
+			} else if bytes.Equal(out, contents) {
+				b.Fatal("replacement was not applied")
+			}
+
+			b.SetBytes(int64(len(contents)))
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if _, err := f.ParseBytes(contents); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkConfigurationFile_ParseJsonStream(b *testing.B) {
+	config.Set(&config.Configuration{AuthenticationToken: "abc"})
+
+	data := map[string]interface{}{}
+	for i := 0; i < 5000; i++ {
+		data[fmt.Sprintf("key_%d", i)] = map[string]interface{}{"enabled": true, "value": i, "name": "foo"}
+	}
+	contents, err := json.Marshal(data)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	for _, stream := range []bool{false, true} {
+		b.Run(fmt.Sprintf("stream=%t", stream), func(b *testing.B) {
+			f := newConfigurationFile(b, Json, `[{"match":"key_1.value","replace_with":"10"},{"match":"key_2.enabled","replace_with":false}]`)
+			f.JsonStream = stream
+
+			b.SetBytes(int64(len(contents)))
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if _, err := f.ParseBytes(contents); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkConfigurationFile_ParseXml(b *testing.B) {
+	config.Set(&config.Configuration{AuthenticationToken: "abc"})
+
+	// Build an XML document with a few thousand elements so the memory used by the
+	// document tree, relative to the size of the file, can be seen with -benchmem.
+	var buf bytes.Buffer
+	buf.WriteString(`<?xml version="1.0" encoding="utf-8"?><Settings>`)
+	for i := 0; i < 5000; i++ {
+		fmt.Fprintf(&buf, `<Entry id="%d"><Name>foo</Name><Enabled>true</Enabled></Entry>`, i)
+	}
+	buf.WriteString(`<Port>1</Port></Settings>`)
+	contents := buf.Bytes()
+
+	f := newConfigurationFile(b, Xml, `[{"match":"Settings.Port","replace_with":"25565"}]`)
+
+	b.SetBytes(int64(len(contents)))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := f.ParseBytes(contents); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package parser
+
+// ParseResult describes the outcome of applying each of the replacements for a
+// configuration file.
+type ParseResult struct {
+	// The number of replacements that created a key which did not previously exist.
+	Created int `json:"created"`
+
+	// The number of replacements that updated an existing key.
+	Updated int `json:"updated"`
+
+	// The number of replacements that were not applied, either because the match
+	// did not resolve to anything or because the if_value condition was not met.
+	Skipped int `json:"skipped"`
+
+	// The number of replacements that failed with an error.
+	Errored int `json:"errored"`
+
+	// Whether parsing was skipped because neither the file nor its replacements
+	// had changed since the file was last parsed.
+	Unchanged bool `json:"unchanged"`
+}
+
+// Applied returns the number of replacements that were successfully applied to
+// the configuration file.
+func (r ParseResult) Applied() int {
+	return r.Created + r.Updated
+}
+
+// Total returns the total number of replacements that were processed.
+func (r ParseResult) Total() int {
+	return r.Created + r.Updated + r.Skipped + r.Errored
+}
+
+// outcome is the result of applying a single replacement. Outcomes are ordered
+// such that when a replacement matches multiple values the highest outcome is
+// the one that is recorded.
+type outcome int
+
+const (
+	outcomeSkipped outcome = iota
+	outcomeUpdated
+	outcomeCreated
+)
+
+// record tracks the outcome of a single replacement in the result.
+func (r *ParseResult) record(o outcome) {
+	switch o {
+	case outcomeCreated:
+		r.Created++
+	case outcomeUpdated:
+		r.Updated++
+	default:
+		r.Skipped++
+	}
+}
+
+// outcomeOf returns the outcome of a replacement that was applied to a key which
+// either did or did not previously exist.
+func outcomeOf(applied bool, existed bool) outcome {
+	if !applied {
+		return outcomeSkipped
+	}
+	if existed {
+		return outcomeUpdated
+	}
+	return outcomeCreated
+}
@@ -0,0 +1,112 @@
+package parser
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+
+	. "github.com/franela/goblin"
+	"github.com/goccy/go-json"
+
+	"github.com/IvanX77/turbowings/config"
+)
+
+// update rewrites the expected output of every round-trip fixture with the output
+// of the parsers. Run "go test ./parser -run RoundTrip -update" after making an
+// intentional change to the output of a parser and review the changes to the
+// fixtures before committing them.
+var update = flag.Bool("update", false, "update the expected output of the round-trip fixtures")
+
+// roundTripFixture is a configuration file definition along with the contents of
+// a file before and after the replacements are applied to it. Each fixture is a
+// directory in testdata/roundtrip containing the definition in the same format
+// the Panel sends it in as "definition.json", and the contents of the file in
+// "input" and "expected".
+type roundTripFixture struct {
+	Name     string
+	Dir      string
+	File     ConfigurationFile
+	Input    []byte
+	Expected []byte
+}
+
+// loadRoundTripFixtures returns every fixture in testdata/roundtrip.
+func loadRoundTripFixtures(t testing.TB) []roundTripFixture {
+	dirs, err := filepath.Glob(filepath.Join("testdata", "roundtrip", "*"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fixtures := make([]roundTripFixture, 0, len(dirs))
+	for _, dir := range dirs {
+		fx := roundTripFixture{Name: filepath.Base(dir), Dir: dir}
+		b, err := os.ReadFile(filepath.Join(dir, "definition.json"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := json.Unmarshal(b, &fx.File); err != nil {
+			t.Fatalf("%s: %s", fx.Name, err)
+		}
+		if fx.Input, err = os.ReadFile(filepath.Join(dir, "input")); err != nil {
+			t.Fatal(err)
+		}
+		if fx.Expected, err = os.ReadFile(filepath.Join(dir, "expected")); err != nil && !(*update && os.IsNotExist(err)) {
+			t.Fatal(err)
+		}
+		fixtures = append(fixtures, fx)
+	}
+	return fixtures
+}
+
+// roundTrip applies the replacements for the configuration file to the input in
+// memory, and then applies them again to the output. Parsing a file that has
+// already been updated must not change it any further, otherwise the file would
+// change every time the server is started.
+func roundTrip(f ConfigurationFile, input []byte) (out []byte, again []byte, err error) {
+	if out, err = f.ParseBytes(input); err != nil {
+		return nil, nil, err
+	}
+	if again, err = f.ParseBytes(out); err != nil {
+		return out, nil, err
+	}
+	return out, again, nil
+}
+
+func TestConfigurationFile_RoundTrip(t *testing.T) {
+	g := Goblin(t)
+	fixtures := loadRoundTripFixtures(t)
+
+	g.Describe("RoundTrip", func() {
+		g.BeforeEach(func() {
+			config.Set(&config.Configuration{AuthenticationToken: "abc"})
+		})
+
+		g.It("has a fixture for every parser", func() {
+			parsers := make(map[ConfigurationParser]bool)
+			for _, fx := range fixtures {
+				parsers[fx.File.Parser] = true
+			}
+			for _, p := range SupportedParsers() {
+				if p == "yml" {
+					continue
+				}
+				g.Assert(parsers[ConfigurationParser(p)]).IsTrue(p)
+			}
+		})
+
+		for _, fx := range fixtures {
+			fx := fx
+			g.It("applies the replacements to the "+fx.Name+" fixture", func() {
+				out, again, err := roundTrip(fx.File, fx.Input)
+				g.Assert(err).IsNil(fx.Name)
+				if *update {
+					g.Assert(os.WriteFile(filepath.Join(fx.Dir, "expected"), out, 0o644)).IsNil()
+					fx.Expected = out
+				}
+				g.Assert(string(out)).Equal(string(fx.Expected), fx.Name)
+				g.Assert(string(again)).Equal(string(out), fx.Name)
+			})
+		}
+	})
+}
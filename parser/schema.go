@@ -0,0 +1,127 @@
+package parser
+
+import (
+	"bytes"
+	"sort"
+	"strings"
+
+	"emperror.dev/errors"
+	"github.com/goccy/go-json"
+	"github.com/icza/dyno"
+	"github.com/santhosh-tekuri/jsonschema/v6"
+	"gopkg.in/yaml.v3"
+)
+
+// schemaResource is the location the schema for a configuration file is added to
+// the compiler under. References within the schema are resolved relative to it.
+const schemaResource = "turbowings://configuration-file/schema.json"
+
+// SchemaError is returned when a parsed configuration file does not match the
+// JSON Schema defined for it.
+type SchemaError struct {
+	// Violations describes each part of the document that did not match the
+	// schema, prefixed with the location of the value in the document.
+	Violations []string
+}
+
+func (e *SchemaError) Error() string {
+	return "parser: configuration file does not match its schema: " + strings.Join(e.Violations, "; ")
+}
+
+// schemaLoader refuses to load any schema that is not part of the schema for the
+// configuration file. References to other documents would otherwise allow a schema
+// to read files from the disk or make requests to other hosts.
+type schemaLoader struct{}
+
+func (schemaLoader) Load(url string) (any, error) {
+	return nil, errors.New("parser: schema references an external document: " + url)
+}
+
+// validateSchema validates the parsed contents of a JSON or YAML file against the
+// schema for the file, if one is set. References within the schema itself, such as
+// to its "$defs", are supported, but references to other documents are not.
+func (f *ConfigurationFile) validateSchema(out []byte) error {
+	if len(f.Schema) == 0 {
+		return nil
+	}
+
+	schema, err := compileSchema(f.Schema)
+	if err != nil {
+		return err
+	}
+
+	var b []byte
+	switch f.Parser {
+	case Json, Jsonc:
+		b = out
+	case Yaml, "yml":
+		var i interface{}
+		if err := yaml.Unmarshal(out, &i); err != nil {
+			return newSyntaxError(err)
+		}
+		// Round-trip the document through JSON so that the values have the same
+		// types as a decoded JSON document.
+		if b, err = json.Marshal(dyno.ConvertMapI2MapS(i)); err != nil {
+			return errors.WithStack(err)
+		}
+	default:
+		return nil
+	}
+	doc, err := jsonschema.UnmarshalJSON(bytes.NewReader(b))
+	if err != nil {
+		return newSyntaxError(err)
+	}
+
+	if err := schema.Validate(doc); err != nil {
+		var verr *jsonschema.ValidationError
+		if !errors.As(err, &verr) {
+			return errors.WrapIf(err, "parser: failed to validate configuration file against its schema")
+		}
+		return errors.WithStack(&SchemaError{Violations: schemaViolations(verr)})
+	}
+	return nil
+}
+
+// compileSchema compiles the JSON Schema for a configuration file.
+func compileSchema(raw json.RawMessage) (*jsonschema.Schema, error) {
+	doc, err := jsonschema.UnmarshalJSON(bytes.NewReader(raw))
+	if err != nil {
+		return nil, errors.WrapIf(err, "parser: failed to decode configuration file schema")
+	}
+
+	c := jsonschema.NewCompiler()
+	c.UseLoader(schemaLoader{})
+	if err := c.AddResource(schemaResource, doc); err != nil {
+		return nil, errors.WrapIf(err, "parser: failed to load configuration file schema")
+	}
+	schema, err := c.Compile(schemaResource)
+	if err != nil {
+		return nil, errors.WrapIf(err, "parser: failed to compile configuration file schema")
+	}
+	return schema, nil
+}
+
+// schemaViolations returns a description of every part of the document that did
+// not match the schema, prefixed with the location of the value in the document.
+// The violations are sorted so that they are always reported in the same order.
+func schemaViolations(err *jsonschema.ValidationError) []string {
+	var violations []string
+	var walk func(e *jsonschema.ValidationError)
+	walk = func(e *jsonschema.ValidationError) {
+		if len(e.Causes) > 0 {
+			for _, c := range e.Causes {
+				walk(c)
+			}
+			return
+		}
+		out := e.BasicOutput()
+		path := out.InstanceLocation
+		if path == "" {
+			path = "/"
+		}
+		violations = append(violations, path+": "+out.Error.String())
+	}
+	walk(err)
+	sort.Strings(violations)
+	return violations
+}
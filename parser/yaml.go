@@ -0,0 +1,198 @@
+package parser
+
+import (
+	"bytes"
+	"slices"
+
+	"emperror.dev/errors"
+	"github.com/goccy/go-json"
+	"github.com/icza/dyno"
+	"gopkg.in/yaml.v3"
+)
+
+// mergeKey is the key used by YAML to merge the contents of one mapping into
+// another, typically used alongside an alias to share a set of defaults.
+const mergeKey = "<<"
+
+// defaultYamlIndent is the indentation used when writing a YAML document that
+// does not have any existing indentation to match.
+const defaultYamlIndent = 4
+
+// detectYamlIndent returns the number of spaces used to indent the YAML document
+// by looking at the first indented line that is not a comment. Sequences are
+// commonly written at the same level as their parent key, so lines starting a
+// sequence entry are not considered.
+func detectYamlIndent(input []byte) int {
+	for _, line := range bytes.Split(input, []byte("\n")) {
+		trimmed := bytes.TrimLeft(line, " ")
+		indent := len(line) - len(trimmed)
+		if indent == 0 || len(bytes.TrimSpace(trimmed)) == 0 || trimmed[0] == '#' || trimmed[0] == '-' {
+			continue
+		}
+		if indent >= 2 && indent <= 8 {
+			return indent
+		}
+		return defaultYamlIndent
+	}
+	return defaultYamlIndent
+}
+
+// mergeYamlNode updates the YAML node so that it represents the given value,
+// which is the updated JSON representation of the node. Any part of the node that
+// already represents the expected value is left as-is, which keeps anchors,
+// aliases, comments, and the original formatting intact wherever possible.
+func mergeYamlNode(n *yaml.Node, value interface{}) error {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		if n.Kind == yaml.MappingNode {
+			return mergeYamlMapping(n, v)
+		}
+	case []interface{}:
+		if n.Kind == yaml.SequenceNode {
+			return mergeYamlSequence(n, v)
+		}
+	}
+
+	equal, err := yamlNodeEquals(n, value)
+	if err != nil || equal {
+		return err
+	}
+	return replaceYamlNode(n, value)
+}
+
+// mergeYamlMapping merges the given map into a mapping node. Keys that exist in
+// the node are updated in place, any keys that are missing are appended to the
+// end of the mapping in a stable order, and keys that are no longer present in
+// the map are removed.
+//
+// Keys that are only present in the mapping because they were merged in from
+// an alias are only written out if their value was changed, in which case they
+// take precedence over the merged value without modifying the anchor itself.
+func mergeYamlMapping(n *yaml.Node, value map[string]interface{}) error {
+	seen := make(map[string]bool, len(n.Content)/2)
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		k := n.Content[i].Value
+		if k == mergeKey {
+			// The merge key is tagged explicitly when decoded, which would otherwise
+			// cause it to be written back out as "!!merge <<".
+			n.Content[i].Tag = ""
+			continue
+		}
+		seen[k] = true
+		if v, ok := value[k]; ok {
+			if err := mergeYamlNode(n.Content[i+1], v); err != nil {
+				return err
+			}
+		}
+	}
+	// Remove any keys that are no longer present, which only happens when they were
+	// deleted by a merge patch.
+	for i := 0; i+1 < len(n.Content); {
+		if k := n.Content[i].Value; k != mergeKey {
+			if _, ok := value[k]; !ok {
+				n.Content = slices.Delete(n.Content, i, i+2)
+				continue
+			}
+		}
+		i += 2
+	}
+
+	var missing []string
+	for k := range value {
+		if !seen[k] {
+			missing = append(missing, k)
+		}
+	}
+	if len(missing) == 0 {
+		return nil
+	}
+	slices.Sort(missing)
+
+	var existing map[string]interface{}
+	if err := n.Decode(&existing); err != nil {
+		return errors.WithStack(err)
+	}
+	for _, k := range missing {
+		if v, ok := existing[k]; ok {
+			if equal, err := jsonEquals(v, value[k]); err != nil {
+				return err
+			} else if equal {
+				continue
+			}
+		}
+		var vn yaml.Node
+		if err := vn.Encode(value[k]); err != nil {
+			return errors.WithStack(err)
+		}
+		n.Content = append(n.Content, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: k}, &vn)
+	}
+	return nil
+}
+
+// mergeYamlSequence merges the given slice into a sequence node, updating the
+// existing elements in place and appending any new elements to the end.
+func mergeYamlSequence(n *yaml.Node, value []interface{}) error {
+	for i, v := range value {
+		if i < len(n.Content) {
+			if err := mergeYamlNode(n.Content[i], v); err != nil {
+				return err
+			}
+			continue
+		}
+		var vn yaml.Node
+		if err := vn.Encode(v); err != nil {
+			return errors.WithStack(err)
+		}
+		n.Content = append(n.Content, &vn)
+	}
+	if len(n.Content) > len(value) {
+		n.Content = n.Content[:len(value)]
+	}
+	return nil
+}
+
+// replaceYamlNode replaces the node with a newly encoded node for the value. The
+// comments and anchor of the original node are kept, and the quoting style of a
+// scalar is kept if the type of the value did not change.
+func replaceYamlNode(n *yaml.Node, value interface{}) error {
+	var vn yaml.Node
+	if err := vn.Encode(value); err != nil {
+		return errors.WithStack(err)
+	}
+	if n.Kind == yaml.ScalarNode && vn.Kind == yaml.ScalarNode && n.Tag == vn.Tag {
+		vn.Style = n.Style
+	}
+	// An alias cannot carry an anchor, it only points at one.
+	if n.Kind != yaml.AliasNode {
+		vn.Anchor = n.Anchor
+	}
+	vn.HeadComment = n.HeadComment
+	vn.LineComment = n.LineComment
+	vn.FootComment = n.FootComment
+	*n = vn
+	return nil
+}
+
+// yamlNodeEquals returns true if the decoded value of the node is equal to the
+// given value. Aliases are resolved to the value of their anchor.
+func yamlNodeEquals(n *yaml.Node, value interface{}) (bool, error) {
+	var v interface{}
+	if err := n.Decode(&v); err != nil {
+		return false, errors.WithStack(err)
+	}
+	return jsonEquals(v, value)
+}
+
+// jsonEquals compares two values using their JSON representation, which avoids
+// differences between the numeric types used when decoding YAML and JSON.
+func jsonEquals(a interface{}, b interface{}) (bool, error) {
+	ab, err := json.Marshal(dyno.ConvertMapI2MapS(a))
+	if err != nil {
+		return false, errors.WithStack(err)
+	}
+	bb, err := json.Marshal(dyno.ConvertMapI2MapS(b))
+	if err != nil {
+		return false, errors.WithStack(err)
+	}
+	return bytes.Equal(ab, bb), nil
+}
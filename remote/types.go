@@ -136,6 +136,15 @@ func (olm *OutputLineMatcher) UnmarshalJSON(data []byte) error {
 type ProcessStopConfiguration struct {
 	Type  string `json:"type"`
 	Value string `json:"value"`
+
+	// The signal Docker should send to the container when it is stopped natively,
+	// for example "SIGINT". If empty the image default is used.
+	Signal string `json:"signal"`
+
+	// The number of seconds Docker will wait after sending the stop signal before
+	// the container is forcibly killed. If zero the container is allowed to stop
+	// for as long as it needs.
+	Timeout int `json:"timeout"`
 }
 
 // ProcessConfiguration defines the process configuration for a given server
@@ -150,6 +159,19 @@ type ProcessConfiguration struct {
 	} `json:"startup"`
 	Stop               ProcessStopConfiguration   `json:"stop"`
 	ConfigurationFiles []parser.ConfigurationFile `json:"configs"`
+	Backup             BackupHooks                `json:"backup"`
+}
+
+// BackupHooks defines the commands that are run inside the server container
+// before and after a backup is generated, for example to have the server flush
+// everything to the disk so that the backup is consistent.
+type BackupHooks struct {
+	// Pre is run before the backup is generated, if it fails the backup is aborted.
+	Pre string `json:"pre"`
+	// Post is run after the backup has been generated.
+	Post string `json:"post"`
+	// Timeout is the number of seconds to wait for each command to complete.
+	Timeout int `json:"timeout"`
 }
 
 type BackupRemoteUploadResponse struct {
@@ -5,6 +5,7 @@ import (
 	"github.com/apex/log"
 	"github.com/gin-gonic/gin"
 	"github.com/IvanX77/turbowings/config"
+	"github.com/IvanX77/turbowings/internal/metrics"
 	"github.com/IvanX77/turbowings/remote"
 	"github.com/IvanX77/turbowings/router/middleware"
 	wserver "github.com/IvanX77/turbowings/server"
@@ -42,6 +43,7 @@ func Configure(m *wserver.Manager, client remote.Client) *gin.Engine {
 	router.GET("/download/backup", getDownloadBackup)
 	router.GET("/download/file", getDownloadFile)
 	router.POST("/upload/file", postServerUploadFiles)
+	router.PUT("/upload/file", putServerUploadFile)
 
 	// This route is special it sits above all the other requests because we are
 	// using a JWT to authorize access to it, therefore it needs to be publicly
@@ -59,8 +61,10 @@ func Configure(m *wserver.Manager, client remote.Client) *gin.Engine {
 	protected.POST("/api/update", postUpdateConfiguration)
 	protected.GET("/api/system", getSystemInformation)
 	protected.GET("/api/system/docker/disk", getDockerDiskUsage)
+	protected.GET("/api/system/docker/health", getDockerHealth)
 	protected.DELETE("/api/system/docker/image/prune", pruneDockerImages)
 	protected.GET("/api/system/ips", getSystemIps)
+	protected.GET("/api/system/metrics", gin.WrapH(metrics.Handler()))
 	protected.GET("/api/system/utilization", getSystemUtilization)
 	protected.GET("/api/servers", getAllServers)
 	protected.POST("/api/servers", postCreateServer)
@@ -81,6 +85,7 @@ func Configure(m *wserver.Manager, client remote.Client) *gin.Engine {
 		server.POST("/reinstall", postServerReinstall)
 		server.POST("/sync", postServerSync)
 		server.POST("/ws/deny", postServerDenyWSTokens)
+		server.POST("/configuration/resolve", postServerResolveConfigurationValue)
 
 		// This archive request causes the archive to start being created
 		// this should only be triggered by the panel.
@@ -111,8 +116,11 @@ func Configure(m *wserver.Manager, client remote.Client) *gin.Engine {
 
 		backup := server.Group("/backup")
 		{
+			backup.GET("", getServerBackups)
 			backup.POST("", postServerBackup)
+			backup.POST("/preview", postServerBackupPreview)
 			backup.POST("/:backup/restore", postServerRestoreBackup)
+			backup.POST("/:backup/cancel", postServerCancelBackup)
 			backup.DELETE("/:backup", deleteServerBackup)
 		}
 	}
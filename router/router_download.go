@@ -2,19 +2,80 @@ package router
 
 import (
 	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
 	"errors"
+	"fmt"
+	"io"
 	"net/http"
+	"net/url"
 	"os"
+	"path/filepath"
 	"strconv"
+	"strings"
+	"time"
 
+	"github.com/apex/log"
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
 
 	"github.com/IvanX77/turbowings/router/middleware"
 	"github.com/IvanX77/turbowings/router/tokens"
+	"github.com/IvanX77/turbowings/server"
 	"github.com/IvanX77/turbowings/server/backup"
+	"github.com/IvanX77/turbowings/server/filesystem"
 )
 
+// attachmentDisposition returns the Content-Disposition header value used when
+// sending a file to the client. Filenames that are not plain ASCII are encoded
+// per RFC 5987 in the "filename*" parameter so browsers can restore the original
+// name, with an ASCII-only "filename" included as a fallback for older clients.
+func attachmentDisposition(name string) string {
+	fallback := strings.Map(func(r rune) rune {
+		if r < 0x20 || r > 0x7e || r == '"' || r == '\\' {
+			return '_'
+		}
+		return r
+	}, name)
+
+	v := "attachment; filename=" + strconv.Quote(fallback)
+	if fallback == name {
+		return v
+	}
+
+	var b strings.Builder
+	for _, c := range []byte(name) {
+		if isAttrChar(c) {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return v + "; filename*=UTF-8''" + b.String()
+}
+
+// isAttrChar returns true if the byte is allowed to appear unencoded in an
+// extended header parameter value, as defined by RFC 5987.
+func isAttrChar(c byte) bool {
+	if (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9') {
+		return true
+	}
+	return strings.IndexByte("!#$&+-.^_`|~", c) >= 0
+}
+
+// abortInvalidDownloadToken aborts a download request that was made using a token
+// that could not be parsed. Expired tokens are reported with a HTTP-410 so that
+// the Panel can tell the user to request a new download link.
+func abortInvalidDownloadToken(c *gin.Context, err error) {
+	if errors.Is(err, tokens.ErrTokenExpired) {
+		c.AbortWithStatusJSON(http.StatusGone, gin.H{
+			"error": "The download link has expired, please request a new one.",
+		})
+		return
+	}
+	middleware.CaptureAndAbort(c, err)
+}
+
 // Handle a download request for a server backup.
 func getDownloadBackup(c *gin.Context) {
 	client := middleware.ExtractApiClient(c)
@@ -23,7 +84,7 @@ func getDownloadBackup(c *gin.Context) {
 	// Get the payload from the token.
 	token := tokens.BackupPayload{}
 	if err := tokens.ParseToken([]byte(c.Query("token")), &token); err != nil {
-		middleware.CaptureAndAbort(c, err)
+		abortInvalidDownloadToken(c, err)
 		return
 	}
 
@@ -65,11 +126,34 @@ func getDownloadBackup(c *gin.Context) {
 	}
 	defer f.Close()
 
+	sum, err := b.RecordedChecksum()
+	if err != nil {
+		middleware.ExtractLogger(c).WithField("error", err).Warn("failed to read recorded checksum for backup")
+	}
+
 	c.Header("Content-Length", strconv.Itoa(int(st.Size())))
-	c.Header("Content-Disposition", "attachment; filename="+strconv.Quote(st.Name()))
+	c.Header("Content-Disposition", attachmentDisposition(st.Name()))
 	c.Header("Content-Type", "application/octet-stream")
+	if sum != "" {
+		c.Header("X-Checksum-Sha1", sum)
+		_, _ = bufio.NewReader(f).WriteTo(c.Writer)
+		return
+	}
 
-	_, _ = bufio.NewReader(f).WriteTo(c.Writer)
+	// No checksum was recorded for this backup, so compute it while the backup is
+	// being sent and record it once the entire file has been read. This allows the
+	// Panel to backfill the checksum for older backups.
+	h := sha1.New()
+	n, err := io.Copy(c.Writer, io.TeeReader(f, h))
+	if err != nil || n != st.Size() {
+		return
+	}
+	sum = hex.EncodeToString(h.Sum(nil))
+	logger := middleware.ExtractLogger(c).WithFields(log.Fields{"backup": b.Identifier(), "checksum": sum})
+	logger.Info("computed missing checksum for backup while downloading")
+	if err := b.RecordChecksum(sum); err != nil {
+		logger.WithField("error", err).Warn("failed to record computed checksum for backup")
+	}
 }
 
 // Handles downloading a specific file for a server.
@@ -77,7 +161,7 @@ func getDownloadFile(c *gin.Context) {
 	manager := middleware.ExtractManager(c)
 	token := tokens.FilePayload{}
 	if err := tokens.ParseToken([]byte(c.Query("token")), &token); err != nil {
-		middleware.CaptureAndAbort(c, err)
+		abortInvalidDownloadToken(c, err)
 		return
 	}
 
@@ -89,27 +173,146 @@ func getDownloadFile(c *gin.Context) {
 		return
 	}
 
+	if len(token.FilePaths) > 0 {
+		streamFilesArchive(c, s, token.FilePaths)
+		return
+	}
+
 	if err := s.Filesystem().IsIgnored(token.FilePath); err != nil {
 		middleware.CaptureAndAbort(c, err)
 		return
 	}
 
+	// Directories are not returned by Filesystem.File, so check for them first and
+	// stream them to the client as an archive instead.
+	if st, err := s.Filesystem().Stat(token.FilePath); err == nil && st.IsDir() {
+		streamDirectoryArchive(c, s, token.FilePath, st.Name())
+		return
+	}
+
+	if follow, _ := strconv.ParseBool(c.Query("follow")); follow {
+		followFile(c, s, token.FilePath)
+		return
+	}
+
 	f, st, err := s.Filesystem().File(token.FilePath)
 	if err != nil {
 		middleware.CaptureAndAbort(c, err)
 		return
 	}
 	defer f.Close()
-	if st.IsDir() {
+
+	c.Header("Content-Length", strconv.Itoa(int(st.Size())))
+	c.Header("Content-Disposition", attachmentDisposition(st.Name()))
+	c.Header("Content-Type", "application/octet-stream")
+
+	_, _ = bufio.NewReader(f).WriteTo(c.Writer)
+}
+
+// followFile streams the contents of a file to the client and then continues to
+// stream any data appended to it until the client disconnects, in the same way as
+// "tail -f". This allows log files to be watched without polling for changes.
+func followFile(c *gin.Context, s *server.Server, p string) {
+	c.Header("Content-Type", "text/plain; charset=utf-8")
+	c.Header("Cache-Control", "no-cache")
+	// Prevent any reverse proxy from buffering the response, otherwise appended
+	// data would not reach the client until the buffer is full.
+	c.Header("X-Accel-Buffering", "no")
+
+	if err := s.Filesystem().Follow(c.Request.Context(), p, c.Writer, c.Writer.Flush); err != nil {
+		if !c.Writer.Written() {
+			middleware.CaptureAndAbort(c, err)
+			return
+		}
+		s.Log().WithField("error", err).WithField("file", p).Warn("failed to follow file for client")
+	}
+}
+
+// streamDirectoryArchive streams the contents of a directory to the client as an
+// archive that is generated on the fly, so memory usage stays bounded regardless
+// of the size of the directory. The archive format defaults to a gzip compressed
+// tarball but can be changed to a zip archive by passing "format=zip".
+//
+// Any files matching the denylist for the server are excluded from the archive,
+// and symlinks are stored as links rather than being followed.
+func streamDirectoryArchive(c *gin.Context, s *server.Server, dir string, name string) {
+	format := filesystem.ArchiveFormatTarGzip
+	if c.Query("format") == string(filesystem.ArchiveFormatZip) {
+		format = filesystem.ArchiveFormatZip
+	}
+
+	c.Header("Content-Disposition", attachmentDisposition(name+"."+format.Extension()))
+	c.Header("Content-Type", "application/octet-stream")
+
+	a := &filesystem.Archive{
+		Filesystem:    s.Filesystem(),
+		BaseDirectory: dir,
+		Format:        format,
+		SkipIgnored:   true,
+	}
+	if err := a.Stream(c.Request.Context(), c.Writer); err != nil {
+		if !c.Writer.Written() {
+			c.Header("Content-Disposition", "")
+			middleware.CaptureAndAbort(c, err)
+			return
+		}
+		// The headers have already been sent at this point, so the best that can be
+		// done is to log the error and cut the response short.
+		s.Log().WithField("error", err).WithField("directory", dir).Error("failed to stream directory archive to client")
+	}
+}
+
+// streamFilesArchive streams multiple files from the server to the client bundled
+// into a single zip archive. Each path is checked against the denylist for the
+// server and must resolve within the server's data directory, any paths that do
+// not are skipped and reported back to the client in the X-Skipped-Files header
+// as a comma separated list of URL encoded paths.
+func streamFilesArchive(c *gin.Context, s *server.Server, paths []string) {
+	var files, skipped []string
+	for _, p := range paths {
+		p = strings.TrimPrefix(filepath.Clean("/"+p), "/")
+		if err := s.Filesystem().IsIgnored(p); err != nil {
+			skipped = append(skipped, p)
+			continue
+		}
+		if _, err := s.Filesystem().Stat(p); err != nil {
+			skipped = append(skipped, p)
+			continue
+		}
+		files = append(files, p)
+	}
+	if len(skipped) > 0 {
+		s.Log().WithField("files", skipped).Debug("skipping files that cannot be downloaded")
+
+		escaped := make([]string, len(skipped))
+		for i, p := range skipped {
+			escaped[i] = url.PathEscape(p)
+		}
+		c.Header("X-Skipped-Files", strings.Join(escaped, ","))
+	}
+	if len(files) == 0 {
 		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{
-			"error": "The requested resource was not found on this server.",
+			"error": "None of the requested files were found on this server.",
 		})
 		return
 	}
 
-	c.Header("Content-Length", strconv.Itoa(int(st.Size())))
-	c.Header("Content-Disposition", "attachment; filename="+strconv.Quote(st.Name()))
+	name := fmt.Sprintf("files-%s.%s", strings.ReplaceAll(time.Now().Format(time.RFC3339), ":", ""), filesystem.ArchiveFormatZip.Extension())
+	c.Header("Content-Disposition", attachmentDisposition(name))
 	c.Header("Content-Type", "application/octet-stream")
 
-	_, _ = bufio.NewReader(f).WriteTo(c.Writer)
+	a := &filesystem.Archive{
+		Filesystem:  s.Filesystem(),
+		Files:       files,
+		Format:      filesystem.ArchiveFormatZip,
+		SkipIgnored: true,
+	}
+	if err := a.Stream(c.Request.Context(), c.Writer); err != nil {
+		if !c.Writer.Written() {
+			c.Header("Content-Disposition", "")
+			middleware.CaptureAndAbort(c, err)
+			return
+		}
+		s.Log().WithField("error", err).Error("failed to stream files archive to client")
+	}
 }
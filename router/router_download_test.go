@@ -0,0 +1,27 @@
+package router
+
+import (
+	"testing"
+
+	. "github.com/franela/goblin"
+)
+
+func TestAttachmentDisposition(t *testing.T) {
+	g := Goblin(t)
+
+	g.Describe("attachmentDisposition", func() {
+		g.It("encodes the filename for the Content-Disposition header", func() {
+			cases := map[string]string{
+				"backup.tar.gz":   `attachment; filename="backup.tar.gz"`,
+				"my world.zip":    `attachment; filename="my world.zip"`,
+				"wörld.dat":       `attachment; filename="w_rld.dat"; filename*=UTF-8''w%C3%B6rld.dat`,
+				`say "hi".txt`:    `attachment; filename="say _hi_.txt"; filename*=UTF-8''say%20%22hi%22.txt`,
+				`back\slash.txt`:  `attachment; filename="back_slash.txt"; filename*=UTF-8''back%5Cslash.txt`,
+				"line\nbreak.txt": `attachment; filename="line_break.txt"; filename*=UTF-8''line%0Abreak.txt`,
+			}
+			for name, expected := range cases {
+				g.Assert(attachmentDisposition(name)).Equal(expected, name)
+			}
+		})
+	})
+}
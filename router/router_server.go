@@ -11,6 +11,7 @@ import (
 	"github.com/apex/log"
 	"github.com/gin-gonic/gin"
 
+	"github.com/IvanX77/turbowings/parser"
 	"github.com/IvanX77/turbowings/router/downloader"
 	"github.com/IvanX77/turbowings/router/middleware"
 	"github.com/IvanX77/turbowings/router/tokens"
@@ -23,6 +24,28 @@ func getServer(c *gin.Context) {
 	c.JSON(http.StatusOK, ExtractServer(c).ToAPIResponse())
 }
 
+// postServerResolveConfigurationValue returns the value a configuration file
+// replacement resolves to for the server, including any values substituted from
+// the TurboWings configuration, without writing anything to the disk. This helps
+// with diagnosing why a replacement wrote an unexpected value to a file.
+func postServerResolveConfigurationValue(c *gin.Context) {
+	var cfr parser.ConfigurationFileReplacement
+	if err := c.BindJSON(&cfr); err != nil {
+		return
+	}
+
+	value, err := parser.ResolveReplacement(cfr)
+	if err != nil {
+		middleware.CaptureAndAbort(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"match":        cfr.Match,
+		"replace_with": cfr.ReplaceWith.String(),
+		"value":        value,
+	})
+}
+
 // Returns the logs for a given server instance.
 func getServerLogs(c *gin.Context) {
 	s := ExtractServer(c)
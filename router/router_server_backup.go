@@ -9,9 +9,11 @@ import (
 	"github.com/apex/log"
 	"github.com/gin-gonic/gin"
 
+	"github.com/IvanX77/turbowings/config"
 	"github.com/IvanX77/turbowings/router/middleware"
 	"github.com/IvanX77/turbowings/server"
 	"github.com/IvanX77/turbowings/server/backup"
+	"github.com/IvanX77/turbowings/server/filesystem"
 )
 
 // postServerBackup performs a backup against a given server instance using the
@@ -24,21 +26,36 @@ func postServerBackup(c *gin.Context) {
 		Adapter backup.AdapterType `json:"adapter"`
 		Uuid    string             `json:"uuid"`
 		Ignore  string             `json:"ignore"`
+		// CompressionLevel optionally overrides the configured compression level
+		// for this backup, one of "store", "fast", or "best".
+		CompressionLevel string `json:"compression_level"`
+		// Parent is the UUID of an earlier local backup to generate an incremental
+		// backup against, this is only supported by the local adapter.
+		Parent string `json:"parent"`
 	}
 	if err := c.BindJSON(&data); err != nil {
 		return
 	}
+	level, err := filesystem.ParseCompressionLevel(data.CompressionLevel)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "The compression_level field must be one of store, fast, or best."})
+		return
+	}
 
 	var adapter backup.BackupInterface
 	switch data.Adapter {
 	case backup.LocalBackupAdapter:
-		adapter = backup.NewLocal(client, data.Uuid, s.ID(), data.Ignore)
+		b := backup.NewLocal(client, data.Uuid, s.ID(), data.Ignore)
+		b.ServerName = s.Config().Meta.Name
+		b.Parent = data.Parent
+		adapter = b
 	case backup.S3BackupAdapter:
 		adapter = backup.NewS3(client, data.Uuid, s.ID(), data.Ignore)
 	default:
 		middleware.CaptureAndAbort(c, errors.New("router/backups: provided adapter is not valid: "+string(data.Adapter)))
 		return
 	}
+	adapter.SetCompressionLevel(level)
 
 	// Attach the server ID and the request ID to the adapter log context for easier
 	// parsing in the logs.
@@ -56,6 +73,40 @@ func postServerBackup(c *gin.Context) {
 	c.Status(http.StatusAccepted)
 }
 
+// postServerBackupPreview returns the files that would be included in a backup
+// of the server given the provided ignore rules, along with their total size,
+// without generating an archive.
+func postServerBackupPreview(c *gin.Context) {
+	s := middleware.ExtractServer(c)
+	client := middleware.ExtractApiClient(c)
+	var data struct {
+		Ignore string `json:"ignore"`
+	}
+	if err := c.BindJSON(&data); err != nil {
+		return
+	}
+
+	// The adapter has no bearing on which files are included, so a local backup is
+	// used to resolve the files.
+	preview, err := s.BackupPreview(c.Request.Context(), backup.NewLocal(client, "", s.ID(), data.Ignore))
+	if err != nil {
+		middleware.CaptureAndAbort(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, preview)
+}
+
+// getServerBackups returns the details of all the local backups that exist for
+// the server, allowing the Panel to reconcile its records with the disk.
+func getServerBackups(c *gin.Context) {
+	backups, err := backup.ListLocal(c.Request.Context(), middleware.ExtractServer(c).ID(), config.Get().System.Backups.VerifyReadLimit)
+	if err != nil {
+		middleware.CaptureAndAbort(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, backups)
+}
+
 // postServerRestoreBackup handles restoring a backup for a server by downloading
 // or finding the given backup on the system and then unpacking the archive into
 // the server's data directory. If the TruncateDirectory field is provided and
@@ -76,6 +127,11 @@ func postServerRestoreBackup(c *gin.Context) {
 		// A UUID is always required for this endpoint, however the download URL
 		// is only present when the given adapter type is s3.
 		DownloadUrl string `json:"download_url"`
+		// Files optionally limits the restoration to the files matching the given
+		// patterns. When live is set the server is paused rather than stopped while
+		// these files are restored.
+		Files []string `json:"files"`
+		Live  bool     `json:"live"`
 	}
 	if err := c.BindJSON(&data); err != nil {
 		return
@@ -84,6 +140,11 @@ func postServerRestoreBackup(c *gin.Context) {
 		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "The download_url field is required when the backup adapter is set to S3."})
 		return
 	}
+	if data.Live && (len(data.Files) == 0 || data.TruncateDirectory) {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "A live restore requires the files field to be set and cannot be combined with truncate_directory."})
+		return
+	}
+	opts := server.RestoreOptions{Files: data.Files, Live: data.Live}
 
 	s.SetRestoring(true)
 	hasError := true
@@ -114,7 +175,7 @@ func postServerRestoreBackup(c *gin.Context) {
 		}
 		go func(s *server.Server, b backup.BackupInterface, logger *log.Entry) {
 			logger.Info("starting restoration process for server backup using local driver")
-			if err := s.RestoreBackup(b, nil); err != nil {
+			if err := s.RestoreBackupWithOptions(b, nil, opts); err != nil {
 				logger.WithField("error", err).Error("failed to restore local backup to server")
 			}
 			s.Events().Publish(server.DaemonMessageEvent, "Completed server restoration from local backup.")
@@ -158,7 +219,7 @@ func postServerRestoreBackup(c *gin.Context) {
 
 	go func(s *server.Server, uuid string, logger *log.Entry) {
 		logger.Info("starting restoration process for server backup using S3 driver")
-		if err := s.RestoreBackup(backup.NewS3(client, uuid, s.ID(), ""), res.Body); err != nil {
+		if err := s.RestoreBackupWithOptions(backup.NewS3(client, uuid, s.ID(), ""), res.Body, opts); err != nil {
 			logger.WithField("error", errors.WithStack(err)).Error("failed to restore remote S3 backup to server")
 		}
 		s.Events().Publish(server.DaemonMessageEvent, "Completed server restoration from S3 backup.")
@@ -171,6 +232,22 @@ func postServerRestoreBackup(c *gin.Context) {
 	c.Status(http.StatusAccepted)
 }
 
+// postServerCancelBackup cancels a backup that is currently being generated for
+// the server. If the backup is not running a 404 error is returned.
+func postServerCancelBackup(c *gin.Context) {
+	if err := middleware.ExtractServer(c).CancelBackup(c.Param("backup")); err != nil {
+		if errors.Is(err, server.ErrBackupNotRunning) {
+			c.AbortWithStatusJSON(http.StatusNotFound, gin.H{
+				"error": "The requested backup is not currently running on this server.",
+			})
+			return
+		}
+		middleware.CaptureAndAbort(c, err)
+		return
+	}
+	c.Status(http.StatusAccepted)
+}
+
 // deleteServerBackup deletes a local backup of a server. If the backup is not
 // found on the machine just return a 404 error. The service calling this
 // endpoint can make its own decisions as to how it wants to handle that
@@ -192,6 +269,12 @@ func deleteServerBackup(c *gin.Context) {
 	// locate the backup previously and it is now missing when we go to delete, just
 	// treat it as having been successful, rather than returning a 404.
 	if err := b.Remove(); err != nil && !errors.Is(err, os.ErrNotExist) {
+		if errors.Is(err, backup.ErrBackupHasDependents) {
+			c.AbortWithStatusJSON(http.StatusConflict, gin.H{
+				"error": "The requested backup cannot be deleted while incremental backups that depend on it exist.",
+			})
+			return
+		}
 		middleware.CaptureAndAbort(c, err)
 		return
 	}
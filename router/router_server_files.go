@@ -67,7 +67,7 @@ func getServerFileContents(c *gin.Context) {
 	// If a download parameter is included in the URL go ahead and attach the necessary headers
 	// so that the file can be downloaded.
 	if c.Query("download") != "" {
-		c.Header("Content-Disposition", "attachment; filename="+strconv.Quote(st.Name()))
+		c.Header("Content-Disposition", attachmentDisposition(st.Name()))
 		c.Header("Content-Type", "application/octet-stream")
 	}
 	defer c.Writer.Flush()
@@ -640,6 +640,132 @@ func postServerUploadFiles(c *gin.Context) {
 	}
 }
 
+// putServerUploadFile writes a part of a file to the server at the offset given by
+// the Content-Range header of the request. This allows large files to be uploaded
+// in parts and resumed if the upload is interrupted, with every part using its
+// own signed upload URL. The size of the file once the part has been written is
+// returned so that the client knows where to continue from. If the total size of
+// the file is provided, the upload is complete once the file reaches that size.
+func putServerUploadFile(c *gin.Context) {
+	manager := middleware.ExtractManager(c)
+
+	token := tokens.UploadPayload{}
+	if err := tokens.ParseToken([]byte(c.Query("token")), &token); err != nil {
+		middleware.CaptureAndAbort(c, err)
+		return
+	}
+
+	s, ok := manager.Get(token.ServerUuid)
+	if !ok || !token.IsUniqueRequest() {
+		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{
+			"error": "The requested resource was not found on this server.",
+		})
+		return
+	}
+
+	f := strings.TrimLeft(c.Query("file"), "/")
+	if f == "" {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
+			"error": "No file was specified for the upload.",
+		})
+		return
+	}
+	f = "/" + f
+
+	start, end, total, err := parseContentRange(c.GetHeader("Content-Range"))
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
+			"error": "Invalid or missing Content-Range header: " + err.Error(),
+		})
+		return
+	}
+	length := end - start + 1
+	if c.Request.ContentLength != -1 && c.Request.ContentLength != length {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
+			"error": "Content-Length does not match the size of the Content-Range.",
+		})
+		return
+	}
+
+	maxFileSize := config.Get().Api.UploadLimit
+	if limit := maxFileSize * 1024 * 1024; end+1 > limit || total > limit {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
+			"error": "File is larger than the maximum file upload size of " + strconv.FormatInt(maxFileSize, 10) + " MB.",
+		})
+		return
+	}
+
+	if err := s.Filesystem().IsIgnored(f); err != nil {
+		middleware.CaptureAndAbort(c, err)
+		return
+	}
+
+	size, err := s.Filesystem().WriteRange(f, c.Request.Body, start, length, total)
+	if err != nil {
+		if errors.Is(err, filesystem.ErrInvalidRange) {
+			c.AbortWithStatusJSON(http.StatusRequestedRangeNotSatisfiable, gin.H{
+				"error": "The range cannot start past the end of the file.",
+				"size":  size,
+			})
+			return
+		}
+		if filesystem.IsErrorCode(err, filesystem.ErrCodeIsDirectory) {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
+				"error": "Cannot write file, name conflicts with an existing directory by the same name.",
+			})
+			return
+		}
+		middleware.CaptureAndAbort(c, err)
+		return
+	}
+
+	complete := total >= 0 && size == total
+	if complete {
+		s.SaveActivity(s.NewRequestActivity(token.UserUuid, c.ClientIP()), server.ActivityFileUploaded, models.ActivityMeta{
+			"file":      filepath.Base(f),
+			"directory": filepath.Dir(f),
+		})
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"size":     size,
+		"complete": complete,
+	})
+}
+
+// parseContentRange parses a Content-Range header in the form of "bytes
+// start-end/total", returning the first and last byte of the range and the total
+// size. The total size is -1 if it is given as "*".
+func parseContentRange(header string) (int64, int64, int64, error) {
+	r, ok := strings.CutPrefix(header, "bytes ")
+	if !ok {
+		return 0, 0, 0, errors.New("unit must be bytes")
+	}
+	r, size, ok := strings.Cut(r, "/")
+	if !ok {
+		return 0, 0, 0, errors.New("missing total size")
+	}
+	first, last, ok := strings.Cut(r, "-")
+	if !ok {
+		return 0, 0, 0, errors.New("missing range")
+	}
+	start, err := strconv.ParseInt(first, 10, 64)
+	if err != nil || start < 0 {
+		return 0, 0, 0, errors.New("invalid range start")
+	}
+	end, err := strconv.ParseInt(last, 10, 64)
+	if err != nil || end < start {
+		return 0, 0, 0, errors.New("invalid range end")
+	}
+	total := int64(-1)
+	if size != "*" {
+		if total, err = strconv.ParseInt(size, 10, 64); err != nil || total <= end {
+			return 0, 0, 0, errors.New("invalid total size")
+		}
+	}
+	return start, end, total, nil
+}
+
 func handleFileUpload(p string, s *server.Server, header *multipart.FileHeader) error {
 	file, err := header.Open()
 	if err != nil {
@@ -5,11 +5,13 @@ import (
 	"errors"
 	"net/http"
 	"strings"
+	"time"
 
 	"github.com/apex/log"
 	"github.com/gin-gonic/gin"
 
 	"github.com/IvanX77/turbowings/config"
+	"github.com/IvanX77/turbowings/environment"
 	"github.com/IvanX77/turbowings/router/middleware"
 	"github.com/IvanX77/turbowings/server"
 	"github.com/IvanX77/turbowings/server/installer"
@@ -82,6 +84,30 @@ func getDockerDiskUsage(c *gin.Context) {
 	c.JSON(http.StatusOK, d)
 }
 
+// Returns the health of the connection between TurboWings and the Docker daemon.
+// A 503 is returned if the daemon cannot be reached.
+func getDockerHealth(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(c.Request.Context(), time.Second*5)
+	defer cancel()
+
+	cli, err := environment.Docker()
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusServiceUnavailable, gin.H{"healthy": false, "error": err.Error()})
+		return
+	}
+
+	ping, err := cli.Ping(ctx)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusServiceUnavailable, gin.H{"healthy": false, "error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"healthy":     true,
+		"api_version": ping.APIVersion,
+	})
+}
+
 // Prunes the docker image cache
 func pruneDockerImages(c *gin.Context) {
 	p, err := system.PruneDockerImages(c)
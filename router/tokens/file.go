@@ -9,6 +9,10 @@ type FilePayload struct {
 	FilePath   string `json:"file_path"`
 	ServerUuid string `json:"server_uuid"`
 	UniqueId   string `json:"unique_id"`
+
+	// FilePaths optionally lists multiple files to be downloaded at once, in which
+	// case they are bundled into a single zip archive and FilePath is ignored.
+	FilePaths []string `json:"file_paths"`
 }
 
 // Returns the JWT payload.
@@ -3,11 +3,16 @@ package tokens
 import (
 	"time"
 
+	"emperror.dev/errors"
 	"github.com/gbrlsnchs/jwt/v3"
 
 	"github.com/IvanX77/turbowings/config"
 )
 
+// ErrTokenExpired is returned by ParseToken when the token was valid but its
+// expiration time has already passed.
+var ErrTokenExpired = errors.Sentinel("tokens: token has expired")
+
 type TokenData interface {
 	GetPayload() *jwt.Payload
 }
@@ -16,6 +21,10 @@ type TokenData interface {
 // parsed data. This function DOES NOT validate that the token is valid for the connected
 // server, nor does it ensure that the user providing the token is able to actually do things.
 //
+// Every token must carry an expiration time, tokens without one are rejected just like
+// tokens that have already expired. This ensures a leaked token cannot be re-used
+// indefinitely.
+//
 // This simply returns a parsed token.
 func ParseToken(token []byte, data TokenData) error {
 	verifyOptions := jwt.ValidatePayload(
@@ -24,6 +33,12 @@ func ParseToken(token []byte, data TokenData) error {
 	)
 
 	_, err := jwt.Verify(token, config.GetJwtAlgorithm(), &data, verifyOptions)
+	if errors.Is(err, jwt.ErrExpValidation) {
+		if data.GetPayload().ExpirationTime == nil {
+			return errors.Wrap(err, "tokens: token is missing an expiration time")
+		}
+		return errors.WithStack(ErrTokenExpired)
+	}
 
 	return err
 }
@@ -21,6 +21,7 @@ const (
 	ActivitySftpDelete          = models.Event("server:sftp.delete")
 	ActivityFileUploaded        = models.Event("server:file.uploaded")
 	ActivityServerCrashed       = models.Event("server:crashed")
+	ActivityServerOomKilled     = models.Event("server:oom-killed")
 
 )
 
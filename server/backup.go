@@ -1,15 +1,24 @@
 package server
 
 import (
+	"archive/tar"
+	"context"
 	"io"
 	"io/fs"
 	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	"emperror.dev/errors"
 	"github.com/apex/log"
 	"github.com/docker/docker/client"
+	ignore "github.com/sabhiram/go-gitignore"
+	"golang.org/x/sync/semaphore"
 
+	"github.com/IvanX77/turbowings/config"
 	"github.com/IvanX77/turbowings/environment"
 	"github.com/IvanX77/turbowings/remote"
 	"github.com/IvanX77/turbowings/server/backup"
@@ -33,9 +42,42 @@ func (s *Server) notifyPanelOfBackup(uuid string, ad *backup.ArchiveDetails, suc
 	return nil
 }
 
-// Get all of the ignored files for a server based on its .pelicanignore file in the root.
+// serverwideIgnoreFiles are the files in the root of a server that patterns are
+// read from when determining which files to exclude from a backup. The files are
+// listed in order of increasing precedence.
+var serverwideIgnoreFiles = []string{".pteroignore", ".pelicanignore"}
+
+// Get all of the ignored files for a server based on the ignore files in the root
+// of the server.
+//
+// The patterns from every ignore file that is present are merged together. As with
+// gitignore the last matching pattern wins, so the files are merged in order of
+// precedence with the patterns in .pelicanignore taking precedence over those in
+// .pteroignore. If the same pattern appears more than once only the occurrence
+// with the highest precedence is kept.
 func (s *Server) getServerwideIgnoredFiles() (string, error) {
-	f, st, err := s.Filesystem().File(".pelicanignore")
+	var patterns []string
+	for _, name := range serverwideIgnoreFiles {
+		b, err := s.readIgnoreFile(name)
+		if err != nil {
+			return "", err
+		}
+		for _, line := range strings.Split(b, "\n") {
+			line = strings.TrimRight(line, "\r")
+			if strings.TrimSpace(line) == "" {
+				continue
+			}
+			patterns = slices.DeleteFunc(patterns, func(p string) bool { return p == line })
+			patterns = append(patterns, line)
+		}
+	}
+	return strings.Join(patterns, "\n"), nil
+}
+
+// readIgnoreFile returns the contents of an ignore file in the root of the server,
+// or an empty string if the file does not exist.
+func (s *Server) readIgnoreFile(name string) (string, error) {
+	f, st, err := s.Filesystem().File(name)
 	if err != nil {
 		if errors.Is(err, os.ErrNotExist) {
 			return "", nil
@@ -43,8 +85,19 @@ func (s *Server) getServerwideIgnoredFiles() (string, error) {
 		return "", err
 	}
 	defer f.Close()
-	if st.Mode()&os.ModeSymlink != 0 || st.Size() > 32*1024 {
-		// Don't read a symlinked ignore file, or a file larger than 32KiB in size.
+	if st.Mode()&os.ModeSymlink != 0 {
+		// Don't read a symlinked ignore file.
+		return "", nil
+	}
+	// Don't read an ignore file larger than the configured limit, but make sure that
+	// someone knows the exclusions in it are not being applied.
+	if limit := s.maxIgnoreFileSize(); limit > 0 && st.Size() > limit*1024 {
+		s.Log().WithFields(log.Fields{
+			"file":  name,
+			"size":  st.Size(),
+			"limit": limit * 1024,
+		}).Warn("ignore file exceeds the maximum size and will not be applied to backups")
+		s.Events().Publish(DaemonMessageEvent, "The "+name+" file is larger than the maximum size of "+strconv.FormatInt(limit, 10)+"KiB, the files listed in it will not be excluded from backups.")
 		return "", nil
 	}
 	b, err := io.ReadAll(f)
@@ -58,33 +111,66 @@ func (s *Server) getServerwideIgnoredFiles() (string, error) {
 // websocket. We let the actual backup system handle notifying the panel of the
 // status, but that won't emit a websocket event.
 func (s *Server) Backup(b backup.BackupInterface) error {
-	ignored := b.Ignored()
-	if b.Ignored() == "" {
-		if i, err := s.getServerwideIgnoredFiles(); err != nil {
-			log.WithField("server", s.ID()).WithField("error", err).Warn("failed to get server-wide ignored files")
-		} else {
-			ignored = i
+	ignored := s.backupIgnoredFiles(b)
+
+	// Register the backup so that it can be cancelled while it is queued or being
+	// generated.
+	ctx, cancel := context.WithCancel(s.Context())
+	defer cancel()
+	s.registerBackup(b.Identifier(), cancel)
+	defer s.unregisterBackup(b.Identifier())
+
+	// Wait for a backup slot to become available on the node before generating the
+	// backup, this will only return an error if the context is cancelled.
+	release, err := s.acquireBackupSlot(ctx, b)
+	if err != nil {
+		s.failBackup(b, ctx.Err() == context.Canceled)
+		return errors.WrapIf(err, "backup: failed to acquire backup slot")
+	}
+	defer release()
+
+	// Run the pre-backup command for the server, if one is defined, so that the
+	// server can write everything to the disk before the backup is generated.
+	hooks, runHooks := s.backupHooks()
+	if runHooks && hooks.Pre != "" {
+		s.Log().WithField("backup", b.Identifier()).Debug("running pre-backup command")
+		if err := s.runBackupHook(ctx, hooks.Pre, hooks.Timeout); err != nil {
+			s.failBackup(b, false)
+			return errors.WrapIf(err, "backup: pre-backup command failed")
 		}
 	}
 
-	ad, err := b.Generate(s.Context(), s.Filesystem(), ignored)
-	if err != nil {
-		if err := s.notifyPanelOfBackup(b.Identifier(), &backup.ArchiveDetails{}, false); err != nil {
-			s.Log().WithFields(log.Fields{
-				"backup": b.Identifier(),
-				"error":  err,
-			}).Warn("failed to notify panel of failed backup state")
-		} else {
-			s.Log().WithField("backup", b.Identifier()).Info("notified panel of failed backup state")
+	if timeout := config.Get().System.Backups.Timeout; timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, time.Duration(timeout)*time.Minute)
+		defer cancel()
+	}
+
+	ad, err := b.Generate(ctx, s.Filesystem(), ignored)
+
+	// The post-backup command is run regardless of whether the backup was generated
+	// successfully, since the pre-backup command may have changed the state of the
+	// server. A failure here does not affect the backup itself.
+	if runHooks && hooks.Post != "" {
+		s.Log().WithField("backup", b.Identifier()).Debug("running post-backup command")
+		if err := s.runBackupHook(s.Context(), hooks.Post, hooks.Timeout); err != nil {
+			s.Log().WithField("backup", b.Identifier()).WithField("error", err).Warn("post-backup command failed")
+			s.Events().Publish(DaemonMessageEvent, "The post-backup command failed: "+err.Error())
 		}
+	}
 
-		s.Events().Publish(BackupCompletedEvent+":"+b.Identifier(), map[string]interface{}{
-			"uuid":          b.Identifier(),
-			"is_successful": false,
-			"checksum":      "",
-			"checksum_type": "sha1",
-			"file_size":     0,
-		})
+	if err != nil {
+		cancelled := errors.Is(err, context.Canceled) && s.Context().Err() == nil
+		if errors.Is(err, context.DeadlineExceeded) {
+			s.Log().WithField("backup", b.Identifier()).Warn("backup generation exceeded the configured timeout and was cancelled")
+		}
+		if cancelled {
+			// Make sure nothing from the partially generated archive is left behind.
+			if err := b.Remove(); err != nil && !errors.Is(err, os.ErrNotExist) {
+				s.Log().WithField("backup", b.Identifier()).WithField("error", err).Warn("failed to remove partial archive for cancelled backup")
+			}
+		}
+		s.failBackup(b, cancelled)
 
 		return errors.WrapIf(err, "backup: error while generating server backup")
 	}
@@ -108,23 +194,211 @@ func (s *Server) Backup(b backup.BackupInterface) error {
 		"checksum":      ad.Checksum,
 		"checksum_type": "sha1",
 		"file_size":     ad.Size,
+		"compression":   b.CompressionLevel(),
+	})
+
+	return nil
+}
+
+// backupHooks returns the commands to run inside the server container before and
+// after a backup, and whether they should be run. The commands are only run while
+// the server is running, otherwise there is nothing for them to flush.
+func (s *Server) backupHooks() (remote.BackupHooks, bool) {
+	cfg := s.ProcessConfiguration()
+	if cfg == nil || s.Environment == nil || s.Environment.State() != environment.ProcessRunningState {
+		return remote.BackupHooks{}, false
+	}
+	return cfg.Backup, true
+}
+
+// runBackupHook runs a backup hook command inside the server container using the
+// shell of the container, and waits for it to complete. An error is returned if
+// the command does not exit successfully within the timeout, which defaults to
+// one minute.
+func (s *Server) runBackupHook(ctx context.Context, command string, timeout int) error {
+	if timeout <= 0 {
+		timeout = 60
+	}
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(timeout)*time.Second)
+	defer cancel()
+
+	code, out, err := s.Environment.Exec(ctx, []string{"/bin/sh", "-c", command})
+	if err != nil {
+		if errors.Is(err, context.DeadlineExceeded) {
+			return errors.Errorf("command did not complete within %d seconds", timeout)
+		}
+		return err
+	}
+	if code != 0 {
+		return errors.Errorf("command exited with code %d: %s", code, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// failBackup notifies the Panel that a backup did not complete and emits an
+// event over the websocket so the frontend can update the backup in realtime.
+func (s *Server) failBackup(b backup.BackupInterface, cancelled bool) {
+	if cancelled {
+		s.Log().WithField("backup", b.Identifier()).Info("backup was cancelled")
+	}
+	if err := s.notifyPanelOfBackup(b.Identifier(), &backup.ArchiveDetails{}, false); err != nil {
+		s.Log().WithFields(log.Fields{
+			"backup": b.Identifier(),
+			"error":  err,
+		}).Warn("failed to notify panel of failed backup state")
+	} else {
+		s.Log().WithField("backup", b.Identifier()).Info("notified panel of failed backup state")
+	}
+
+	s.Events().Publish(BackupCompletedEvent+":"+b.Identifier(), map[string]interface{}{
+		"uuid":          b.Identifier(),
+		"is_successful": false,
+		"is_cancelled":  cancelled,
+		"checksum":      "",
+		"checksum_type": "sha1",
+		"file_size":     0,
 	})
+}
 
+// CancelBackup cancels a backup that is currently queued or being generated for
+// the server. The partially generated archive is removed and the Panel is
+// notified that the backup failed. If no backup with the given identifier is
+// running ErrBackupNotRunning is returned.
+func (s *Server) CancelBackup(identifier string) error {
+	s.backupsMu.Lock()
+	defer s.backupsMu.Unlock()
+	cancel, ok := s.backups[identifier]
+	if !ok {
+		return ErrBackupNotRunning
+	}
+	cancel()
 	return nil
 }
 
+// registerBackup tracks the cancel function for a running backup.
+func (s *Server) registerBackup(identifier string, cancel context.CancelFunc) {
+	s.backupsMu.Lock()
+	defer s.backupsMu.Unlock()
+	if s.backups == nil {
+		s.backups = make(map[string]context.CancelFunc)
+	}
+	s.backups[identifier] = cancel
+}
+
+// unregisterBackup stops tracking a backup once it is no longer running.
+func (s *Server) unregisterBackup(identifier string) {
+	s.backupsMu.Lock()
+	defer s.backupsMu.Unlock()
+	delete(s.backups, identifier)
+}
+
+var (
+	backupSlotsOnce sync.Once
+	backupSlots     *semaphore.Weighted
+)
+
+// acquireBackupSlot blocks until the backup is able to run without exceeding the
+// maximum number of concurrent backups for the node, and returns a function that
+// must be called to release the slot once the backup is complete. If the backup
+// has to wait for a slot an event is emitted so the Panel can show that it is
+// queued.
+func (s *Server) acquireBackupSlot(ctx context.Context, b backup.BackupInterface) (func(), error) {
+	backupSlotsOnce.Do(func() {
+		if n := config.Get().System.Backups.MaxConcurrent; n > 0 {
+			backupSlots = semaphore.NewWeighted(int64(n))
+		}
+	})
+	if backupSlots == nil {
+		return func() {}, nil
+	}
+
+	if !backupSlots.TryAcquire(1) {
+		s.Log().WithField("backup", b.Identifier()).Info("maximum number of concurrent backups reached, waiting for a backup slot")
+		s.Events().Publish(BackupQueuedEvent+":"+b.Identifier(), map[string]interface{}{
+			"uuid": b.Identifier(),
+		})
+		if err := backupSlots.Acquire(ctx, 1); err != nil {
+			return nil, err
+		}
+	}
+	return func() { backupSlots.Release(1) }, nil
+}
+
+// BackupPreview returns the files that would be included in the backup, and
+// their total size, using the same ignore rules as Backup. No archive is created.
+func (s *Server) BackupPreview(ctx context.Context, b backup.BackupInterface) (*backup.PreviewDetails, error) {
+	return b.Preview(ctx, s.Filesystem(), s.backupIgnoredFiles(b))
+}
+
+// backupIgnoredFiles returns the ignore patterns for a backup, falling back to
+// the server-wide ignore files when the backup does not specify any.
+func (s *Server) backupIgnoredFiles(b backup.BackupInterface) string {
+	ignored := b.Ignored()
+	if ignored == "" {
+		if i, err := s.getServerwideIgnoredFiles(); err != nil {
+			log.WithField("server", s.ID()).WithField("error", err).Warn("failed to get server-wide ignored files")
+		} else {
+			ignored = i
+		}
+	}
+	return ignored
+}
+
+// RestoreOptions controls how a backup is restored to a server.
+type RestoreOptions struct {
+	// Files limits the restoration to the files in the backup that match one of
+	// the given patterns, using the same syntax as a .gitignore file. If no
+	// patterns are provided every file in the backup is restored.
+	Files []string
+
+	// Live restores the files without stopping the server. The server is paused
+	// while the files are being written instead, and then resumed once they have
+	// all been restored.
+	//
+	// This is only safe for files that the server is able to handle changing
+	// underneath it, such as configuration files or plugins that are reloaded, so
+	// it must be explicitly requested and is only allowed when restoring a subset
+	// of the files in the backup.
+	Live bool
+}
+
 // RestoreBackup calls the Restore function on the provided backup. Once this
 // restoration is completed an event is emitted to the websocket to notify the
 // Panel that is has been completed.
 //
 // In addition to the websocket event an API call is triggered to notify the
 // Panel of the new state.
-func (s *Server) RestoreBackup(b backup.BackupInterface, reader io.ReadCloser) (err error) {
-	s.Config().SetSuspended(true)
+func (s *Server) RestoreBackup(b backup.BackupInterface, reader io.ReadCloser) error {
+	return s.RestoreBackupWithOptions(b, reader, RestoreOptions{})
+}
+
+// RestoreBackupWithOptions restores the provided backup in the same way as
+// RestoreBackup, using the given options to control which files are restored and
+// whether the server is stopped while restoring them.
+func (s *Server) RestoreBackupWithOptions(b backup.BackupInterface, reader io.ReadCloser, opts RestoreOptions) (err error) {
+	if opts.Live && len(opts.Files) == 0 {
+		if reader != nil {
+			_ = reader.Close()
+		}
+		return errors.New("server/backup: restore: a live restore requires the files to restore to be specified")
+	}
+
+	var matcher *ignore.GitIgnore
+	if len(opts.Files) > 0 {
+		matcher = ignore.CompileIgnoreLines(opts.Files...)
+	}
+
+	// A live restore leaves the server running, so it must not be suspended as that
+	// would cause it to be stopped if the server is synced during the restoration.
+	if !opts.Live {
+		s.Config().SetSuspended(true)
+	}
 	// Local backups will not pass a reader through to this function, so check first
 	// to make sure it is a valid reader before trying to close it.
 	defer func() {
-		s.Config().SetSuspended(false)
+		if !opts.Live {
+			s.Config().SetSuspended(false)
+		}
 		if reader != nil {
 			_ = reader.Close()
 		}
@@ -137,10 +411,25 @@ func (s *Server) RestoreBackup(b backup.BackupInterface, reader io.ReadCloser) (
 		}
 	}()
 
-	// Don't try to restore the server until we have completely stopped the running
-	// instance, otherwise you'll likely hit all types of write errors due to the
-	// server being suspended.
-	if s.Environment.State() != environment.ProcessOfflineState {
+	if opts.Live {
+		// Pause the server rather than stopping it, so that it does not read any of
+		// the files while they are only partially written.
+		if s.Environment.State() != environment.ProcessOfflineState {
+			s.Log().Info("pausing server for live backup restoration")
+			if err = s.Environment.Pause(s.Context()); err != nil {
+				return errors.WrapIf(err, "server/backup: restore: failed to pause server")
+			}
+			defer func() {
+				// Use a fresh context so the server is never left paused.
+				if uerr := s.Environment.Unpause(context.Background()); uerr != nil {
+					s.Log().WithField("error", uerr).Error("failed to unpause server after live backup restoration")
+				}
+			}()
+		}
+	} else if s.Environment.State() != environment.ProcessOfflineState {
+		// Don't try to restore the server until we have completely stopped the running
+		// instance, otherwise you'll likely hit all types of write errors due to the
+		// server being suspended.
 		if err = s.Environment.WaitForStop(s.Context(), 2*time.Minute, false); err != nil {
 			if !client.IsErrNotFound(err) {
 				return errors.WrapIf(err, "server/backup: restore: failed to wait for container stop")
@@ -153,11 +442,14 @@ func (s *Server) RestoreBackup(b backup.BackupInterface, reader io.ReadCloser) (
 	s.Log().Debug("starting file writing process for backup restoration")
 	err = b.Restore(s.Context(), reader, func(file string, info fs.FileInfo, r io.ReadCloser) error {
 		defer r.Close()
+		if matcher != nil && !matcher.MatchesPath(file) {
+			return nil
+		}
 		s.Events().Publish(DaemonMessageEvent, "(restoring): "+file)
 		// TODO: since this will be called a lot, it may be worth adding an optimized
 		// Write with Chtimes method to the UnixFS that is able to re-use the
 		// same dirfd and file name.
-		if err := s.Filesystem().Write(file, r, info.Size(), info.Mode()); err != nil {
+		if err := s.writeRestoredFile(file, info, r); err != nil {
 			return err
 		}
 		atime := info.ModTime()
@@ -166,3 +458,24 @@ func (s *Server) RestoreBackup(b backup.BackupInterface, reader io.ReadCloser) (
 
 	return errors.WithStackIf(err)
 }
+
+// restoreOwner returns the uid and gid that a file restored from a backup should
+// be owned by, based on the configured restore ownership mode.
+func restoreOwner(info fs.FileInfo) (int, int) {
+	cfg := config.Get().System
+	uid, gid := cfg.User.Uid, cfg.User.Gid
+	if cfg.Backups.RestoreOwnership != "preserve" {
+		return uid, gid
+	}
+	// Only tar archives record the owner of a file. Files owned by root are never
+	// restored as root, otherwise the server would be unable to modify them.
+	if h, ok := info.Sys().(*tar.Header); ok {
+		if h.Uid > 0 {
+			uid = h.Uid
+		}
+		if h.Gid > 0 {
+			gid = h.Gid
+		}
+	}
+	return uid, gid
+}
@@ -51,8 +51,17 @@ type BackupInterface interface {
 	// Generate creates a backup in whatever the configured source for the
 	// specific implementation is.
 	Generate(context.Context, *filesystem.Filesystem, string) (*ArchiveDetails, error)
+	// Preview returns the files that would be included in the backup if it
+	// were generated, without creating an archive.
+	Preview(context.Context, *filesystem.Filesystem, string) (*PreviewDetails, error)
 	// Ignored returns the ignored files for this backup instance.
 	Ignored() string
+	// SetCompressionLevel sets the level of compression used when generating
+	// the backup archive.
+	SetCompressionLevel(string)
+	// CompressionLevel returns the level of compression used when generating
+	// the backup archive.
+	CompressionLevel() string
 	// Checksum returns a SHA1 checksum for the generated backup.
 	Checksum() ([]byte, error)
 	// Size returns the size of the generated backup.
@@ -83,6 +92,14 @@ type Backup struct {
 	// compatible with a standard .gitignore structure.
 	Ignore string `json:"ignore"`
 
+	// The level of compression to use when generating this backup, if empty the
+	// compression level from the configuration is used.
+	Compression string `json:"compression_level"`
+
+	// The name of the backup file on the disk, if unset the backup is named using
+	// its UUID.
+	filename string
+
 	client     remote.Client
 	adapter    AdapterType
 	logContext map[string]interface{}
@@ -100,7 +117,11 @@ func (b *Backup) ServerId() string { return b.ServerUuid }
 
 // Path returns the path for this specific backup.
 func (b *Backup) Path() string {
-	return path.Join(config.Get().System.BackupDirectory, b.ServerId(), b.Identifier()+".tar.gz")
+	name := b.filename
+	if name == "" {
+		name = b.Identifier() + ".tar.gz"
+	}
+	return path.Join(config.Get().System.BackupDirectory, b.ServerId(), name)
 }
 
 // Size returns the size of the generated backup.
@@ -161,10 +182,57 @@ func (b *Backup) Details(ctx context.Context, parts []remote.BackupPart) (*Archi
 	return &ad, nil
 }
 
+// Preview walks the filesystem using the same inclusion rules as Generate and
+// returns every file that would be included in the backup along with their
+// total size. No archive is created.
+func (b *Backup) Preview(ctx context.Context, fsys *filesystem.Filesystem, ignore string) (*PreviewDetails, error) {
+	files, err := newArchive(fsys, ignore).List(ctx)
+	if err != nil {
+		return nil, errors.WrapIf(err, "backup: failed to list files for backup preview")
+	}
+	pd := PreviewDetails{Files: files}
+	if pd.Files == nil {
+		pd.Files = []filesystem.ArchiveEntry{}
+	}
+	for _, f := range files {
+		pd.Size += f.Size
+	}
+	return &pd, nil
+}
+
+// newArchive returns the archive used to generate a backup of the filesystem,
+// excluding any files that match the ignore patterns. Symbolic links are handled
+// according to the configuration, unless explicitly configured otherwise they
+// are skipped so that nothing outside the server root is read.
+func newArchive(fsys *filesystem.Filesystem, ignore string) *filesystem.Archive {
+	symlinks := filesystem.ArchiveSymlinkMode(config.Get().System.Backups.Symlinks)
+	if symlinks != filesystem.ArchiveSymlinksStore && symlinks != filesystem.ArchiveSymlinksFollow {
+		symlinks = filesystem.ArchiveSymlinksSkip
+	}
+	return &filesystem.Archive{
+		Filesystem: fsys,
+		Ignore:     ignore,
+		Symlinks:   symlinks,
+	}
+}
+
 func (b *Backup) Ignored() string {
 	return b.Ignore
 }
 
+func (b *Backup) SetCompressionLevel(level string) {
+	b.Compression = level
+}
+
+// CompressionLevel returns the level of compression used for this backup,
+// falling back to the configured compression level if none was selected.
+func (b *Backup) CompressionLevel() string {
+	if b.Compression == "" {
+		return config.Get().System.Backups.CompressionLevel
+	}
+	return b.Compression
+}
+
 // Returns a logger instance for this backup with the additional context fields
 // assigned to the output.
 func (b *Backup) log() *log.Entry {
@@ -182,6 +250,12 @@ type ArchiveDetails struct {
 	Parts        []remote.BackupPart `json:"parts"`
 }
 
+// PreviewDetails contains the files that would be included in a backup.
+type PreviewDetails struct {
+	Files []filesystem.ArchiveEntry `json:"files"`
+	Size  int64                     `json:"size"`
+}
+
 // ToRequest returns a request object.
 func (ad *ArchiveDetails) ToRequest(successful bool) remote.BackupRequest {
 	return remote.BackupRequest{
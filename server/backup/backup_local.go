@@ -2,28 +2,48 @@ package backup
 
 import (
 	"context"
+	"crypto/sha1"
+	"encoding/hex"
 	"io"
 	"os"
 	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"text/template"
+	"time"
 
 	"emperror.dev/errors"
+	"github.com/google/uuid"
 	"github.com/juju/ratelimit"
 	"github.com/mholt/archives"
 
 	"github.com/IvanX77/turbowings/config"
+	"github.com/IvanX77/turbowings/internal/ufs"
 	"github.com/IvanX77/turbowings/remote"
 	"github.com/IvanX77/turbowings/server/filesystem"
 )
 
 type LocalBackup struct {
 	Backup
+
+	// ServerName is the name of the server the backup is associated with, this is
+	// only used when naming the backup with the configured filename template.
+	ServerName string
+
+	// Parent is the UUID of an earlier local backup of the server. If it is set,
+	// only the files that changed since the parent was generated are written to
+	// the backup, and restoring the backup restores the unchanged files from the
+	// earlier backups in the chain. A backup cannot be removed while there are
+	// incremental backups that were generated against it.
+	Parent string
 }
 
 var _ BackupInterface = (*LocalBackup)(nil)
 
 func NewLocal(client remote.Client, uuid string, suuid string, ignore string) *LocalBackup {
 	return &LocalBackup{
-		Backup{
+		Backup: Backup{
 			client:     client,
 			Uuid:       uuid,
 			ServerUuid: suuid,
@@ -37,6 +57,9 @@ func NewLocal(client remote.Client, uuid string, suuid string, ignore string) *L
 // will obviously only work if the backup was created as a local backup.
 func LocateLocal(client remote.Client, uuid string, suuid string) (*LocalBackup, os.FileInfo, error) {
 	b := NewLocal(client, uuid, suuid, "")
+	if err := b.resolveFilename(); err != nil {
+		return nil, nil, err
+	}
 	st, err := os.Stat(b.Path())
 	if err != nil {
 		return nil, nil, err
@@ -49,12 +72,340 @@ func LocateLocal(client remote.Client, uuid string, suuid string) (*LocalBackup,
 	return b, st, nil
 }
 
-// Remove removes a backup from the system.
+// LocalBackupInfo contains the details of a backup stored on the local disk.
+type LocalBackupInfo struct {
+	Uuid         string    `json:"uuid"`
+	Size         int64     `json:"size"`
+	ModifiedAt   time.Time `json:"modified_at"`
+	Checksum     string    `json:"checksum"`
+	ChecksumType string    `json:"checksum_type"`
+}
+
+// ListLocal returns the details of all the local backups that exist for a
+// server. Backups that are still being generated are written to a temporary
+// file first and are therefore not included.
+//
+// Backups without a recorded checksum are hashed with the same read limit, in
+// MiB/s, that is used when verifying them, and the checksum is recorded.
+func ListLocal(ctx context.Context, suuid string, readLimit int) ([]LocalBackupInfo, error) {
+	entries, err := localBackupEntries(suuid)
+	if err != nil {
+		return nil, err
+	}
+
+	backups := make([]LocalBackupInfo, 0, len(entries))
+	for id, e := range entries {
+		st, err := e.Info()
+		if err != nil {
+			// The backup was removed while we were listing the directory.
+			if errors.Is(err, os.ErrNotExist) {
+				continue
+			}
+			return nil, errors.WithStack(err)
+		}
+
+		b := NewLocal(nil, id, suuid, "")
+		b.filename = e.Name()
+		sum, err := b.RecordedChecksum()
+		if err != nil {
+			return nil, err
+		}
+		// Only hash the archive if no checksum was recorded when it was created, and
+		// record it so that the archive does not need to be read again.
+		if sum == "" {
+			if sum, err = b.checksum(ctx, readLimit); err != nil {
+				if errors.Is(err, os.ErrNotExist) {
+					continue
+				}
+				return nil, err
+			}
+			if err := b.writeChecksum(sum); err != nil {
+				return nil, err
+			}
+		}
+		backups = append(backups, LocalBackupInfo{
+			Uuid:         id,
+			Size:         st.Size(),
+			ModifiedAt:   st.ModTime(),
+			Checksum:     sum,
+			ChecksumType: "sha1",
+		})
+	}
+	slices.SortFunc(backups, func(a, b LocalBackupInfo) int {
+		return a.ModifiedAt.Compare(b.ModifiedAt)
+	})
+	return backups, nil
+}
+
+// localBackupEntries returns the directory entries for all the completed local
+// backups of a server, keyed by the UUID of the backup. Backups named using the
+// filename template are matched to their UUID using the name recorded for them.
+func localBackupEntries(suuid string) (map[string]os.DirEntry, error) {
+	dir := filepath.Join(config.Get().System.BackupDirectory, suuid)
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return map[string]os.DirEntry{}, nil
+		}
+		return nil, errors.WithStack(err)
+	}
+
+	names := make(map[string]string)
+	for _, e := range entries {
+		id, ok := strings.CutSuffix(e.Name(), ".name")
+		if !ok || !e.Type().IsRegular() {
+			continue
+		}
+		b := NewLocal(nil, id, suuid, "")
+		if err := b.resolveFilename(); err != nil || b.filename == "" {
+			continue
+		}
+		names[b.filename] = id
+	}
+
+	backups := make(map[string]os.DirEntry, len(entries))
+	for _, e := range entries {
+		if id, ok := names[e.Name()]; ok {
+			if e.Type().IsRegular() {
+				backups[id] = e
+			}
+			continue
+		}
+		id, ok := strings.CutSuffix(e.Name(), ".tar.gz")
+		if !ok || !e.Type().IsRegular() {
+			continue
+		}
+		if _, err := uuid.Parse(id); err != nil {
+			continue
+		}
+		backups[id] = e
+	}
+	return backups, nil
+}
+
+// LocalVerification is the result of verifying the checksum of a local backup.
+type LocalVerification struct {
+	Uuid     string
+	Size     int64
+	Expected string
+	Actual   string
+}
+
+// Valid returns true if the checksum of the backup matches the checksum that
+// was recorded when the backup was created.
+func (v LocalVerification) Valid() bool {
+	return v.Expected == v.Actual
+}
+
+// VerifyLocal recomputes the checksums of all the local backups for a server
+// and compares them against the checksums recorded when the backups were made.
+// Backups that do not have a recorded checksum (such as those created by older
+// versions) have their current checksum recorded so later runs can verify them.
+//
+// The backups are read at no more than readLimit MiB/s, if readLimit is less
+// than 1 the read speed is unlimited.
+func VerifyLocal(ctx context.Context, suuid string, readLimit int) ([]LocalVerification, error) {
+	entries, err := localBackupEntries(suuid)
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]LocalVerification, 0, len(entries))
+	for id, e := range entries {
+		if err := ctx.Err(); err != nil {
+			return results, err
+		}
+		b := NewLocal(nil, id, suuid, "")
+		b.filename = e.Name()
+		v, err := b.verify(ctx, readLimit)
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				continue
+			}
+			return results, err
+		}
+		results = append(results, v)
+	}
+	return results, nil
+}
+
+// verify computes the checksum of the backup and compares it to the recorded
+// checksum, recording the checksum if one does not exist yet.
+func (b *LocalBackup) verify(ctx context.Context, readLimit int) (LocalVerification, error) {
+	v := LocalVerification{Uuid: b.Identifier()}
+
+	st, err := os.Stat(b.Path())
+	if err != nil {
+		return v, err
+	}
+	v.Size = st.Size()
+
+	if v.Actual, err = b.checksum(ctx, readLimit); err != nil {
+		return v, err
+	}
+
+	expected, err := b.RecordedChecksum()
+	if err != nil {
+		return v, err
+	}
+	if expected == "" {
+		v.Expected = v.Actual
+		return v, b.writeChecksum(v.Actual)
+	}
+	v.Expected = expected
+	return v, nil
+}
+
+// checksum computes the checksum of the backup, reading the archive at no more
+// than readLimit MiB/s. A limit of zero or less disables the limit.
+func (b *LocalBackup) checksum(ctx context.Context, readLimit int) (string, error) {
+	f, err := os.Open(b.Path())
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	var r io.Reader = f
+	if limit := int64(readLimit * 1024 * 1024); limit > 0 {
+		r = ratelimit.Reader(f, ratelimit.NewBucketWithRate(float64(limit), limit))
+	}
+	h := sha1.New()
+	if _, err := io.CopyBuffer(h, filesystem.NewContextReader(ctx, r), make([]byte, 1024*32)); err != nil {
+		return "", errors.WrapIf(err, "backup: failed to compute checksum of local backup")
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// checksumPath returns the path of the file used to record the checksum of the
+// backup when it was created.
+func (b *LocalBackup) checksumPath() string {
+	return b.Path() + ".sha1"
+}
+
+// namePath returns the path of the file used to record the name of the backup on
+// the disk when it was named using the filename template.
+func (b *LocalBackup) namePath() string {
+	return filepath.Join(config.Get().System.BackupDirectory, b.ServerId(), b.Identifier()+".name")
+}
+
+// resolveFilename sets the name of the backup on the disk to the one recorded when
+// it was generated. If no name was recorded the backup is named using its UUID.
+func (b *LocalBackup) resolveFilename() error {
+	v, err := os.ReadFile(b.namePath())
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return errors.WithStack(err)
+	}
+	name := strings.TrimSpace(string(v))
+	if name == "" || name != filepath.Base(name) || strings.HasPrefix(name, ".") {
+		return errors.New("backup: recorded filename for local backup is not valid: " + name)
+	}
+	b.filename = name
+	return nil
+}
+
+// filenameData is the data available to the filename template for local backups.
+type filenameData struct {
+	Name       string
+	Uuid       string
+	ServerUuid string
+	Timestamp  string
+}
+
+// unsafeFilenameCharacters matches the characters that are replaced when naming
+// a backup using the filename template.
+var unsafeFilenameCharacters = regexp.MustCompile(`[^\w.\- ]+`)
+
+// templatedFilename returns the name of the backup on the disk rendered using the
+// configured filename template. An empty string is returned if no template is
+// configured or it could not be rendered, in which case the backup is named using
+// its UUID. If a backup with the rendered name already exists the UUID is added
+// to the end of the name.
+func (b *LocalBackup) templatedFilename(t time.Time) string {
+	tmpl := config.Get().System.Backups.FilenameTemplate
+	if tmpl == "" {
+		return ""
+	}
+
+	var buf strings.Builder
+	parsed, err := template.New("filename").Option("missingkey=error").Parse(tmpl)
+	if err == nil {
+		err = parsed.Execute(&buf, filenameData{
+			Name:       b.ServerName,
+			Uuid:       b.Identifier(),
+			ServerUuid: b.ServerId(),
+			Timestamp:  t.UTC().Format("2006-01-02_15-04-05"),
+		})
+	}
+	if err != nil {
+		b.log().WithField("error", err).Warn("failed to render backup filename template, falling back to the backup UUID")
+		return ""
+	}
+
+	name := strings.Trim(unsafeFilenameCharacters.ReplaceAllString(buf.String(), "_"), ". ")
+	if name == "" {
+		return ""
+	}
+	if _, err := os.Stat(filepath.Join(filepath.Dir(b.Path()), name+".tar.gz")); err == nil {
+		name += "-" + b.Identifier()
+	}
+	return name + ".tar.gz"
+}
+
+// RecordedChecksum returns the checksum that was recorded for the backup when it
+// was created. An empty string is returned if no checksum was recorded, which is
+// the case for backups created by older versions of TurboWings.
+func (b *LocalBackup) RecordedChecksum() (string, error) {
+	v, err := os.ReadFile(b.checksumPath())
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return "", nil
+		}
+		return "", errors.WithStack(err)
+	}
+	return strings.TrimSpace(string(v)), nil
+}
+
+// RecordChecksum records the checksum of the backup, allowing the checksum to be
+// backfilled for backups that do not have one recorded.
+func (b *LocalBackup) RecordChecksum(sum string) error {
+	return b.writeChecksum(sum)
+}
+
+// writeChecksum records the checksum of the backup so that it can be verified
+// at a later point in time.
+func (b *LocalBackup) writeChecksum(sum string) error {
+	return errors.WithStack(os.WriteFile(b.checksumPath(), []byte(sum), 0o600))
+}
+
+// Remove removes a backup from the system. A backup that other incremental
+// backups were generated against cannot be removed until those backups have been
+// removed, otherwise they could no longer be restored.
 func (b *LocalBackup) Remove() error {
-	err := os.Remove(b.Path())
+	dependents, err := b.Dependents()
 	if err != nil {
 		return err
 	}
+	if len(dependents) > 0 {
+		return errors.WithMessage(ErrBackupHasDependents, "backup "+b.Identifier()+" is the parent of "+strings.Join(dependents, ", "))
+	}
+	if err := os.Remove(b.Path()); err != nil {
+		return err
+	}
+	if err := os.Remove(b.checksumPath()); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	if err := os.Remove(b.namePath()); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	if err := os.Remove(b.manifestPath()); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	if err := os.Remove(b.parentPath()); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
 	d, err := os.ReadDir(filepath.Dir(b.Path()))
 	if err != nil {
 		return err
@@ -76,33 +427,122 @@ func (b *LocalBackup) WithLogContext(c map[string]interface{}) {
 // Generate generates a backup of the selected files and pushes it to the
 // defined location for this instance.
 func (b *LocalBackup) Generate(ctx context.Context, fsys *filesystem.Filesystem, ignore string) (*ArchiveDetails, error) {
-	a := &filesystem.Archive{
-		Filesystem: fsys,
-		Ignore:     ignore,
-	}
+	a := newArchive(fsys, ignore)
+	a.CompressionLevel = b.Compression
 
-	b.log().WithField("path", b.Path()).Info("creating backup for server")
 	if _, err := os.Stat(filepath.Dir(b.Path())); os.IsNotExist(err) {
 		err := os.Mkdir(filepath.Dir(b.Path()), 0o700)
 		if err != nil {
 			return nil, err
 		}
 	}
-	if err := a.Create(ctx, b.Path()); err != nil {
+	// Record the templated name of the backup before it is written so that it can
+	// always be found using its UUID.
+	if name := b.templatedFilename(time.Now()); name != "" {
+		b.filename = name
+		if err := os.WriteFile(b.namePath(), []byte(name), 0o600); err != nil {
+			return nil, errors.WrapIf(err, "backup: failed to record filename for local backup")
+		}
+	}
+
+	parent, err := b.parentManifest()
+	if err != nil {
+		return nil, errors.WrapIf(err, "backup: failed to read manifest of parent backup")
+	}
+	if b.Parent != "" && parent == nil {
+		b.log().WithField("parent", b.Parent).Warn("parent backup does not have a manifest, creating a full backup instead")
+	}
+	m := &Manifest{Files: make(map[string]ManifestEntry)}
+	if parent != nil {
+		m.Parent = b.Parent
+		// Record the parent before the archive is written so that the parent can
+		// not be removed while this backup is being generated.
+		if err := b.writeParent(m.Parent); err != nil {
+			_ = os.Remove(b.namePath())
+			return nil, err
+		}
+	}
+	a.Skip = func(relative string, info ufs.FileInfo) bool {
+		if parent != nil {
+			if e, ok := parent.Files[relative]; ok && e.Unchanged(info) {
+				m.Files[relative] = e
+				return true
+			}
+		}
+		m.Files[relative] = newManifestEntry(b.Identifier(), info)
+		return false
+	}
+	b.log().WithField("path", b.Path()).WithField("parent", m.Parent).Info("creating backup for server")
+
+	// Write the archive to a temporary file and only move it into place once it
+	// is complete, so a partially written backup is never mistaken for a real one.
+	tmp := b.Path() + ".part"
+	if err := a.Create(ctx, tmp); err != nil {
+		_ = os.Remove(tmp)
+		_ = os.Remove(b.namePath())
+		_ = os.Remove(b.parentPath())
 		return nil, err
 	}
+	if err := os.Rename(tmp, b.Path()); err != nil {
+		_ = os.Remove(tmp)
+		_ = os.Remove(b.namePath())
+		_ = os.Remove(b.parentPath())
+		return nil, errors.WithStack(err)
+	}
+	// An incremental backup can not be restored without its manifest, whereas a
+	// full backup can still be restored but will not be usable as a parent.
+	if err := b.writeManifest(m); err != nil {
+		if m.Parent != "" {
+			_ = b.Remove()
+			return nil, err
+		}
+		b.log().WithField("error", err).Warn("failed to record manifest for local backup")
+	}
 	b.log().Info("created backup successfully")
 
 	ad, err := b.Details(ctx, nil)
 	if err != nil {
 		return nil, errors.WrapIf(err, "backup: failed to get archive details for local backup")
 	}
+	if err := b.writeChecksum(ad.Checksum); err != nil {
+		b.log().WithField("error", err).Warn("failed to record checksum for local backup")
+	}
 	return ad, nil
 }
 
 // Restore will walk over the archive and call the callback function for each
-// file encountered.
+// file encountered. Incremental backups are restored by walking over every backup
+// in the chain starting from the base backup, only calling the callback for the
+// files that were present when the incremental backup was generated.
 func (b *LocalBackup) Restore(ctx context.Context, _ io.Reader, callback RestoreCallback) error {
+	m, err := b.Manifest()
+	if err != nil {
+		return err
+	}
+	if m == nil || m.Parent == "" {
+		return b.extract(ctx, callback, nil)
+	}
+
+	chain, err := b.chain(m)
+	if err != nil {
+		return err
+	}
+	for _, l := range chain {
+		id := l.Identifier()
+		if err := l.extract(ctx, callback, func(name string) bool {
+			e, ok := m.Files[name]
+			return ok && e.Backup == id
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// extract walks over the archive and calls the callback function for each file
+// encountered, if include is set only the files it returns true for are passed
+// to the callback.
+func (b *LocalBackup) extract(ctx context.Context, callback RestoreCallback, include func(name string) bool) error {
 	f, err := os.Open(b.Path())
 	if err != nil {
 		return err
@@ -116,6 +556,9 @@ func (b *LocalBackup) Restore(ctx context.Context, _ io.Reader, callback Restore
 		reader = ratelimit.Reader(f, ratelimit.NewBucketWithRate(float64(writeLimit), writeLimit))
 	}
 	if err := format.Extract(ctx, reader, func(ctx context.Context, f archives.FileInfo) error {
+		if include != nil && !include(f.NameInArchive) {
+			return nil
+		}
 		r, err := f.Open()
 		if err != nil {
 			return err
@@ -0,0 +1,308 @@
+package backup
+
+import (
+	"context"
+	"encoding/hex"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+
+	"emperror.dev/errors"
+	. "github.com/franela/goblin"
+
+	"github.com/IvanX77/turbowings/config"
+	"github.com/IvanX77/turbowings/server/filesystem"
+)
+
+func TestLocalBackup_Incremental(t *testing.T) {
+	g := Goblin(t)
+
+	g.Describe("LocalBackup", func() {
+		var (
+			root string
+			fsys *filesystem.Filesystem
+		)
+
+		g.BeforeEach(func() {
+			c := &config.Configuration{AuthenticationToken: "abc"}
+			c.System.BackupDirectory = t.TempDir()
+			config.Set(c)
+
+			root = t.TempDir()
+			var err error
+			fsys, err = filesystem.New(root, 0, nil)
+			g.Assert(err).IsNil()
+		})
+
+		// write writes a file to the server with a modification time that is
+		// different from any previous version of the file.
+		write := func(name, contents string, mtime time.Time) {
+			p := filepath.Join(root, name)
+			g.Assert(os.MkdirAll(filepath.Dir(p), 0o755)).IsNil()
+			g.Assert(os.WriteFile(p, []byte(contents), 0o644)).IsNil()
+			g.Assert(os.Chtimes(p, mtime, mtime)).IsNil()
+		}
+
+		generate := func(id, parent string) *LocalBackup {
+			b := NewLocal(nil, id, "server", "")
+			b.Parent = parent
+			_, err := b.Generate(context.Background(), fsys, "")
+			g.Assert(err).IsNil()
+			return b
+		}
+
+		// restore restores the backup and returns the contents of every file that
+		// was passed to the callback, failing if a file is restored more than once.
+		restore := func(b *LocalBackup) (map[string]string, error) {
+			files := make(map[string]string)
+			err := b.Restore(context.Background(), nil, func(file string, _ fs.FileInfo, r io.ReadCloser) error {
+				if _, ok := files[file]; ok {
+					return errors.New("restored more than once: " + file)
+				}
+				v, err := io.ReadAll(r)
+				files[file] = string(v)
+				return err
+			})
+			return files, err
+		}
+
+		// archived returns the names of the files contained in the archive for the
+		// backup itself.
+		archived := func(b *LocalBackup) []string {
+			var names []string
+			err := b.extract(context.Background(), func(file string, _ fs.FileInfo, r io.ReadCloser) error {
+				names = append(names, file)
+				return nil
+			}, nil)
+			g.Assert(err).IsNil()
+			sort.Strings(names)
+			return names
+		}
+
+		t1 := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+		t2 := t1.Add(time.Hour)
+
+		g.It("only archives the files changed since the parent backup", func() {
+			write("server.properties", "port=1", t1)
+			write("world/level.dat", "level", t1)
+			write("world/old.dat", "old", t1)
+			full := generate("full", "")
+
+			write("server.properties", "port=25565", t2)
+			write("world/new.dat", "new", t2)
+			g.Assert(os.Remove(filepath.Join(root, "world/old.dat"))).IsNil()
+			inc := generate("incremental", full.Identifier())
+
+			g.Assert(archived(inc)).Equal([]string{"server.properties", "world/new.dat"})
+
+			m, err := inc.Manifest()
+			g.Assert(err).IsNil()
+			g.Assert(m.Parent).Equal("full")
+			g.Assert(m.Files["world/level.dat"].Backup).Equal("full")
+			g.Assert(m.Files["server.properties"].Backup).Equal("incremental")
+			_, ok := m.Files["world/old.dat"]
+			g.Assert(ok).IsFalse()
+		})
+
+		g.It("restores an incremental backup on top of the backups it depends on", func() {
+			write("server.properties", "port=1", t1)
+			write("world/level.dat", "level", t1)
+			write("world/old.dat", "old", t1)
+			full := generate("full", "")
+
+			write("server.properties", "port=25565", t2)
+			g.Assert(os.Remove(filepath.Join(root, "world/old.dat"))).IsNil()
+			inc := generate("incremental", full.Identifier())
+
+			write("world/new.dat", "new", t2)
+			latest := generate("latest", inc.Identifier())
+
+			files, err := restore(latest)
+			g.Assert(err).IsNil()
+			g.Assert(files).Equal(map[string]string{
+				"server.properties": "port=25565",
+				"world/level.dat":   "level",
+				"world/new.dat":     "new",
+			})
+
+			// Restoring the full backup is unaffected by the backups generated
+			// against it.
+			files, err = restore(full)
+			g.Assert(err).IsNil()
+			g.Assert(files).Equal(map[string]string{
+				"server.properties": "port=1",
+				"world/level.dat":   "level",
+				"world/old.dat":     "old",
+			})
+		})
+
+		g.It("creates a full backup if the parent backup is missing", func() {
+			write("server.properties", "port=1", t1)
+			b := generate("incremental", "missing")
+
+			m, err := b.Manifest()
+			g.Assert(err).IsNil()
+			g.Assert(m.Parent).Equal("")
+			g.Assert(archived(b)).Equal([]string{"server.properties"})
+
+			files, err := restore(b)
+			g.Assert(err).IsNil()
+			g.Assert(files).Equal(map[string]string{"server.properties": "port=1"})
+		})
+
+		g.It("does not restore an incremental backup if a backup it depends on is missing", func() {
+			write("server.properties", "port=1", t1)
+			write("world/level.dat", "level", t1)
+			full := generate("full", "")
+			write("server.properties", "port=25565", t2)
+			inc := generate("incremental", full.Identifier())
+
+			g.Assert(os.Remove(full.Path())).IsNil()
+
+			var restored bool
+			err := inc.Restore(context.Background(), nil, func(string, fs.FileInfo, io.ReadCloser) error {
+				restored = true
+				return nil
+			})
+			g.Assert(err).IsNotNil()
+			g.Assert(err.Error()).Equal("backup: backup full in the incremental backup chain is missing")
+			g.Assert(restored).IsFalse()
+		})
+
+		g.It("does not remove a backup that incremental backups depend on", func() {
+			write("server.properties", "port=1", t1)
+			full := generate("full", "")
+			write("server.properties", "port=25565", t2)
+			inc := generate("incremental", full.Identifier())
+
+			err := full.Remove()
+			g.Assert(errors.Is(err, ErrBackupHasDependents)).IsTrue()
+			_, err = os.Stat(full.Path())
+			g.Assert(err).IsNil()
+
+			deps, err := full.Dependents()
+			g.Assert(err).IsNil()
+			g.Assert(deps).Equal([]string{"incremental"})
+
+			g.Assert(inc.Remove()).IsNil()
+			g.Assert(full.Remove()).IsNil()
+			_, err = os.Stat(full.Path())
+			g.Assert(errors.Is(err, os.ErrNotExist)).IsTrue()
+		})
+
+		g.It("lists backups using the checksum recorded for them", func() {
+			write("server.properties", "port=1", t1)
+			b := generate("0b7c7dbe-8a1f-4f4c-9a52-3c0c2e7d5c11", "")
+			g.Assert(b.RecordChecksum("recorded")).IsNil()
+
+			backups, err := ListLocal(context.Background(), "server", 0)
+			g.Assert(err).IsNil()
+			g.Assert(len(backups)).Equal(1)
+			g.Assert(backups[0].Checksum).Equal("recorded")
+
+			// A backup without a recorded checksum is hashed, and the checksum is
+			// recorded for it.
+			g.Assert(os.Remove(b.checksumPath())).IsNil()
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+			_, err = ListLocal(ctx, "server", 0)
+			g.Assert(errors.Is(err, context.Canceled)).IsTrue()
+
+			backups, err = ListLocal(context.Background(), "server", 0)
+			g.Assert(err).IsNil()
+			sum, err := b.Checksum()
+			g.Assert(err).IsNil()
+			g.Assert(backups[0].Checksum).Equal(hex.EncodeToString(sum))
+			recorded, err := b.RecordedChecksum()
+			g.Assert(err).IsNil()
+			g.Assert(recorded).Equal(backups[0].Checksum)
+		})
+	})
+}
+
+func TestLocalBackup_TemplatedFilename(t *testing.T) {
+	g := Goblin(t)
+
+	g.Describe("LocalBackup filename template", func() {
+		const id = "5d3b8c1e-2f4a-4b6c-8d9e-0a1b2c3d4e5f"
+		created := time.Date(2024, 3, 9, 14, 5, 6, 0, time.UTC)
+
+		var dir string
+		configure := func(tmpl string) {
+			c := &config.Configuration{AuthenticationToken: "abc"}
+			c.System.BackupDirectory = t.TempDir()
+			c.System.Backups.FilenameTemplate = tmpl
+			config.Set(c)
+			dir = filepath.Join(c.System.BackupDirectory, "server")
+			g.Assert(os.MkdirAll(dir, 0o700)).IsNil()
+		}
+
+		newBackup := func() *LocalBackup {
+			b := NewLocal(nil, id, "server", "")
+			b.ServerName = "Survival"
+			return b
+		}
+
+		g.It("is not used when no template is configured", func() {
+			configure("")
+			g.Assert(newBackup().templatedFilename(created)).Equal("")
+		})
+
+		g.It("renders the template", func() {
+			configure("{{.Name}}_{{.Timestamp}}_{{.ServerUuid}}_{{.Uuid}}")
+			g.Assert(newBackup().templatedFilename(created)).Equal("Survival_2024-03-09_14-05-06_server_" + id + ".tar.gz")
+		})
+
+		g.It("replaces characters that are not safe to use in a filename", func() {
+			configure("{{.Name}}")
+			b := newBackup()
+			b.ServerName = "../My/Server: \"1\"?"
+			g.Assert(b.templatedFilename(created)).Equal("_My_Server_ _1_.tar.gz")
+
+			b.ServerName = "..."
+			g.Assert(b.templatedFilename(created)).Equal("")
+		})
+
+		g.It("adds the UUID when a backup with the same name exists", func() {
+			configure("{{.Name}}")
+			g.Assert(os.WriteFile(filepath.Join(dir, "Survival.tar.gz"), nil, 0o600)).IsNil()
+			g.Assert(newBackup().templatedFilename(created)).Equal("Survival-" + id + ".tar.gz")
+		})
+
+		g.It("falls back to the UUID when the template cannot be rendered", func() {
+			for _, tmpl := range []string{"{{.Name", "{{.Missing}}"} {
+				configure(tmpl)
+				g.Assert(newBackup().templatedFilename(created)).Equal("", tmpl)
+			}
+		})
+
+		g.It("locates and lists a templated backup using its UUID", func() {
+			configure("{{.Name}}")
+			fsys, err := filesystem.New(t.TempDir(), 0, nil)
+			g.Assert(err).IsNil()
+
+			b := newBackup()
+			_, err = b.Generate(context.Background(), fsys, "")
+			g.Assert(err).IsNil()
+			g.Assert(filepath.Base(b.Path())).Equal("Survival.tar.gz")
+
+			name, err := os.ReadFile(filepath.Join(dir, id+".name"))
+			g.Assert(err).IsNil()
+			g.Assert(string(name)).Equal("Survival.tar.gz")
+
+			located, st, err := LocateLocal(nil, id, "server")
+			g.Assert(err).IsNil()
+			g.Assert(located.Path()).Equal(filepath.Join(dir, "Survival.tar.gz"))
+			g.Assert(st.Name()).Equal("Survival.tar.gz")
+
+			backups, err := ListLocal(context.Background(), "server", 0)
+			g.Assert(err).IsNil()
+			g.Assert(len(backups)).Equal(1)
+			g.Assert(backups[0].Uuid).Equal(id)
+		})
+	})
+}
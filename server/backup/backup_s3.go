@@ -53,10 +53,8 @@ func (s *S3Backup) WithLogContext(c map[string]interface{}) {
 func (s *S3Backup) Generate(ctx context.Context, fsys *filesystem.Filesystem, ignore string) (*ArchiveDetails, error) {
 	defer s.Remove()
 
-	a := &filesystem.Archive{
-		Filesystem: fsys,
-		Ignore:     ignore,
-	}
+	a := newArchive(fsys, ignore)
+	a.CompressionLevel = s.Compression
 
 	s.log().WithField("path", s.Path()).Info("creating backup for server")
 	if _, err := os.Stat(filepath.Dir(s.Path())); os.IsNotExist(err) {
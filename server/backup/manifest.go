@@ -0,0 +1,225 @@
+package backup
+
+import (
+	"compress/gzip"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"emperror.dev/errors"
+	"github.com/goccy/go-json"
+
+	"github.com/IvanX77/turbowings/config"
+)
+
+// ErrBackupHasDependents is returned when removing a local backup that other
+// incremental backups were generated against.
+var ErrBackupHasDependents = errors.Sentinel("backup: backup has incremental backups that depend on it")
+
+// Manifest records every file that was present on the server when a local backup
+// was generated, along with the backup that contains the contents of each file.
+// For a full backup every file is contained in the backup itself. An incremental
+// backup only contains the files that changed since its parent, the rest of the
+// files are contained in the earlier backups of the chain.
+type Manifest struct {
+	// Parent is the UUID of the backup that an incremental backup was generated
+	// against, this is empty for a full backup.
+	Parent string `json:"parent,omitempty"`
+
+	// Files is every file in the backup keyed by its path relative to the root
+	// of the server.
+	Files map[string]ManifestEntry `json:"files"`
+}
+
+// ManifestEntry is a single file recorded in a backup manifest.
+type ManifestEntry struct {
+	// Backup is the UUID of the backup that contains the contents of the file.
+	Backup  string      `json:"backup"`
+	Size    int64       `json:"size"`
+	ModTime time.Time   `json:"mod_time"`
+	Mode    fs.FileMode `json:"mode"`
+}
+
+// newManifestEntry returns the manifest entry for a file in the given backup.
+func newManifestEntry(backup string, info fs.FileInfo) ManifestEntry {
+	return ManifestEntry{
+		Backup:  backup,
+		Size:    info.Size(),
+		ModTime: info.ModTime(),
+		Mode:    info.Mode(),
+	}
+}
+
+// Unchanged reports whether the file described by the info is the same as the
+// file recorded in the entry. Files are compared using their size, modification
+// time and mode rather than their contents so that unchanged files never need to
+// be read.
+func (e ManifestEntry) Unchanged(info fs.FileInfo) bool {
+	return e.Size == info.Size() && e.ModTime.Equal(info.ModTime()) && e.Mode == info.Mode()
+}
+
+// manifestPath returns the path of the manifest recorded for the backup.
+func (b *LocalBackup) manifestPath() string {
+	return filepath.Join(config.Get().System.BackupDirectory, b.ServerId(), b.Identifier()+".manifest.json.gz")
+}
+
+// parentPath returns the path of the file used to record the parent of an
+// incremental backup. The parent is also recorded in the manifest, but keeping it
+// in its own file allows the backups that depend on another to be found without
+// reading every manifest.
+func (b *LocalBackup) parentPath() string {
+	return filepath.Join(config.Get().System.BackupDirectory, b.ServerId(), b.Identifier()+".parent")
+}
+
+// writeParent records the parent of an incremental backup.
+func (b *LocalBackup) writeParent(parent string) error {
+	return errors.WrapIf(os.WriteFile(b.parentPath(), []byte(parent), 0o600), "backup: failed to record parent for local backup")
+}
+
+// Dependents returns the UUIDs of the incremental backups that were generated
+// against this backup.
+func (b *LocalBackup) Dependents() ([]string, error) {
+	entries, err := os.ReadDir(filepath.Join(config.Get().System.BackupDirectory, b.ServerId()))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, errors.WithStack(err)
+	}
+
+	var dependents []string
+	for _, e := range entries {
+		id, ok := strings.CutSuffix(e.Name(), ".parent")
+		if !ok || !e.Type().IsRegular() || id == b.Identifier() {
+			continue
+		}
+		v, err := os.ReadFile(filepath.Join(config.Get().System.BackupDirectory, b.ServerId(), e.Name()))
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				continue
+			}
+			return nil, errors.WithStack(err)
+		}
+		if strings.TrimSpace(string(v)) == b.Identifier() {
+			dependents = append(dependents, id)
+		}
+	}
+	sort.Strings(dependents)
+	return dependents, nil
+}
+
+// Manifest returns the manifest recorded for the backup when it was generated,
+// or nil if there is no manifest. Backups created by older versions of TurboWings
+// do not have a manifest and are always full backups.
+func (b *LocalBackup) Manifest() (*Manifest, error) {
+	f, err := os.Open(b.manifestPath())
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, errors.WithStack(err)
+	}
+	defer f.Close()
+
+	gr, err := gzip.NewReader(f)
+	if err != nil {
+		return nil, errors.WrapIf(err, "backup: failed to read manifest for local backup")
+	}
+	defer gr.Close()
+
+	var m Manifest
+	if err := json.NewDecoder(gr).Decode(&m); err != nil {
+		return nil, errors.WrapIf(err, "backup: failed to decode manifest for local backup")
+	}
+	return &m, nil
+}
+
+// writeManifest records the manifest for the backup. The manifest is written to
+// a temporary file first so that an incomplete manifest is never read.
+func (b *LocalBackup) writeManifest(m *Manifest) error {
+	tmp := b.manifestPath() + ".part"
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	gw := gzip.NewWriter(f)
+	err = json.NewEncoder(gw).Encode(m)
+	if cerr := gw.Close(); err == nil {
+		err = cerr
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp, b.manifestPath())
+	}
+	if err != nil {
+		_ = os.Remove(tmp)
+		return errors.WrapIf(err, "backup: failed to write manifest for local backup")
+	}
+	return nil
+}
+
+// parentManifest returns the manifest of the parent backup that an incremental
+// backup is generated against. Nil is returned if the backup is not incremental,
+// or the parent does not have a manifest to compare against.
+func (b *LocalBackup) parentManifest() (*Manifest, error) {
+	if b.Parent == "" {
+		return nil, nil
+	}
+	p := NewLocal(nil, b.Parent, b.ServerId(), "")
+	if err := p.resolveFilename(); err != nil {
+		return nil, err
+	}
+	if _, err := os.Stat(p.Path()); err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, errors.WithStack(err)
+	}
+	return p.Manifest()
+}
+
+// chain returns the backups that the files recorded in the manifest are contained
+// in, ordered from the base backup to this one. An error is returned if any of the
+// backups in the chain are missing.
+func (b *LocalBackup) chain(m *Manifest) ([]*LocalBackup, error) {
+	chain := []*LocalBackup{b}
+	seen := map[string]bool{b.Identifier(): true}
+	for parent := m.Parent; parent != ""; {
+		if seen[parent] {
+			return nil, errors.New("backup: incremental backup chain contains a cycle at backup " + parent)
+		}
+		seen[parent] = true
+
+		p := NewLocal(nil, parent, b.ServerId(), "")
+		if err := p.resolveFilename(); err != nil {
+			return nil, err
+		}
+		if _, err := os.Stat(p.Path()); err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				return nil, errors.New("backup: backup " + parent + " in the incremental backup chain is missing")
+			}
+			return nil, errors.WithStack(err)
+		}
+		pm, err := p.Manifest()
+		if err != nil {
+			return nil, err
+		}
+		chain = append([]*LocalBackup{p}, chain...)
+		if pm == nil {
+			break
+		}
+		parent = pm.Parent
+	}
+
+	for name, e := range m.Files {
+		if !seen[e.Backup] {
+			return nil, errors.New("backup: backup " + e.Backup + " containing " + name + " is not in the incremental backup chain")
+		}
+	}
+	return chain, nil
+}
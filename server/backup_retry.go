@@ -0,0 +1,120 @@
+package server
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"syscall"
+	"time"
+
+	"emperror.dev/errors"
+
+	"github.com/IvanX77/turbowings/config"
+)
+
+const (
+	// restoreWriteAttempts is the number of times writing a file restored from a
+	// backup is attempted before giving up on the restoration.
+	restoreWriteAttempts = 5
+
+	// restoreRetryBackoff is the delay before the first retry of a write, this is
+	// doubled for every attempt after it.
+	restoreRetryBackoff = 250 * time.Millisecond
+
+	// restoreReplayLimit is the largest amount of data from a single file that is
+	// kept in memory so that it can be written again. The contents of a backup can
+	// only be read once, so a write that fails after reading more than this can
+	// not be retried.
+	restoreReplayLimit = 4 * 1024 * 1024
+)
+
+// transientWriteErrors are the errors returned by the system that may resolve on
+// their own, these are commonly encountered when the data directory is on network
+// backed storage.
+var transientWriteErrors = []syscall.Errno{
+	syscall.EAGAIN,
+	syscall.EINTR,
+	syscall.EBUSY,
+	syscall.ENOSPC,
+	syscall.ETIMEDOUT,
+	syscall.EIO,
+	syscall.ESTALE,
+}
+
+// isTransientWriteError reports whether the error returned when writing a file
+// may not occur again if the write is retried.
+func isTransientWriteError(err error) bool {
+	for _, errno := range transientWriteErrors {
+		if errors.Is(err, errno) {
+			return true
+		}
+	}
+	return false
+}
+
+// writeRestoredFile writes a file from a backup to the disk, retrying the write
+// with a backoff if it fails with a transient error. Permanent errors are returned
+// immediately.
+func (s *Server) writeRestoredFile(file string, info fs.FileInfo, r io.Reader) error {
+	uid, gid := restoreOwner(info)
+	rr := &replayReader{r: r, limit: restoreReplayLimit}
+	backoff := restoreRetryBackoff
+	for attempt := 1; ; attempt++ {
+		err := s.Filesystem().WriteOwned(file, rr, info.Size(), info.Mode(), uid, gid)
+		if err == nil || attempt == restoreWriteAttempts || !isTransientWriteError(err) || !rr.Rewind() {
+			return err
+		}
+
+		s.Log().WithField("file", file).WithField("attempt", attempt).WithField("error", err).Debug("retrying write of restored file after transient error")
+		if config.Get().Debug {
+			s.Events().Publish(DaemonMessageEvent, fmt.Sprintf("(retrying): %s (attempt %d of %d): %s", file, attempt+1, restoreWriteAttempts, err))
+		}
+
+		select {
+		case <-s.Context().Done():
+			return errors.WithStack(s.Context().Err())
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+// replayReader keeps the data read from the underlying reader in memory, up to a
+// limit, so that it can be read again from the start.
+type replayReader struct {
+	r          io.Reader
+	buf        []byte
+	off        int
+	limit      int
+	overflowed bool
+}
+
+// Read reads any data being replayed before reading from the underlying reader.
+func (rr *replayReader) Read(p []byte) (int, error) {
+	if rr.off < len(rr.buf) {
+		n := copy(p, rr.buf[rr.off:])
+		rr.off += n
+		return n, nil
+	}
+	n, err := rr.r.Read(p)
+	if n > 0 && !rr.overflowed {
+		if len(rr.buf)+n > rr.limit {
+			rr.overflowed = true
+			rr.buf = nil
+		} else {
+			rr.buf = append(rr.buf, p[:n]...)
+		}
+		rr.off = len(rr.buf)
+	}
+	return n, err
+}
+
+// Rewind starts reading from the beginning of the data again. False is returned
+// if more data was read than could be kept in memory.
+func (rr *replayReader) Rewind() bool {
+	if rr.overflowed {
+		return false
+	}
+	rr.off = 0
+	return true
+}
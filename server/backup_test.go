@@ -0,0 +1,240 @@
+package server
+
+import (
+	"archive/tar"
+	"context"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+
+	. "github.com/franela/goblin"
+
+	"github.com/IvanX77/turbowings/config"
+	"github.com/IvanX77/turbowings/server/filesystem"
+)
+
+func TestServer_getServerwideIgnoredFiles(t *testing.T) {
+	g := Goblin(t)
+
+	g.Describe("Server#getServerwideIgnoredFiles", func() {
+		var s *Server
+		var root string
+
+		g.BeforeEach(func() {
+			config.Set(&config.Configuration{AuthenticationToken: "abc"})
+
+			root = t.TempDir()
+			fs, err := filesystem.New(root, 0, nil)
+			g.Assert(err).IsNil()
+			s = &Server{fs: fs}
+		})
+
+		g.It("returns nothing when there are no ignore files", func() {
+			ignored, err := s.getServerwideIgnoredFiles()
+			g.Assert(err).IsNil()
+			g.Assert(ignored).Equal("")
+		})
+
+		g.It("merges the patterns from all ignore files", func() {
+			g.Assert(os.WriteFile(filepath.Join(root, ".pteroignore"), []byte("logs/\n*.tmp\n"), 0o644)).IsNil()
+			g.Assert(os.WriteFile(filepath.Join(root, ".pelicanignore"), []byte("*.tmp\r\n\ncache/\n"), 0o644)).IsNil()
+
+			ignored, err := s.getServerwideIgnoredFiles()
+			g.Assert(err).IsNil()
+			g.Assert(ignored).Equal("logs/\n*.tmp\ncache/")
+		})
+
+		g.It("skips ignore files larger than the configured limit", func() {
+			c := &config.Configuration{AuthenticationToken: "abc"}
+			c.System.Backups.MaxIgnoreFileSize = 1
+			config.Set(c)
+
+			g.Assert(os.WriteFile(filepath.Join(root, ".pteroignore"), []byte("logs/\n"), 0o644)).IsNil()
+			g.Assert(os.WriteFile(filepath.Join(root, ".pelicanignore"), []byte(strings.Repeat("cache/\n", 200)), 0o644)).IsNil()
+
+			ignored, err := s.getServerwideIgnoredFiles()
+			g.Assert(err).IsNil()
+			g.Assert(ignored).Equal("logs/")
+		})
+
+		g.It("uses the limit configured for the server instead of the node", func() {
+			c := &config.Configuration{AuthenticationToken: "abc"}
+			c.System.Backups.MaxIgnoreFileSize = 32
+			config.Set(c)
+			limit := int64(1)
+			s.cfg.Backups.MaxIgnoreFileSize = &limit
+
+			g.Assert(os.WriteFile(filepath.Join(root, ".pteroignore"), []byte("logs/\n"), 0o644)).IsNil()
+			g.Assert(os.WriteFile(filepath.Join(root, ".pelicanignore"), []byte(strings.Repeat("cache/\n", 200)), 0o644)).IsNil()
+
+			ignored, err := s.getServerwideIgnoredFiles()
+			g.Assert(err).IsNil()
+			g.Assert(ignored).Equal("logs/")
+
+			// A limit of zero for the server removes the limit for the node.
+			limit = 0
+			c.System.Backups.MaxIgnoreFileSize = 1
+			ignored, err = s.getServerwideIgnoredFiles()
+			g.Assert(err).IsNil()
+			g.Assert(strings.HasPrefix(ignored, "logs/\ncache/")).IsTrue()
+		})
+	})
+}
+
+func TestServer_CancelBackup(t *testing.T) {
+	g := Goblin(t)
+
+	g.Describe("Server#CancelBackup", func() {
+		g.It("returns an error when the backup is not running", func() {
+			s := &Server{}
+			g.Assert(s.CancelBackup("missing")).Equal(ErrBackupNotRunning)
+		})
+
+		g.It("cancels the context of a running backup", func() {
+			s := &Server{}
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			s.registerBackup("abc", cancel)
+
+			g.Assert(s.CancelBackup("abc")).IsNil()
+			g.Assert(ctx.Err()).Equal(context.Canceled)
+
+			s.unregisterBackup("abc")
+			g.Assert(s.CancelBackup("abc")).Equal(ErrBackupNotRunning)
+		})
+	})
+}
+
+func TestServer_RestoreBackupWithOptions(t *testing.T) {
+	g := Goblin(t)
+
+	g.Describe("Server#RestoreBackupWithOptions", func() {
+		g.It("refuses a live restore of every file in the backup", func() {
+			s := &Server{}
+			err := s.RestoreBackupWithOptions(nil, nil, RestoreOptions{Live: true})
+			g.Assert(err == nil).IsFalse()
+			g.Assert(s.IsSuspended()).IsFalse()
+		})
+	})
+}
+
+func TestServer_restoreOwner(t *testing.T) {
+	g := Goblin(t)
+
+	g.Describe("restoreOwner", func() {
+		header := func(uid, gid int) fs.FileInfo {
+			return (&tar.Header{Name: "server.properties", Mode: 0o644, Uid: uid, Gid: gid}).FileInfo()
+		}
+		setMode := func(mode string) {
+			c := &config.Configuration{AuthenticationToken: "abc"}
+			c.System.User.Uid = 988
+			c.System.User.Gid = 989
+			c.System.Backups.RestoreOwnership = mode
+			config.Set(c)
+		}
+
+		g.It("uses the configured user by default", func() {
+			setMode("user")
+			uid, gid := restoreOwner(header(1000, 1000))
+			g.Assert([]int{uid, gid}).Equal([]int{988, 989})
+		})
+
+		g.It("preserves the owner recorded in the archive", func() {
+			setMode("preserve")
+			uid, gid := restoreOwner(header(1000, 1001))
+			g.Assert([]int{uid, gid}).Equal([]int{1000, 1001})
+		})
+
+		g.It("never preserves root as the owner", func() {
+			setMode("preserve")
+			uid, gid := restoreOwner(header(0, 1001))
+			g.Assert([]int{uid, gid}).Equal([]int{988, 1001})
+		})
+	})
+}
+
+func TestServer_writeRestoredFileRetries(t *testing.T) {
+	g := Goblin(t)
+
+	g.Describe("isTransientWriteError", func() {
+		g.It("treats temporary system errors as transient", func() {
+			g.Assert(isTransientWriteError(&fs.PathError{Op: "write", Path: "a", Err: syscall.EAGAIN})).IsTrue()
+			g.Assert(isTransientWriteError(&fs.PathError{Op: "write", Path: "a", Err: syscall.ENOSPC})).IsTrue()
+		})
+
+		g.It("treats other errors as permanent", func() {
+			g.Assert(isTransientWriteError(&fs.PathError{Op: "open", Path: "a", Err: syscall.EACCES})).IsFalse()
+			g.Assert(isTransientWriteError(fs.ErrNotExist)).IsFalse()
+		})
+	})
+
+	g.Describe("Server#writeRestoredFile", func() {
+		g.It("gives the created directories to the owner when the first write fails", func() {
+			if os.Getuid() != 0 {
+				t.Skip("changing the owner of a file requires root")
+			}
+			c := &config.Configuration{AuthenticationToken: "abc"}
+			c.System.Backups.RestoreOwnership = "preserve"
+			config.Set(c)
+
+			root := t.TempDir()
+			fsys, err := filesystem.New(root, 0, nil)
+			g.Assert(err).IsNil()
+			s := &Server{fs: fsys, ctx: context.Background()}
+
+			info := (&tar.Header{Name: "world/region/r.0.0.mca", Mode: 0o644, Size: 5, Uid: 1000, Gid: 1001}).FileInfo()
+			r := &failOnceReader{r: strings.NewReader("hello")}
+			g.Assert(s.writeRestoredFile("world/region/r.0.0.mca", info, r)).IsNil()
+			g.Assert(r.failed).IsTrue()
+
+			b, err := os.ReadFile(filepath.Join(root, "world/region/r.0.0.mca"))
+			g.Assert(err).IsNil()
+			g.Assert(string(b)).Equal("hello")
+			for _, p := range []string{"world", "world/region", "world/region/r.0.0.mca"} {
+				st, err := os.Lstat(filepath.Join(root, p))
+				g.Assert(err).IsNil()
+				sys := st.Sys().(*syscall.Stat_t)
+				g.Assert([]uint32{sys.Uid, sys.Gid}).Equal([]uint32{1000, 1001}, p)
+			}
+		})
+	})
+
+	g.Describe("replayReader", func() {
+		g.It("replays the data that has already been read", func() {
+			rr := &replayReader{r: strings.NewReader("hello world"), limit: 64}
+			b := make([]byte, 5)
+			_, err := io.ReadFull(rr, b)
+			g.Assert(err).IsNil()
+			g.Assert(rr.Rewind()).IsTrue()
+
+			out, err := io.ReadAll(rr)
+			g.Assert(err).IsNil()
+			g.Assert(string(out)).Equal("hello world")
+		})
+
+		g.It("cannot rewind once more than the limit has been read", func() {
+			rr := &replayReader{r: strings.NewReader("hello world"), limit: 4}
+			_, err := io.ReadAll(rr)
+			g.Assert(err).IsNil()
+			g.Assert(rr.Rewind()).IsFalse()
+		})
+	})
+}
+
+// failOnceReader fails the first read with a transient error.
+type failOnceReader struct {
+	r      io.Reader
+	failed bool
+}
+
+func (f *failOnceReader) Read(p []byte) (int, error) {
+	if !f.failed {
+		f.failed = true
+		return 0, &fs.PathError{Op: "read", Path: "backup", Err: syscall.EIO}
+	}
+	return f.r.Read(p)
+}
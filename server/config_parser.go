@@ -1,14 +1,23 @@
 package server
 
 import (
+	"bytes"
+	"fmt"
+	"io"
 	"os"
 	"runtime"
-
-	"fmt"
+	"sort"
 	"strings"
+	"sync"
 
+	"emperror.dev/errors"
+	"github.com/apex/log"
 	"github.com/gammazero/workerpool"
+	"github.com/goccy/go-json"
+
+	"github.com/IvanX77/turbowings/config"
 	"github.com/IvanX77/turbowings/internal/ufs"
+	"github.com/IvanX77/turbowings/parser"
 )
 
 // Helper function to replace variables in the file path of the configuration parser
@@ -33,9 +42,36 @@ func replaceParserConfigPathVariables(filename string, envvars map[string]interf
 
 // UpdateConfigurationFiles updates all the defined configuration files for
 // a server automatically to ensure that they always use the specified values.
+//
+// If enabled, files that have not changed since they were last parsed, and whose
+// replacements have not changed either, are skipped.
+//
+// If enabled, the files are updated as a single operation, the contents of each
+// file are captured before it is modified and if any file fails to be updated
+// every file is restored to its original contents.
 func (s *Server) UpdateConfigurationFiles() {
+	// Any errors have already been logged and reported to the server console.
+	_ = s.updateConfigurationFiles()
+}
+
+// updateConfigurationFiles updates the configuration files for the server in the
+// same way as UpdateConfigurationFiles, returning the combined errors for any of
+// the files that could not be updated.
+func (s *Server) updateConfigurationFiles() error {
 	pool := workerpool.New(runtime.NumCPU())
 
+	var mu sync.Mutex
+	var errs error
+	var previous, fingerprints map[string]string
+	if config.Get().System.SkipUnchangedConfigurationFiles {
+		previous = s.readConfigurationFingerprints()
+		fingerprints = make(map[string]string)
+	}
+
+	rollback := config.Get().System.AtomicConfigurationFiles
+	var snapshots []configurationSnapshot
+	var failed bool
+
 	s.Log().Debug("acquiring process configuration files...")
 	files := s.ProcessConfiguration().ConfigurationFiles
 	s.Log().Debug("acquired process configuration files")
@@ -44,30 +80,294 @@ func (s *Server) UpdateConfigurationFiles() {
 
 		pool.Submit(func() {
 			filename := replaceParserConfigPathVariables(f.FileName, s.Config().EnvVars)
-			file, err := func() (ufs.File, error) {
-				if f.AllowCreateFile {
-					return s.Filesystem().UnixFS().Touch(filename, ufs.O_RDWR|ufs.O_CREATE, 0o644)
+			f.Root = s.Filesystem().Path()
+			for _, name := range s.configurationFileTargets(f, filename) {
+				if rollback {
+					mu.Lock()
+					stop := failed
+					mu.Unlock()
+					// Everything is going to be rolled back anyway, so don't bother
+					// updating any more files.
+					if stop {
+						return
+					}
+					snapshot, err := s.snapshotConfigurationFile(name)
+					mu.Lock()
+					if err != nil {
+						s.Log().WithField("file_name", name).WithField("error", err).Error("failed to capture configuration file before updating it")
+						failed = true
+						errs = errors.Append(errs, errors.WrapIf(err, name))
+					} else {
+						snapshots = append(snapshots, snapshot)
+					}
+					mu.Unlock()
+					if err != nil {
+						return
+					}
 				}
-				return s.Filesystem().UnixFS().Open(filename)
-			}()
-			if err != nil {
-				log := s.Log().WithField("file_name", filename)
-				if os.IsNotExist(err) && !f.AllowCreateFile {
-					log.Debug("file not created")
-				} else {
-					log.WithField("error", err).Error("failed to open file for configuration")
+
+				f.Fingerprint = previous[name]
+				fp, err := s.updateConfigurationFile(f, name)
+				mu.Lock()
+				if err != nil {
+					failed = true
+					errs = errors.Append(errs, errors.WrapIf(err, name))
+				} else if fp != "" && fingerprints != nil {
+					fingerprints[name] = fp
 				}
-				return
+				mu.Unlock()
 			}
-			defer file.Close()
+		})
+	}
+
+	pool.StopWait()
+
+	if rollback && failed {
+		s.rollbackConfigurationFiles(snapshots)
+		return errs
+	}
+
+	if fingerprints != nil {
+		s.writeConfigurationFingerprints(fingerprints)
+	}
+	return errs
+}
+
+// updateConfigurationFile applies the replacements for a configuration file to the
+// file with the given name, returning the fingerprint of the file once it has been
+// parsed successfully. A file that does not exist and is not allowed to be created
+// is not considered to be an error.
+func (s *Server) updateConfigurationFile(f parser.ConfigurationFile, filename string) (string, error) {
+	file, err := func() (ufs.File, error) {
+		if f.AllowCreateFile {
+			return s.Filesystem().UnixFS().Touch(filename, ufs.O_RDWR|ufs.O_CREATE, 0o644)
+		}
+		return s.Filesystem().UnixFS().Open(filename)
+	}()
+	if err != nil {
+		log := s.Log().WithField("file_name", filename)
+		if os.IsNotExist(err) && !f.AllowCreateFile {
+			log.Debug("file not created")
+			return "", nil
+		}
+		log.WithField("error", err).Error("failed to open file for configuration")
+		return "", err
+	}
+	defer file.Close()
+
+	res, err := f.ParseWithResult(file)
+	if err != nil {
+		s.Log().WithField("error", err).Error("failed to parse and update server configuration file")
+
+		// Let the user know that the file could not be updated, otherwise the server
+		// may start with the wrong settings without any indication as to why.
+		msg := "Failed to update configuration file " + filename + ": " + err.Error()
+		var rerr *parser.ReplacementError
+		if errors.As(err, &rerr) {
+			msg = "Failed to apply replacement for \"" + rerr.Match + "\" to configuration file " + filename + ": " + rerr.Unwrap().Error()
+		}
+		s.Events().Publish(DaemonMessageEvent, msg)
+	}
+
+	s.Log().WithFields(log.Fields{
+		"file_name": filename,
+		"applied":   res.Applied(),
+		"total":     res.Total(),
+		"created":   res.Created,
+		"updated":   res.Updated,
+		"skipped":   res.Skipped,
+		"errored":   res.Errored,
+		"unchanged": res.Unchanged,
+	}).Debug("finished processing server configuration file")
+
+	if err != nil {
+		return "", err
+	}
+	return f.Fingerprint, nil
+}
+
+// configurationSnapshot is the contents of a configuration file from before the
+// replacements were applied to it, allowing the file to be rolled back.
+type configurationSnapshot struct {
+	name    string
+	content []byte
+	mode    ufs.FileMode
+	existed bool
+}
+
+// snapshotConfigurationFile captures the current contents and mode of the
+// configuration file with the given name. Files larger than the configured limit
+// for configuration files are never read into memory.
+func (s *Server) snapshotConfigurationFile(name string) (configurationSnapshot, error) {
+	snapshot := configurationSnapshot{name: name}
+	f, st, err := s.Filesystem().File(name)
+	if err != nil {
+		if f != nil {
+			_ = f.Close()
+		}
+		if errors.Is(err, os.ErrNotExist) {
+			return snapshot, nil
+		}
+		return snapshot, err
+	}
+	defer f.Close()
 
-			if err := f.Parse(file); err != nil {
-				s.Log().WithField("error", err).Error("failed to parse and update server configuration file")
+	var r io.Reader = f
+	limit := config.Get().System.MaxConfigFileSize * 1024 * 1024
+	if limit > 0 {
+		// The file may grow while it is being read, so never read more than the limit.
+		r = io.LimitReader(f, limit+1)
+	}
+	if snapshot.content, err = io.ReadAll(r); err != nil {
+		return snapshot, errors.WithStack(err)
+	}
+	if size := int64(len(snapshot.content)); limit > 0 && size > limit {
+		return configurationSnapshot{name: name}, errors.WithStack(fmt.Errorf("%w: %d bytes exceeds the limit of %d MiB", parser.ErrFileTooLarge, size, limit/1024/1024))
+	}
+	snapshot.mode = st.Mode()
+	snapshot.existed = true
+	return snapshot, nil
+}
+
+// rollbackConfigurationFiles restores each of the configuration files to the
+// contents and mode they had when they were captured, removing any files that did
+// not exist at the time.
+func (s *Server) rollbackConfigurationFiles(snapshots []configurationSnapshot) {
+	for _, snapshot := range snapshots {
+		var err error
+		if snapshot.existed {
+			if err = s.Filesystem().Writefile(snapshot.name, bytes.NewReader(snapshot.content)); err == nil {
+				err = s.Filesystem().Chmod(snapshot.name, snapshot.mode)
 			}
+		} else if err = s.Filesystem().UnixFS().Remove(snapshot.name); errors.Is(err, os.ErrNotExist) {
+			err = nil
+		}
+		if err != nil {
+			s.Log().WithField("file_name", snapshot.name).WithField("error", err).Error("failed to roll back configuration file")
+		}
+	}
+	s.Log().WithField("files", len(snapshots)).Warn("rolled back configuration files after a file failed to update")
+	s.Events().Publish(DaemonMessageEvent, "Configuration file changes were rolled back because not every file could be updated.")
+}
 
-			s.Log().WithField("file_name", f.FileName).Debug("finished processing server configuration file")
-		})
+// configurationFileTargets returns the files that the replacements for a
+// configuration file should be applied to. If the file name refers to a directory
+// this is every regular file within it that is handled by the parser for the
+// configuration file, otherwise it is just the file itself. Symlinks within the
+// directory are never followed. The files are returned in sorted order so that
+// they are always parsed in the same order.
+func (s *Server) configurationFileTargets(f parser.ConfigurationFile, filename string) []string {
+	st, err := s.Filesystem().UnixFS().Stat(filename)
+	if err != nil || !st.IsDir() {
+		return []string{filename}
 	}
 
-	pool.StopWait()
+	var files []string
+	err = s.Filesystem().UnixFS().WalkDir(filename, func(p string, d ufs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if p != filename && !f.Recursive {
+				return ufs.SkipDir
+			}
+			return nil
+		}
+		if d.Type().IsRegular() && f.Parser.Handles(p) {
+			files = append(files, p)
+		}
+		return nil
+	})
+	if err != nil {
+		s.Log().WithField("file_name", filename).WithField("error", err).Error("failed to read directory for configuration")
+	}
+	sort.Strings(files)
+	return files
+}
+
+// configurationFingerprintsFile is the marker file in the root of the server that
+// stores the fingerprint of each configuration file from the last time it was
+// parsed.
+const configurationFingerprintsFile = ".turbowings-config.json"
+
+// readConfigurationFingerprints returns the fingerprints of the configuration files
+// from the last time they were parsed. If the marker file is missing or cannot be
+// read an empty map is returned, causing every file to be parsed.
+func (s *Server) readConfigurationFingerprints() map[string]string {
+	fingerprints := make(map[string]string)
+	f, st, err := s.Filesystem().File(configurationFingerprintsFile)
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			s.Log().WithField("error", err).Warn("failed to open configuration file fingerprints")
+		}
+		return fingerprints
+	}
+	defer f.Close()
+	// Don't read a file that is unreasonably large for the number of configuration
+	// files a server will have.
+	if st.Size() > 1024*1024 {
+		return fingerprints
+	}
+	if err := json.NewDecoder(f).Decode(&fingerprints); err != nil {
+		s.Log().WithField("error", err).Warn("failed to read configuration file fingerprints")
+		return make(map[string]string)
+	}
+	return fingerprints
+}
+
+// writeConfigurationFingerprints stores the fingerprints of the configuration files
+// in the marker file in the root of the server.
+func (s *Server) writeConfigurationFingerprints(fingerprints map[string]string) {
+	b, err := json.Marshal(fingerprints)
+	if err == nil {
+		err = s.Filesystem().Writefile(configurationFingerprintsFile, bytes.NewReader(b))
+	}
+	if err != nil {
+		s.Log().WithField("error", err).Warn("failed to write configuration file fingerprints")
+	}
+}
+
+// ConfigurationFileError is a configuration file for a server that could not be
+// parsed.
+type ConfigurationFileError struct {
+	File string
+	Err  error
+}
+
+// ValidateConfigurationFiles checks that all the defined configuration files for
+// a server can be parsed, without making any changes to them. Any files that
+// failed to parse are returned along with the error that was encountered.
+func (s *Server) ValidateConfigurationFiles() []ConfigurationFileError {
+	var errs []ConfigurationFileError
+	for _, f := range s.ProcessConfiguration().ConfigurationFiles {
+		filename := replaceParserConfigPathVariables(f.FileName, s.Config().EnvVars)
+		for _, name := range s.configurationFileTargets(f, filename) {
+			if err := s.validateConfigurationFile(f, name); err != nil {
+				errs = append(errs, ConfigurationFileError{File: name, Err: err})
+			}
+		}
+	}
+	return errs
+}
+
+// validateConfigurationFile performs a dry run of the parser against a single
+// configuration file for the server.
+func (s *Server) validateConfigurationFile(f parser.ConfigurationFile, filename string) error {
+	file, err := s.Filesystem().UnixFS().Open(filename)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			return err
+		}
+		// The file would be created when the server boots, so make sure that the
+		// replacements can be applied to an empty file.
+		if f.AllowCreateFile {
+			_, err := f.ParseBytes(nil)
+			return err
+		}
+		return nil
+	}
+	defer file.Close()
+
+	_, err = f.DryRun(file)
+	return err
 }
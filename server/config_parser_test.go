@@ -0,0 +1,165 @@
+package server
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"emperror.dev/errors"
+	. "github.com/franela/goblin"
+
+	"github.com/IvanX77/turbowings/config"
+	"github.com/IvanX77/turbowings/parser"
+	"github.com/IvanX77/turbowings/remote"
+	"github.com/IvanX77/turbowings/server/filesystem"
+)
+
+func TestServer_configurationFileTargets(t *testing.T) {
+	g := Goblin(t)
+
+	g.Describe("Server#configurationFileTargets", func() {
+		var s *Server
+
+		g.BeforeEach(func() {
+			config.Set(&config.Configuration{AuthenticationToken: "abc"})
+
+			root := t.TempDir()
+			for _, p := range []string{"config/a.json", "config/b.JSON", "config/c.yml", "config/nested/d.json"} {
+				g.Assert(os.MkdirAll(filepath.Join(root, filepath.Dir(p)), 0o755)).IsNil()
+				g.Assert(os.WriteFile(filepath.Join(root, p), []byte("{}"), 0o644)).IsNil()
+			}
+			g.Assert(os.Symlink(filepath.Join(root, "config/a.json"), filepath.Join(root, "config/link.json"))).IsNil()
+
+			fs, err := filesystem.New(root, 0, nil)
+			g.Assert(err).IsNil()
+			s = &Server{fs: fs}
+		})
+
+		g.It("returns the file itself when it is not a directory", func() {
+			f := parser.ConfigurationFile{Parser: parser.Json}
+			g.Assert(s.configurationFileTargets(f, "config/a.json")).Equal([]string{"config/a.json"})
+			g.Assert(s.configurationFileTargets(f, "missing.json")).Equal([]string{"missing.json"})
+		})
+
+		g.It("returns the files handled by the parser within a directory", func() {
+			f := parser.ConfigurationFile{Parser: parser.Json}
+			g.Assert(s.configurationFileTargets(f, "config")).Equal([]string{"config/a.json", "config/b.JSON"})
+		})
+
+		g.It("includes files in subdirectories when recursive", func() {
+			f := parser.ConfigurationFile{Parser: parser.Json, Recursive: true}
+			g.Assert(s.configurationFileTargets(f, "config")).Equal([]string{"config/a.json", "config/b.JSON", "config/nested/d.json"})
+		})
+	})
+}
+
+func TestServer_rollbackConfigurationFiles(t *testing.T) {
+	g := Goblin(t)
+
+	g.Describe("Server#rollbackConfigurationFiles", func() {
+		var s *Server
+		var root string
+
+		g.BeforeEach(func() {
+			config.Set(&config.Configuration{AuthenticationToken: "abc"})
+
+			root = t.TempDir()
+			g.Assert(os.WriteFile(filepath.Join(root, "server.properties"), []byte("server-port=1\n"), 0o644)).IsNil()
+
+			fs, err := filesystem.New(root, 0, nil)
+			g.Assert(err).IsNil()
+			s = &Server{fs: fs}
+		})
+
+		g.It("restores every file to the contents captured before it was updated", func() {
+			var snapshots []configurationSnapshot
+			for _, name := range []string{"server.properties", "config/created.yml"} {
+				snapshot, err := s.snapshotConfigurationFile(name)
+				g.Assert(err).IsNil()
+				snapshots = append(snapshots, snapshot)
+			}
+			g.Assert(snapshots[0].existed).IsTrue()
+			g.Assert(snapshots[1].existed).IsFalse()
+
+			g.Assert(os.WriteFile(filepath.Join(root, "server.properties"), []byte("server-port=25565\n"), 0o644)).IsNil()
+			g.Assert(os.MkdirAll(filepath.Join(root, "config"), 0o755)).IsNil()
+			g.Assert(os.WriteFile(filepath.Join(root, "config/created.yml"), []byte("port: 25565\n"), 0o644)).IsNil()
+
+			s.rollbackConfigurationFiles(snapshots)
+
+			b, err := os.ReadFile(filepath.Join(root, "server.properties"))
+			g.Assert(err).IsNil()
+			g.Assert(string(b)).Equal("server-port=1\n")
+			_, err = os.Stat(filepath.Join(root, "config/created.yml"))
+			g.Assert(os.IsNotExist(err)).IsTrue()
+		})
+
+		g.It("restores the mode of the file", func() {
+			g.Assert(os.Chmod(filepath.Join(root, "server.properties"), 0o600)).IsNil()
+			snapshot, err := s.snapshotConfigurationFile("server.properties")
+			g.Assert(err).IsNil()
+
+			g.Assert(os.Remove(filepath.Join(root, "server.properties"))).IsNil()
+			g.Assert(os.WriteFile(filepath.Join(root, "server.properties"), []byte("server-port=25565\n"), 0o644)).IsNil()
+
+			s.rollbackConfigurationFiles([]configurationSnapshot{snapshot})
+
+			st, err := os.Stat(filepath.Join(root, "server.properties"))
+			g.Assert(err).IsNil()
+			g.Assert(st.Mode().Perm()).Equal(os.FileMode(0o600))
+		})
+
+		g.It("does not capture files larger than the configured limit", func() {
+			c := &config.Configuration{AuthenticationToken: "abc"}
+			c.System.MaxConfigFileSize = 1
+			config.Set(c)
+			g.Assert(os.WriteFile(filepath.Join(root, "server.properties"), make([]byte, 1024*1024+1), 0o644)).IsNil()
+
+			_, err := s.snapshotConfigurationFile("server.properties")
+			g.Assert(errors.Is(err, parser.ErrFileTooLarge)).IsTrue()
+		})
+	})
+}
+
+func TestServer_updateConfigurationFiles(t *testing.T) {
+	g := Goblin(t)
+
+	g.Describe("Server#updateConfigurationFiles", func() {
+		var s *Server
+		var root string
+
+		g.BeforeEach(func() {
+			config.Set(&config.Configuration{AuthenticationToken: "abc"})
+
+			root = t.TempDir()
+			g.Assert(os.WriteFile(filepath.Join(root, "server.properties"), []byte("server-port=1\n"), 0o644)).IsNil()
+			g.Assert(os.WriteFile(filepath.Join(root, "broken.json"), []byte("{"), 0o644)).IsNil()
+
+			fs, err := filesystem.New(root, 0, nil)
+			g.Assert(err).IsNil()
+			s = &Server{fs: fs}
+		})
+
+		replace := func(match, value string) []parser.ConfigurationFileReplacement {
+			var r parser.ConfigurationFileReplacement
+			g.Assert(r.UnmarshalJSON([]byte(`{"match":"` + match + `","replace_with":"` + value + `"}`))).IsNil()
+			return []parser.ConfigurationFileReplacement{r}
+		}
+
+		g.It("returns the errors for the files that could not be updated", func() {
+			s.procConfig = &remote.ProcessConfiguration{ConfigurationFiles: []parser.ConfigurationFile{
+				{FileName: "server.properties", Parser: parser.Properties, AllowCreateFile: true, Replace: replace("server-port", "25565")},
+				{FileName: "broken.json", Parser: parser.Json, AllowCreateFile: true, Replace: replace("port", "25565")},
+			}}
+
+			err := s.updateConfigurationFiles()
+			g.Assert(err == nil).IsFalse()
+			g.Assert(strings.Contains(err.Error(), "broken.json")).IsTrue()
+
+			b, rerr := os.ReadFile(filepath.Join(root, "server.properties"))
+			g.Assert(rerr).IsNil()
+			g.Assert(string(b)).Equal("server-port=25565\n")
+		})
+	})
+}
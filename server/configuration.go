@@ -3,6 +3,7 @@ package server
 import (
 	"sync"
 
+	"github.com/IvanX77/turbowings/config"
 	"github.com/IvanX77/turbowings/environment"
 )
 
@@ -16,6 +17,15 @@ type EggConfiguration struct {
 	FileDenylist []string `json:"file_denylist"`
 }
 
+// BackupConfiguration is the backup settings for a single server, which override
+// the backup settings for the node when they are set.
+type BackupConfiguration struct {
+	// MaxIgnoreFileSize is the maximum size in KiB of an ignore file in the root of
+	// the server that will be read when generating a backup. If the value is less
+	// than 1 there is no limit, and if it is not set the limit for the node is used.
+	MaxIgnoreFileSize *int64 `json:"max_ignore_file_size,omitempty"`
+}
+
 type ConfigurationMeta struct {
 	Name        string `json:"name"`
 	Description string `json:"description"`
@@ -46,6 +56,12 @@ type Configuration struct {
 	// server process.
 	EnvVars environment.Variables `json:"environment"`
 
+	// fileEnvVars are the variables loaded from the environment file for the
+	// server. These are kept separate from EnvVars so that they are only ever
+	// passed to the server process, and are never sent back to the Panel or used
+	// when resolving paths.
+	fileEnvVars map[string]string `json:"-"`
+
 	// Labels is a map of container labels that should be applied to the running server process.
 	Labels map[string]string `json:"labels"`
 
@@ -54,13 +70,37 @@ type Configuration struct {
 	CrashDetectionEnabled bool                    `json:"crash_detection_enabled"`
 	Mounts                []Mount                 `json:"mounts"`
 	Egg                   EggConfiguration        `json:"egg,omitempty"`
+	Backups               BackupConfiguration     `json:"backups"`
 
 	Container struct {
 		// Defines the Docker image that will be used for this server
 		Image string `json:"image,omitempty"`
+		// Entrypoint and Command are defined by the egg to override the entrypoint
+		// and command of the image when the container is created, allowing images
+		// that were not built for the egg to be used. The defaults of the image are
+		// used when these are not set.
+		Entrypoint []string `json:"entrypoint,omitempty"`
+		Command    []string `json:"command,omitempty"`
 	} `json:"container,omitempty"`
 }
 
+// processEnvVars returns the environment variables that are passed to the server
+// process, which are the variables from the Panel merged with the ones from the
+// environment file for the server. Variables from the file take precedence.
+func (c *Configuration) processEnvVars() environment.Variables {
+	if len(c.fileEnvVars) == 0 {
+		return c.EnvVars
+	}
+	vars := make(environment.Variables, len(c.EnvVars)+len(c.fileEnvVars))
+	for k, v := range c.EnvVars {
+		vars[k] = v
+	}
+	for k, v := range c.fileEnvVars {
+		vars[k] = v
+	}
+	return vars
+}
+
 func (s *Server) Config() *Configuration {
 	s.cfg.mu.RLock()
 	defer s.cfg.mu.RUnlock()
@@ -74,6 +114,18 @@ func (s *Server) DiskSpace() int64 {
 	return s.cfg.Build.DiskSpace * 1024.0 * 1024.0
 }
 
+// maxIgnoreFileSize returns the maximum size in KiB of an ignore file that is read
+// when generating a backup of the server, falling back to the limit for the node
+// if the server does not override it.
+func (s *Server) maxIgnoreFileSize() int64 {
+	s.cfg.mu.RLock()
+	defer s.cfg.mu.RUnlock()
+	if s.cfg.Backups.MaxIgnoreFileSize != nil {
+		return *s.cfg.Backups.MaxIgnoreFileSize
+	}
+	return config.Get().System.Backups.MaxIgnoreFileSize
+}
+
 func (s *Server) MemoryLimit() int64 {
 	s.cfg.mu.RLock()
 	defer s.cfg.mu.RUnlock()
@@ -0,0 +1,87 @@
+package server
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode"
+
+	"emperror.dev/errors"
+
+	"github.com/IvanX77/turbowings/config"
+)
+
+// loadEnvironmentFile reads the additional environment variables for a server
+// from the environment file configured for the daemon. If no file is configured,
+// or the file for the server does not exist, no variables are returned.
+func loadEnvironmentFile(uuid string) (map[string]string, error) {
+	p := config.Get().Docker.EnvFile
+	if p == "" {
+		return nil, nil
+	}
+	p = filepath.Clean(strings.ReplaceAll(p, "${SERVER_UUID}", uuid))
+	if !filepath.IsAbs(p) {
+		return nil, errors.Errorf("server/env_file: environment file \"%s\" must be an absolute path", p)
+	}
+
+	resolved, err := filepath.EvalSymlinks(p)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, errors.Wrap(err, "server/env_file: failed to resolve environment file")
+	}
+	if d, ok := deniedHostPath(filepath.Clean(resolved)); ok {
+		return nil, errors.Errorf("server/env_file: environment file \"%s\" is not permitted as it is within \"%s\"", resolved, d)
+	}
+
+	f, err := os.Open(resolved)
+	if err != nil {
+		return nil, errors.Wrap(err, "server/env_file: failed to open environment file")
+	}
+	defer f.Close()
+
+	vars, err := parseEnvironmentFile(f)
+	if err != nil {
+		return nil, errors.WrapIf(err, "server/env_file: failed to parse environment file")
+	}
+	return vars, nil
+}
+
+// parseEnvironmentFile parses variables in the format used by Docker's --env-file
+// option. Each line is a KEY=VALUE pair with the value used as-is, blank lines and
+// lines starting with a "#" are ignored. Lines containing only a key are ignored
+// as well, rather than passing through the variable from the daemon's environment.
+//
+// Errors never include the value of a variable, only the line it was found on.
+func parseEnvironmentFile(r io.Reader) (map[string]string, error) {
+	vars := make(map[string]string)
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		line := scanner.Text()
+		if n == 1 {
+			line = strings.TrimPrefix(line, "\uFEFF")
+		}
+		line = strings.TrimLeftFunc(line, unicode.IsSpace)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			continue
+		}
+		if key == "" {
+			return nil, errors.Errorf("line %d: variable name is empty", n)
+		}
+		if strings.ContainsFunc(key, unicode.IsSpace) {
+			return nil, errors.Errorf("line %d: variable name cannot contain whitespace", n)
+		}
+		vars[key] = value
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return vars, nil
+}
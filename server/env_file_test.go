@@ -0,0 +1,116 @@
+package server
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	. "github.com/franela/goblin"
+	"github.com/goccy/go-json"
+
+	"github.com/IvanX77/turbowings/config"
+	"github.com/IvanX77/turbowings/environment"
+	"github.com/IvanX77/turbowings/remote"
+	"github.com/IvanX77/turbowings/server/filesystem"
+)
+
+func TestServer_environmentFile(t *testing.T) {
+	g := Goblin(t)
+
+	g.Describe("parseEnvironmentFile", func() {
+		g.It("parses variables and skips comments and blank lines", func() {
+			vars, err := parseEnvironmentFile(strings.NewReader("# comment\n\nAPI_KEY=abc=123\n  TOKEN= spaced \nHOST_ONLY\n"))
+			g.Assert(err).IsNil()
+			g.Assert(vars).Equal(map[string]string{"API_KEY": "abc=123", "TOKEN": " spaced "})
+		})
+
+		g.It("returns an error without the value for invalid variable names", func() {
+			_, err := parseEnvironmentFile(strings.NewReader("OK=1\nBAD KEY=secret\n"))
+			g.Assert(err == nil).IsFalse()
+			g.Assert(strings.Contains(err.Error(), "line 2")).IsTrue()
+			g.Assert(strings.Contains(err.Error(), "secret")).IsFalse()
+		})
+	})
+
+	g.Describe("Server#SyncWithConfiguration", func() {
+		var root string
+
+		g.BeforeEach(func() {
+			root = t.TempDir()
+			c := &config.Configuration{
+				AuthenticationToken: "abc",
+				DeniedMounts:        []string{filepath.Join(root, "denied")},
+			}
+			c.Docker.EnvFile = filepath.Join(root, "${SERVER_UUID}.env")
+			config.Set(c)
+		})
+
+		sync := func(s *Server) {
+			err := s.SyncWithConfiguration(remote.ServerConfigurationResponse{
+				Settings: []byte(`{"uuid":"abc","environment":{"API_KEY":"panel","PORT":"25565"}}`),
+			})
+			g.Assert(err).IsNil()
+		}
+
+		g.It("passes variables from the environment file to the server process", func() {
+			g.Assert(os.WriteFile(filepath.Join(root, "abc.env"), []byte("API_KEY=secret\nOTHER=1\n"), 0o600)).IsNil()
+
+			s := &Server{}
+			sync(s)
+			env := s.GetEnvironmentVariables()
+			g.Assert(slices.Contains(env, "API_KEY=secret")).IsTrue()
+			g.Assert(slices.Contains(env, "OTHER=1")).IsTrue()
+			g.Assert(slices.Contains(env, "PORT=25565")).IsTrue()
+			g.Assert(slices.Contains(env, "API_KEY=panel")).IsFalse()
+
+			// The variables from the file must never be stored with the ones from
+			// the Panel, since those are sent back to it.
+			g.Assert(s.Config().EnvVars.Get("API_KEY")).Equal("panel")
+			_, ok := s.Config().EnvVars["OTHER"]
+			g.Assert(ok).IsFalse()
+		})
+
+		g.It("does not return variables from the environment file in the API response", func() {
+			g.Assert(os.WriteFile(filepath.Join(root, "abc.env"), []byte("API_KEY=secret\nOTHER=1\n"), 0o600)).IsNil()
+
+			fs, err := filesystem.New(t.TempDir(), 0, nil)
+			g.Assert(err).IsNil()
+			s := &Server{fs: fs, Environment: &testEnvironment{}}
+			sync(s)
+
+			b, err := json.Marshal(s.ToAPIResponse())
+			g.Assert(err).IsNil()
+			g.Assert(strings.Contains(string(b), "secret")).IsFalse()
+			g.Assert(strings.Contains(string(b), "OTHER")).IsFalse()
+			g.Assert(strings.Contains(string(b), `"API_KEY":"panel"`)).IsTrue()
+		})
+
+		g.It("ignores a missing environment file", func() {
+			s := &Server{}
+			sync(s)
+			g.Assert(slices.Contains(s.GetEnvironmentVariables(), "API_KEY=panel")).IsTrue()
+		})
+
+		g.It("does not read an environment file within a denied path", func() {
+			g.Assert(os.MkdirAll(filepath.Join(root, "denied"), 0o755)).IsNil()
+			g.Assert(os.WriteFile(filepath.Join(root, "denied", "abc.env"), []byte("API_KEY=secret\n"), 0o600)).IsNil()
+			g.Assert(os.Symlink(filepath.Join(root, "denied", "abc.env"), filepath.Join(root, "abc.env"))).IsNil()
+
+			s := &Server{}
+			sync(s)
+			g.Assert(slices.Contains(s.GetEnvironmentVariables(), "API_KEY=panel")).IsTrue()
+		})
+	})
+}
+
+// testEnvironment is a process environment that is always offline, any methods
+// other than State panic if they are called.
+type testEnvironment struct {
+	environment.ProcessEnvironment
+}
+
+func (e *testEnvironment) State() string {
+	return environment.ProcessOfflineState
+}
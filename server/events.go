@@ -16,9 +16,11 @@ const (
 	StatsEvent                  = "stats"
 	BackupRestoreCompletedEvent = "backup restore completed"
 	BackupCompletedEvent        = "backup completed"
+	BackupQueuedEvent           = "backup queued"
 	TransferLogsEvent           = "transfer logs"
 	TransferStatusEvent         = "transfer status"
 	DeletedEvent                = "deleted"
+	OomEvent                    = "oom"
 )
 
 // Events returns the server's emitter instance.
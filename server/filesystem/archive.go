@@ -13,6 +13,7 @@ import (
 	"emperror.dev/errors"
 	"github.com/apex/log"
 	"github.com/juju/ratelimit"
+	"github.com/klauspost/compress/zip"
 	"github.com/klauspost/pgzip"
 	ignore "github.com/sabhiram/go-gitignore"
 
@@ -55,6 +56,69 @@ func (p *TarProgress) Write(v []byte) (int, error) {
 	return p.p.Write(v)
 }
 
+// ArchiveFormat is the format used when writing an archive.
+type ArchiveFormat string
+
+const (
+	// ArchiveFormatTarGzip writes a gzip compressed tarball, this is the default.
+	ArchiveFormatTarGzip ArchiveFormat = "tar.gz"
+	// ArchiveFormatZip writes a zip archive.
+	ArchiveFormatZip ArchiveFormat = "zip"
+)
+
+// Extension returns the file extension for the archive format, without the
+// leading dot.
+func (f ArchiveFormat) Extension() string {
+	if f == ArchiveFormatZip {
+		return string(ArchiveFormatZip)
+	}
+	return string(ArchiveFormatTarGzip)
+}
+
+// ArchiveSymlinkMode controls how symbolic links are written to an archive.
+type ArchiveSymlinkMode string
+
+const (
+	// ArchiveSymlinksStore writes symbolic links to the archive as links, the
+	// target of the link is never read. This is the default.
+	ArchiveSymlinksStore ArchiveSymlinkMode = "store"
+	// ArchiveSymlinksSkip excludes symbolic links from the archive entirely.
+	ArchiveSymlinksSkip ArchiveSymlinkMode = "skip"
+	// ArchiveSymlinksFollow writes the contents of the file a symbolic link
+	// points to in place of the link. Links pointing outside the root of the
+	// filesystem, to a directory, or to another link are skipped so that
+	// nothing outside the root is ever read.
+	ArchiveSymlinksFollow ArchiveSymlinkMode = "follow"
+)
+
+// Compression levels that may be used when writing a gzip compressed tarball.
+const (
+	// CompressionLevelNone stores files in the archive without compressing them.
+	CompressionLevelNone = "none"
+	// CompressionLevelBestSpeed uses gzip level 1, trading size for speed.
+	CompressionLevelBestSpeed = "best_speed"
+	// CompressionLevelBestCompression uses gzip level 9, trading speed for size.
+	CompressionLevelBestCompression = "best_compression"
+)
+
+// ParseCompressionLevel returns the compression level matching the given name.
+// In addition to the configuration values the shorter "store", "fast", and
+// "best" aliases are accepted. An empty name is returned as-is, which causes
+// the configured compression level to be used.
+func ParseCompressionLevel(name string) (string, error) {
+	switch strings.ToLower(strings.TrimSpace(name)) {
+	case "":
+		return "", nil
+	case "store", CompressionLevelNone:
+		return CompressionLevelNone, nil
+	case "fast", CompressionLevelBestSpeed:
+		return CompressionLevelBestSpeed, nil
+	case "best", CompressionLevelBestCompression:
+		return CompressionLevelBestCompression, nil
+	}
+	return "", errors.New("filesystem: unknown compression level: " + name)
+}
+
 type Archive struct {
 	// Filesystem to create the archive with.
 	Filesystem *Filesystem
@@ -74,7 +138,31 @@ type Archive struct {
 	// Progress wraps the writer of the archive to pass through the progress tracker.
 	Progress *progress.Progress
 
-	w *TarProgress
+	// Format is the format of the archive that is written, if unspecified a gzip
+	// compressed tarball is written.
+	Format ArchiveFormat
+
+	// CompressionLevel is the level of compression applied when writing a gzip
+	// compressed tarball, if unspecified the configured compression level is used.
+	CompressionLevel string
+
+	// Symlinks controls how symbolic links are written to the archive, if
+	// unspecified they are stored as links.
+	Symlinks ArchiveSymlinkMode
+
+	// SkipIgnored causes any files matching the denylist of the filesystem to be
+	// excluded from the archive.
+	SkipIgnored bool
+
+	// Skip is called with the information about every file before it is written
+	// to the archive, if it returns true the file is left out of the archive. This
+	// allows files to be excluded based on their metadata, such as when only the
+	// files changed since a previous archive should be written.
+	Skip func(relative string, info ufs.FileInfo) bool
+
+	w   *TarProgress
+	zw  *zip.Writer
+	ctx context.Context
 }
 
 // Create creates an archive at dst with all the files defined in the
@@ -113,28 +201,31 @@ func (a *Archive) Stream(ctx context.Context, w io.Writer) error {
 		return errors.New("filesystem: archive.Filesystem is unset")
 	}
 
-	// The base directory may come with a prefixed `/`, strip it to prevent
-	// problems.
-	a.BaseDirectory = strings.TrimPrefix(a.BaseDirectory, "/")
+	a.ctx = ctx
+	a.normalize()
 
-	if filesLen := len(a.Files); filesLen > 0 {
-		files := make([]string, filesLen)
-		for i, f := range a.Files {
-			if !strings.HasPrefix(f, a.Filesystem.Path()) {
-				files[i] = f
-				continue
-			}
-			files[i] = strings.TrimPrefix(strings.TrimPrefix(f, a.Filesystem.Path()), "/")
+	if a.Format == ArchiveFormatZip {
+		a.zw = zip.NewWriter(w)
+		if err := a.walk(ctx, a.addToArchive); err != nil {
+			_ = a.zw.Close()
+			return err
 		}
-		a.Files = files
+		// Closing the writer writes the central directory of the archive, without
+		// which the archive cannot be read.
+		return errors.WrapIf(a.zw.Close(), "filesystem: failed to close zip archive")
 	}
 
-	// Choose which compression level to use based on the compression_level configuration option
+	// Choose which compression level to use based on the level set on the archive,
+	// falling back to the compression_level configuration option.
+	level := a.CompressionLevel
+	if level == "" {
+		level = config.Get().System.Backups.CompressionLevel
+	}
 	var compressionLevel int
-	switch config.Get().System.Backups.CompressionLevel {
-	case "none":
+	switch level {
+	case CompressionLevelNone:
 		compressionLevel = pgzip.NoCompression
-	case "best_compression":
+	case CompressionLevelBestCompression:
 		compressionLevel = pgzip.BestCompression
 	default:
 		compressionLevel = pgzip.BestSpeed
@@ -151,24 +242,114 @@ func (a *Archive) Stream(ctx context.Context, w io.Writer) error {
 
 	a.w = NewTarProgress(tw, a.Progress)
 
+	return a.walk(ctx, a.addToArchive)
+}
+
+// ArchiveEntry is a single file that would be written to an archive.
+type ArchiveEntry struct {
+	Path string `json:"path"`
+	Size int64  `json:"size"`
+}
+
+// List walks the filesystem in the same way as Stream, applying the same
+// inclusion rules, and returns every file that would be written to the archive
+// without actually creating one.
+func (a *Archive) List(ctx context.Context) ([]ArchiveEntry, error) {
+	if a.Filesystem == nil {
+		return nil, errors.New("filesystem: archive.Filesystem is unset")
+	}
+
+	a.normalize()
+
+	var entries []ArchiveEntry
+	err := a.walk(ctx, func(dirfd int, name, relative string, d ufs.DirEntry) error {
+		s, err := d.Info()
+		if err != nil {
+			if errors.Is(err, ufs.ErrNotExist) {
+				return nil
+			}
+			return errors.WrapIff(err, "failed executing os.Lstat on '%s'", name)
+		}
+		if s.Mode()&fs.ModeSymlink != 0 {
+			switch a.Symlinks {
+			case ArchiveSymlinksSkip:
+				return nil
+			case ArchiveSymlinksFollow:
+				_, _, st, closeFd, err := a.resolveSymlink(dirfd, name, relative)
+				closeFd()
+				if err != nil {
+					return nil
+				}
+				s = st
+			}
+		}
+		// Sockets are never written to an archive, see addToArchive.
+		if s.Mode()&fs.ModeSocket != 0 {
+			return nil
+		}
+		var size int64
+		if s.Mode().IsRegular() {
+			size = s.Size()
+		}
+		entries = append(entries, ArchiveEntry{Path: relative, Size: size})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// normalize cleans up the BaseDirectory and Files so they are relative to the
+// root of the filesystem.
+func (a *Archive) normalize() {
+	// The base directory may come with a prefixed `/`, strip it to prevent
+	// problems.
+	a.BaseDirectory = strings.TrimPrefix(a.BaseDirectory, "/")
+
+	if filesLen := len(a.Files); filesLen > 0 {
+		files := make([]string, filesLen)
+		for i, f := range a.Files {
+			if !strings.HasPrefix(f, a.Filesystem.Path()) {
+				files[i] = f
+				continue
+			}
+			files[i] = strings.TrimPrefix(strings.TrimPrefix(f, a.Filesystem.Path()), "/")
+		}
+		a.Files = files
+	}
+}
+
+// walk recursively walks the base directory of the archive, calling add for
+// every file that should be included in the archive.
+func (a *Archive) walk(ctx context.Context, add walkFunc) error {
 	fs := a.Filesystem.unixFS
 
 	// If we're specifically looking for only certain files, or have requested
 	// that certain files be ignored we'll update the callback function to reflect
 	// that request.
+	var opts []walkFunc
+	if a.SkipIgnored {
+		opts = append(opts, func(_ int, _, relative string, _ ufs.DirEntry) error {
+			if a.Filesystem.IsIgnored(filepath.Join(a.BaseDirectory, relative)) != nil {
+				return SkipThis
+			}
+			return nil
+		})
+	}
 	var callback walkFunc
 	if len(a.Files) == 0 && len(a.Ignore) > 0 {
 		i := ignore.CompileIgnoreLines(strings.Split(a.Ignore, "\n")...)
-		callback = a.callback(func(_ int, _, relative string, _ ufs.DirEntry) error {
+		callback = a.callback(add, append(opts, func(_ int, _, relative string, _ ufs.DirEntry) error {
 			if i.MatchesPath(relative) {
 				return SkipThis
 			}
 			return nil
-		})
+		})...)
 	} else if len(a.Files) > 0 {
-		callback = a.withFilesCallback()
+		callback = a.withFilesCallback(add, opts...)
 	} else {
-		callback = a.callback()
+		callback = a.callback(add, opts...)
 	}
 
 	// Open the base directory we were provided.
@@ -194,7 +375,7 @@ func (a *Archive) Stream(ctx context.Context, w io.Writer) error {
 
 // Callback function used to determine if a given file should be included in the archive
 // being generated.
-func (a *Archive) callback(opts ...walkFunc) walkFunc {
+func (a *Archive) callback(add walkFunc, opts ...walkFunc) walkFunc {
 	// Get the base directory we need to strip when walking.
 	//
 	// This is important as when we are walking, the last part of the base directory
@@ -228,15 +409,15 @@ func (a *Archive) callback(opts ...walkFunc) walkFunc {
 
 		// Add the file to the archive, if it is nested in a directory,
 		// the directory will be automatically "created" in the archive.
-		return a.addToArchive(dirfd, name, relative, d)
+		return add(dirfd, name, relative, d)
 	}
 }
 
 var SkipThis = errors.New("skip this")
 
 // Pushes only files defined in the Files key to the final archive.
-func (a *Archive) withFilesCallback() walkFunc {
-	return a.callback(func(_ int, _, relative string, _ ufs.DirEntry) error {
+func (a *Archive) withFilesCallback(add walkFunc, opts ...walkFunc) walkFunc {
+	return a.callback(add, append(opts, func(_ int, _, relative string, _ ufs.DirEntry) error {
 		for _, f := range a.Files {
 			// Allow exact file matches, otherwise check if file is within a parent directory.
 			//
@@ -254,7 +435,7 @@ func (a *Archive) withFilesCallback() walkFunc {
 		}
 
 		return SkipThis
-	})
+	})...)
 }
 
 // Adds a given file path to the final archive being created.
@@ -276,30 +457,52 @@ func (a *Archive) addToArchive(dirfd int, name, relative string, entry ufs.DirEn
 	// Resolve the symlink target if the file is a symlink.
 	var target string
 	if s.Mode()&fs.ModeSymlink != 0 {
-		// Read the target of the symlink. If there are any errors we will dump them out to
-		// the logs, but we're not going to stop the backup. There are far too many cases of
-		// symlinks causing all sorts of unnecessary pain in this process. Sucks to suck if
-		// it doesn't work.
-		target, err = os.Readlink(s.Name())
-		if err != nil {
-			// Ignore the not exist errors specifically, since there is nothing important about that.
-			if !os.IsNotExist(err) {
-				log.WithField("name", name).WithField("readlink_err", err.Error()).Warn("failed reading symlink for target path; skipping...")
-			}
+		switch a.Symlinks {
+		case ArchiveSymlinksSkip:
 			return nil
+		case ArchiveSymlinksFollow:
+			// Swap the symlink out for the file it points to, the contents of which
+			// are then written to the archive under the name of the symlink.
+			fd, n, st, closeFd, err := a.resolveSymlink(dirfd, name, relative)
+			defer closeFd()
+			if err != nil {
+				log.WithField("name", name).WithField("error", err).Debug("symlink does not point to a file within the root; skipping...")
+				return nil
+			}
+			dirfd, name, s = fd, n, st
+		default:
+			// Read the target of the symlink. If there are any errors we will dump them out to
+			// the logs, but we're not going to stop the backup. There are far too many cases of
+			// symlinks causing all sorts of unnecessary pain in this process. Sucks to suck if
+			// it doesn't work.
+			target, err = a.Filesystem.unixFS.Readlinkat(dirfd, name)
+			if err != nil {
+				// Ignore the not exist errors specifically, since there is nothing important about that.
+				if !errors.Is(err, ufs.ErrNotExist) {
+					log.WithField("name", name).WithField("readlink_err", err.Error()).Warn("failed reading symlink for target path; skipping...")
+				}
+				return nil
+			}
 		}
 	}
 
+	if a.Skip != nil && a.Skip(relative, s) {
+		return nil
+	}
+
+	if a.zw != nil {
+		return a.addToZip(dirfd, name, relative, s, target)
+	}
+
 	// Get the tar FileInfoHeader in order to add the file to the archive.
 	header, err := tar.FileInfoHeader(s, filepath.ToSlash(target))
 	if err != nil {
 		return errors.WrapIff(err, "failed to get tar#FileInfoHeader for '%s'", name)
 	}
 
-	// Fix the header name if the file is not a symlink.
-	if s.Mode()&fs.ModeSymlink == 0 {
-		header.Name = relative
-	}
+	// Fix the header name, the FileInfoHeader only contains the base name of the
+	// file.
+	header.Name = relative
 
 	// Write the tar FileInfoHeader to the archive.
 	if err := a.w.WriteHeader(header); err != nil {
@@ -311,10 +514,86 @@ func (a *Archive) addToArchive(dirfd int, name, relative string, entry ufs.DirEn
 		return nil
 	}
 
+	return a.copyToArchive(a.w, dirfd, name, header.Name, header.Size)
+}
+
+// resolveSymlink resolves the symlink with the given name, returning the
+// directory file descriptor, name, and info of the regular file it points to.
+// An error is returned if the target is outside the root of the filesystem,
+// does not exist, or is not a regular file. The returned closeFd function must
+// always be called.
+func (a *Archive) resolveSymlink(dirfd int, name, relative string) (int, string, ufs.FileInfo, func(), error) {
+	closeFd := func() {}
+	target, err := a.Filesystem.unixFS.Readlinkat(dirfd, name)
+	if err != nil {
+		return 0, "", nil, closeFd, err
+	}
+
+	// Absolute targets are only followed when they are within the root of the
+	// filesystem, relative targets are resolved against the directory of the
+	// symlink. Any target that escapes the root is rejected when resolving the
+	// safe path below.
+	p := target
+	if filepath.IsAbs(p) {
+		root := a.Filesystem.Path()
+		if p != root && !strings.HasPrefix(p, root+"/") {
+			return 0, "", nil, closeFd, ufs.ErrBadPathResolution
+		}
+	} else {
+		p = filepath.Join(a.BaseDirectory, filepath.Dir(relative), p)
+	}
+
+	fd, n, closeFd, err := a.Filesystem.unixFS.SafePath(p)
+	if err != nil {
+		return 0, "", nil, closeFd, err
+	}
+	st, err := a.Filesystem.unixFS.Lstatat(fd, n)
+	if err != nil {
+		return 0, "", nil, closeFd, err
+	}
+	if !st.Mode().IsRegular() {
+		return 0, "", nil, closeFd, errors.New("filesystem: symlink target is not a regular file")
+	}
+	return fd, n, st, closeFd, nil
+}
+
+// addToZip adds the given file to the zip archive being created. Symlinks are
+// stored as links with their target as the contents rather than being followed.
+func (a *Archive) addToZip(dirfd int, name, relative string, s ufs.FileInfo, target string) error {
+	header, err := zip.FileInfoHeader(s)
+	if err != nil {
+		return errors.WrapIff(err, "failed to get zip#FileInfoHeader for '%s'", name)
+	}
+	header.Name = relative
+	if s.Mode().IsRegular() {
+		header.Method = zip.Deflate
+	}
+
+	w, err := a.zw.CreateHeader(header)
+	if err != nil {
+		return errors.WrapIff(err, "failed to write zip#FileInfoHeader for '%s'", name)
+	}
+	if s.Mode()&fs.ModeSymlink != 0 {
+		_, err := w.Write([]byte(filepath.ToSlash(target)))
+		return errors.WrapIff(err, "failed to write symlink '%s' to archive", name)
+	}
+	if !s.Mode().IsRegular() || s.Size() < 1 {
+		return nil
+	}
+
+	if a.Progress != nil {
+		a.Progress.Writer = w
+		w = a.Progress
+	}
+	return a.copyToArchive(w, dirfd, name, relative, s.Size())
+}
+
+// copyToArchive copies the contents of the file to the given archive writer.
+func (a *Archive) copyToArchive(w io.Writer, dirfd int, name, relative string, size int64) error {
 	// If the buffer size is larger than the file size, create a smaller buffer to hold the file.
 	var buf []byte
-	if header.Size < memory {
-		buf = make([]byte, header.Size)
+	if size < memory {
+		buf = make([]byte, size)
 	} else {
 		// Get a fixed-size buffer from the pool to save on allocations.
 		buf = pool.Get().([]byte)
@@ -330,13 +609,38 @@ func (a *Archive) addToArchive(dirfd int, name, relative string, entry ufs.DirEn
 		if os.IsNotExist(err) {
 			return nil
 		}
-		return errors.WrapIff(err, "failed to open '%s' for copying", header.Name)
+		return errors.WrapIff(err, "failed to open '%s' for copying", relative)
 	}
 	defer f.Close()
 
-	// Copy the file's contents to the archive using our buffer.
-	if _, err := io.CopyBuffer(a.w, io.LimitReader(f, header.Size), buf); err != nil {
-		return errors.WrapIff(err, "failed to copy '%s' to archive", header.Name)
+	// Copy the file's contents to the archive using our buffer. The context is checked
+	// between each read so that a large file does not hold up a cancelled archive.
+	var r io.Reader = io.LimitReader(f, size)
+	if a.ctx != nil {
+		r = NewContextReader(a.ctx, r)
+	}
+	if _, err := io.CopyBuffer(w, r, buf); err != nil {
+		return errors.WrapIff(err, "failed to copy '%s' to archive", relative)
 	}
 	return nil
 }
+
+// NewContextReader returns a reader that stops reading from the underlying reader
+// once the context is done, returning the error from the context instead. This
+// allows long running copies to be cancelled between reads.
+func NewContextReader(ctx context.Context, r io.Reader) io.Reader {
+	return &contextReader{ctx: ctx, r: r}
+}
+
+// contextReader is a reader that stops reading once the context is done.
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (c *contextReader) Read(p []byte) (int, error) {
+	if err := c.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return c.r.Read(p)
+}
@@ -1,7 +1,11 @@
 package filesystem
 
 import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
 	"context"
+	"io"
 	iofs "io/fs"
 	"os"
 	"path/filepath"
@@ -9,8 +13,13 @@ import (
 	"strings"
 	"testing"
 
+	"emperror.dev/errors"
 	. "github.com/franela/goblin"
+	"github.com/klauspost/compress/zip"
 	"github.com/mholt/archives"
+	ignore "github.com/sabhiram/go-gitignore"
+
+	"github.com/IvanX77/turbowings/internal/ufs"
 )
 
 func TestArchive_Stream(t *testing.T) {
@@ -84,9 +93,201 @@ func TestArchive_Stream(t *testing.T) {
 
 			g.Assert(files).Equal(expected)
 		})
+
+		g.It("streams a zip archive of a directory without ignored files", func() {
+			fs.denylist = ignore.CompileIgnoreLines("*.secret")
+			defer func() {
+				fs.denylist = ignore.CompileIgnoreLines()
+			}()
+
+			g.Assert(fs.CreateDirectory("nested", "/world")).IsNil()
+			for _, name := range []string{"world/level.dat", "world/nested/region.mca", "world/password.secret"} {
+				r := strings.NewReader("hello, world!\n")
+				g.Assert(fs.Write(name, r, r.Size(), 0o644)).IsNil()
+			}
+
+			a := &Archive{
+				Filesystem:    fs,
+				BaseDirectory: "world",
+				Format:        ArchiveFormatZip,
+				SkipIgnored:   true,
+			}
+
+			var buf bytes.Buffer
+			g.Assert(a.Stream(context.Background(), &buf)).IsNil()
+
+			zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+			g.Assert(err).IsNil()
+
+			var files []string
+			for _, f := range zr.File {
+				files = append(files, f.Name)
+			}
+			sort.Strings(files)
+
+			g.Assert(files).Equal([]string{"level.dat", "nested/region.mca"})
+		})
+
+		g.It("returns the error from finishing a zip archive", func() {
+			a := &Archive{Filesystem: fs, Format: ArchiveFormatZip}
+
+			// Nothing is written to an empty zip archive until it is closed.
+			err := a.Stream(context.Background(), failingWriter{})
+			g.Assert(err).IsNotNil()
+			g.Assert(strings.Contains(err.Error(), "write failed")).IsTrue()
+		})
+
+		g.It("lists the files that would be archived without creating an archive", func() {
+			g.Assert(fs.CreateDirectory("logs", "/")).IsNil()
+			for _, name := range []string{"server.jar", "config.yml", "logs/latest.log"} {
+				r := strings.NewReader("hello, world!\n")
+				g.Assert(fs.Write(name, r, r.Size(), 0o644)).IsNil()
+			}
+
+			a := &Archive{
+				Filesystem: fs,
+				Ignore:     "logs/\n*.jar",
+			}
+
+			entries, err := a.List(context.Background())
+			g.Assert(err).IsNil()
+			g.Assert(entries).Equal([]ArchiveEntry{{Path: "config.yml", Size: 14}})
+		})
+
+		g.It("uses the compression level set on the archive", func() {
+			r := strings.NewReader(strings.Repeat("hello, world!\n", 4096))
+			g.Assert(fs.Write("world.txt", r, r.Size(), 0o644)).IsNil()
+
+			var stored, best bytes.Buffer
+			a := &Archive{Filesystem: fs, CompressionLevel: CompressionLevelNone}
+			g.Assert(a.Stream(context.Background(), &stored)).IsNil()
+			a = &Archive{Filesystem: fs, CompressionLevel: CompressionLevelBestCompression}
+			g.Assert(a.Stream(context.Background(), &best)).IsNil()
+
+			g.Assert(stored.Len() > 4096*14).IsTrue()
+			g.Assert(best.Len() < stored.Len()/10).IsTrue()
+		})
+
+		g.Describe("symlinks", func() {
+			g.BeforeEach(func() {
+				g.Assert(os.WriteFile(filepath.Join(rfs.root, "secret.txt"), []byte("secret\n"), 0o644)).IsNil()
+				g.Assert(fs.CreateDirectory("data", "/")).IsNil()
+				r := strings.NewReader("hello, world!\n")
+				g.Assert(fs.Write("data/inside.txt", r, r.Size(), 0o644)).IsNil()
+
+				links := map[string]string{
+					"data/good":     "inside.txt",
+					"data/escape":   "../../secret.txt",
+					"data/absolute": filepath.Join(rfs.root, "secret.txt"),
+				}
+				for name, target := range links {
+					g.Assert(os.Symlink(target, filepath.Join(rfs.root, "server", name))).IsNil()
+				}
+			})
+
+			g.AfterEach(func() {
+				_ = os.Remove(filepath.Join(rfs.root, "secret.txt"))
+			})
+
+			g.It("skips symlinks", func() {
+				entries := streamTarEntries(g, &Archive{Filesystem: fs, Symlinks: ArchiveSymlinksSkip})
+
+				g.Assert(entries).Equal(map[string]string{"data/inside.txt": "hello, world!\n"})
+			})
+
+			g.It("stores symlinks as links without reading their targets", func() {
+				entries := streamTarEntries(g, &Archive{Filesystem: fs, Symlinks: ArchiveSymlinksStore})
+
+				g.Assert(entries).Equal(map[string]string{
+					"data/inside.txt": "hello, world!\n",
+					"data/good":       "-> inside.txt",
+					"data/escape":     "-> ../../secret.txt",
+					"data/absolute":   "-> " + filepath.Join(rfs.root, "secret.txt"),
+				})
+			})
+
+			g.It("follows symlinks that point to files within the root only", func() {
+				a := &Archive{Filesystem: fs, Symlinks: ArchiveSymlinksFollow}
+				entries := streamTarEntries(g, a)
+
+				g.Assert(entries).Equal(map[string]string{
+					"data/inside.txt": "hello, world!\n",
+					"data/good":       "hello, world!\n",
+				})
+
+				listed, err := a.List(context.Background())
+				g.Assert(err).IsNil()
+				g.Assert(len(listed)).Equal(2)
+			})
+		})
+
+		g.It("leaves out the files that are skipped", func() {
+			for _, name := range []string{"changed.txt", "unchanged.txt"} {
+				r := strings.NewReader("hello, world!\n")
+				g.Assert(fs.Write(name, r, r.Size(), 0o644)).IsNil()
+			}
+
+			var seen []string
+			entries := streamTarEntries(g, &Archive{
+				Filesystem: fs,
+				Skip: func(relative string, info ufs.FileInfo) bool {
+					seen = append(seen, relative)
+					return relative == "unchanged.txt"
+				},
+			})
+			sort.Strings(seen)
+
+			g.Assert(seen).Equal([]string{"changed.txt", "unchanged.txt"})
+			g.Assert(entries).Equal(map[string]string{"changed.txt": "hello, world!\n"})
+		})
+
+		g.It("parses compression level names and aliases", func() {
+			for name, expected := range map[string]string{
+				"":                 "",
+				"store":            CompressionLevelNone,
+				"fast":             CompressionLevelBestSpeed,
+				"BEST":             CompressionLevelBestCompression,
+				"best_compression": CompressionLevelBestCompression,
+			} {
+				level, err := ParseCompressionLevel(name)
+				g.Assert(err).IsNil()
+				g.Assert(level).Equal(expected)
+			}
+
+			_, err := ParseCompressionLevel("ultra")
+			g.Assert(err).IsNotNil()
+		})
 	})
 }
 
+// streamTarEntries streams the archive and returns the contents of every entry
+// within it, symlinks are returned as their target prefixed with "-> ".
+func streamTarEntries(g *G, a *Archive) map[string]string {
+	var buf bytes.Buffer
+	g.Assert(a.Stream(context.Background(), &buf)).IsNil()
+
+	gr, err := gzip.NewReader(&buf)
+	g.Assert(err).IsNil()
+	tr := tar.NewReader(gr)
+
+	entries := make(map[string]string)
+	for {
+		h, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		g.Assert(err).IsNil()
+		if h.Typeflag == tar.TypeSymlink {
+			entries[h.Name] = "-> " + h.Linkname
+			continue
+		}
+		b, err := io.ReadAll(tr)
+		g.Assert(err).IsNil()
+		entries[h.Name] = string(b)
+	}
+	return entries
+}
+
 func getFiles(f iofs.ReadDirFS, name string) ([]string, error) {
 	var v []string
 
@@ -120,3 +321,10 @@ func getFiles(f iofs.ReadDirFS, name string) ([]string, error) {
 
 	return v, nil
 }
+
+// failingWriter is a writer that fails every write.
+type failingWriter struct{}
+
+func (failingWriter) Write([]byte) (int, error) {
+	return 0, errors.New("write failed")
+}
@@ -141,6 +141,51 @@ func (fs *Filesystem) Writefile(p string, r io.Reader) error {
 }
 
 func (fs *Filesystem) Write(p string, r io.Reader, newSize int64, mode ufs.FileMode) error {
+	if err := fs.write(p, r, newSize, mode); err != nil {
+		return err
+	}
+	return fs.chownFile(p)
+}
+
+// WriteOwned writes the file in the same way as Write, but gives the file, and
+// any parent directories that had to be created for it, to the given uid and gid
+// rather than the configured user. The created directories are given to the owner
+// even if the write fails, since they already exist if the write is retried.
+func (fs *Filesystem) WriteOwned(p string, r io.Reader, newSize int64, mode ufs.FileMode, uid, gid int) error {
+	// Find the directories that do not exist yet before writing the file, since
+	// they are created along with it.
+	var created []string
+	for d := filepath.Dir(filepath.Clean(p)); d != "." && d != "/"; d = filepath.Dir(d) {
+		if _, err := fs.unixFS.Lstat(d); err == nil {
+			break
+		} else if !errors.Is(err, ufs.ErrNotExist) {
+			return errors.Wrap(err, "server/filesystem: writefile: failed to stat directory")
+		}
+		created = append(created, d)
+	}
+
+	werr := fs.write(p, r, newSize, mode)
+	if fs.isTest {
+		return werr
+	}
+	for _, d := range created {
+		if err := fs.unixFS.Lchown(d, uid, gid); err != nil {
+			if werr != nil {
+				return werr
+			}
+			return errors.Wrap(err, "server/filesystem: writefile: failed to chown directory")
+		}
+	}
+	if werr != nil {
+		return werr
+	}
+	return fs.unixFS.Lchown(p, uid, gid)
+}
+
+// write writes the contents of the reader to the file, creating it and any of
+// its parent directories if they do not exist. The owner of the file is left as
+// it is.
+func (fs *Filesystem) write(p string, r io.Reader, newSize int64, mode ufs.FileMode) error {
 	var currentSize int64
 	st, err := fs.unixFS.Stat(p)
 	if err != nil && !errors.Is(err, ufs.ErrNotExist) {
@@ -182,9 +227,6 @@ func (fs *Filesystem) Write(p string, r io.Reader, newSize int64, mode ufs.FileM
 		fs.unixFS.Add(n - currentSize)
 	}
 
-	if err := fs.chownFile(p); err != nil {
-		return err
-	}
 	// Return any remaining error.
 	return err
 }
@@ -0,0 +1,144 @@
+package filesystem
+
+import (
+	"context"
+	"io"
+	"syscall"
+	"time"
+
+	"emperror.dev/errors"
+	"golang.org/x/sys/unix"
+
+	"github.com/IvanX77/turbowings/internal/ufs"
+)
+
+// followInterval is how often a followed file is checked for new data once all
+// the data currently in it has been written.
+var followInterval = 500 * time.Millisecond
+
+// followBufferSize is the size of the buffer used to copy a followed file, this
+// is the most memory that following a file will use regardless of its size.
+const followBufferSize = 32 * 1024
+
+// Follow writes the contents of the file to the writer and then continues to
+// write any data appended to it, in the same way as "tail -f", until the context
+// is canceled. The flush function is called whenever all the data currently in
+// the file has been written.
+//
+// If the file is truncated it is followed from the start again. If the file is
+// replaced, such as when a log file is rotated, the rest of the original file is
+// written before switching over to the new one. The file is checked against the
+// denylist every time it is opened.
+func (fs *Filesystem) Follow(ctx context.Context, p string, w io.Writer, flush func()) error {
+	f, err := fs.openFollowed(p)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+
+	buf := make([]byte, followBufferSize)
+	t := time.NewTicker(followInterval)
+	defer t.Stop()
+
+	var offset int64
+	for {
+		n, err := copyFollowed(w, f, buf)
+		offset += n
+		if err != nil {
+			return err
+		}
+		flush()
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-t.C:
+		}
+
+		st, err := f.Stat()
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		if st.Size() < offset {
+			if _, err := f.Seek(0, io.SeekStart); err != nil {
+				return errors.WithStack(err)
+			}
+			offset = 0
+			continue
+		}
+		if st.Size() > offset {
+			continue
+		}
+
+		// Everything in the open file has been written, so check if the path now
+		// refers to a different file. If nothing exists at the path yet, such as
+		// part way through a rotation, keep waiting on the original file.
+		current, err := fs.unixFS.Stat(p)
+		if err != nil || sameFile(st, current) {
+			continue
+		}
+		nf, err := fs.openFollowed(p)
+		if err != nil {
+			continue
+		}
+		_ = f.Close()
+		f, offset = nf, 0
+	}
+}
+
+// openFollowed opens the file at the given path to be followed.
+func (fs *Filesystem) openFollowed(p string) (ufs.File, error) {
+	if err := fs.IsIgnored(p); err != nil {
+		return nil, err
+	}
+	f, _, err := fs.File(p)
+	if err != nil {
+		if f != nil {
+			_ = f.Close()
+		}
+		return nil, err
+	}
+	return f, nil
+}
+
+// copyFollowed copies everything that can currently be read from the file to the
+// writer using the given buffer.
+func copyFollowed(w io.Writer, r io.Reader, buf []byte) (int64, error) {
+	var written int64
+	for {
+		n, err := r.Read(buf)
+		if n > 0 {
+			if _, werr := w.Write(buf[:n]); werr != nil {
+				return written, werr
+			}
+			written += int64(n)
+		}
+		if err == io.EOF {
+			return written, nil
+		}
+		if err != nil {
+			return written, errors.WithStack(err)
+		}
+	}
+}
+
+// sameFile reports whether both of the file infos describe the same file on the
+// disk.
+func sameFile(a, b ufs.FileInfo) bool {
+	ad, ai, aok := fileID(a)
+	bd, bi, bok := fileID(b)
+	return aok && bok && ad == bd && ai == bi
+}
+
+// fileID returns the device and inode of the file.
+func fileID(st ufs.FileInfo) (uint64, uint64, bool) {
+	switch s := st.Sys().(type) {
+	case *unix.Stat_t:
+		return uint64(s.Dev), uint64(s.Ino), true
+	case *syscall.Stat_t:
+		return uint64(s.Dev), uint64(s.Ino), true
+	}
+	return 0, 0, false
+}
@@ -0,0 +1,112 @@
+package filesystem
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	. "github.com/franela/goblin"
+)
+
+// syncBuffer is a buffer that can be written to while it is being read.
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func TestFilesystem_Follow(t *testing.T) {
+	g := Goblin(t)
+	fs, rfs := NewFs()
+	followInterval = 5 * time.Millisecond
+
+	g.Describe("Follow", func() {
+		var (
+			out    *syncBuffer
+			cancel context.CancelFunc
+			done   chan error
+		)
+
+		follow := func(p string) {
+			var ctx context.Context
+			ctx, cancel = context.WithCancel(context.Background())
+			out = &syncBuffer{}
+			done = make(chan error, 1)
+			go func() {
+				done <- fs.Follow(ctx, p, out, func() {})
+			}()
+		}
+
+		wait := func(expected string) {
+			deadline := time.Now().Add(2 * time.Second)
+			for out.String() != expected && time.Now().Before(deadline) {
+				time.Sleep(time.Millisecond)
+			}
+			g.Assert(out.String()).Equal(expected)
+		}
+
+		path := func(p string) string {
+			return filepath.Join(rfs.root, "server", p)
+		}
+
+		appendTo := func(p string, s string) {
+			f, err := os.OpenFile(path(p), os.O_APPEND|os.O_WRONLY, 0o644)
+			g.Assert(err).IsNil()
+			_, err = f.WriteString(s)
+			g.Assert(err).IsNil()
+			g.Assert(f.Close()).IsNil()
+		}
+
+		g.AfterEach(func() {
+			cancel()
+			g.Assert(<-done).IsNil()
+			_ = fs.TruncateRootDirectory()
+		})
+
+		g.It("writes data appended to the file", func() {
+			g.Assert(rfs.CreateServerFileFromString("latest.log", "first\n")).IsNil()
+			follow("latest.log")
+			wait("first\n")
+
+			appendTo("latest.log", "second\n")
+			wait("first\nsecond\n")
+		})
+
+		g.It("follows the file from the start after it is truncated", func() {
+			g.Assert(rfs.CreateServerFileFromString("latest.log", "first line\n")).IsNil()
+			follow("latest.log")
+			wait("first line\n")
+
+			g.Assert(os.Truncate(path("latest.log"), 0)).IsNil()
+			time.Sleep(20 * time.Millisecond)
+			appendTo("latest.log", "new\n")
+			wait("first line\nnew\n")
+		})
+
+		g.It("follows the new file after the file is rotated", func() {
+			g.Assert(rfs.CreateServerFileFromString("latest.log", "old\n")).IsNil()
+			follow("latest.log")
+			wait("old\n")
+
+			appendTo("latest.log", "end\n")
+			g.Assert(os.Rename(path("latest.log"), path("latest.log.1"))).IsNil()
+			g.Assert(rfs.CreateServerFileFromString("latest.log", "new\n")).IsNil()
+			wait("old\nend\nnew\n")
+		})
+	})
+}
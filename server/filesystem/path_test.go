@@ -80,6 +80,12 @@ func TestFilesystem_Blocks_Symlinks(t *testing.T) {
 			g.Assert(errors.Is(err, ufs.ErrBadPathResolution)).IsTrue("err is not ErrBadPathResolution")
 		})
 
+		g.It("cannot write a range to a file symlinked outside the root", func() {
+			_, err := fs.WriteRange("symlinked.txt", bytes.NewReader([]byte("testing")), 0, 7, 7)
+			g.Assert(err).IsNotNil()
+			g.Assert(errors.Is(err, ufs.ErrBadPathResolution)).IsTrue("err is not ErrBadPathResolution")
+		})
+
 		g.It("cannot write a file to a directory symlinked outside the root", func() {
 			r := bytes.NewReader([]byte("testing"))
 
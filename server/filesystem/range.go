@@ -0,0 +1,72 @@
+package filesystem
+
+import (
+	"io"
+
+	"emperror.dev/errors"
+
+	"github.com/IvanX77/turbowings/internal/ufs"
+)
+
+// ErrInvalidRange is returned when writing a range of a file that starts past the
+// end of the file.
+const ErrInvalidRange = errors.Sentinel("filesystem: range starts past the end of the file")
+
+// WriteRange writes the contents of the reader to the file starting at the given
+// offset, allowing a large file to be uploaded in parts and resumed if the upload
+// is interrupted. The file is created if it does not exist. A range cannot start
+// past the end of the file, so no gaps are ever left in it.
+//
+// If total is not negative it is the final size of the file, once the range that
+// ends at that size is written any data after it is removed. The size of the file
+// after the range has been written is returned, even if an error is returned.
+func (fs *Filesystem) WriteRange(p string, r io.Reader, offset, length, total int64) (int64, error) {
+	var currentSize int64
+	st, err := fs.unixFS.Stat(p)
+	if err != nil && !errors.Is(err, ufs.ErrNotExist) {
+		return 0, errors.Wrap(err, "server/filesystem: writerange: failed to stat file")
+	} else if err == nil {
+		if st.IsDir() {
+			return 0, errors.WithStack(&Error{code: ErrCodeIsDirectory, resolved: p})
+		}
+		currentSize = st.Size()
+	}
+	if offset > currentSize {
+		return currentSize, ErrInvalidRange
+	}
+
+	newSize := max(currentSize, offset+length)
+	if total >= 0 && offset+length == total {
+		newSize = total
+	}
+	if err := fs.HasSpaceFor(newSize - currentSize); err != nil {
+		return currentSize, err
+	}
+
+	file, err := fs.unixFS.Touch(p, ufs.O_RDWR, 0o644)
+	if err != nil {
+		return currentSize, err
+	}
+	defer file.Close()
+
+	if _, err := file.Seek(offset, io.SeekStart); err != nil {
+		return currentSize, errors.WithStack(err)
+	}
+	n, err := io.Copy(file, io.LimitReader(r, length))
+	if err == nil && n < length {
+		err = io.ErrUnexpectedEOF
+	}
+
+	size := max(currentSize, offset+n)
+	if err == nil && total >= 0 && offset+n == total && size > total {
+		if err = file.Truncate(total); err == nil {
+			size = total
+		}
+	}
+	fs.unixFS.Add(size - currentSize)
+
+	if cerr := fs.chownFile(p); cerr != nil && err == nil {
+		err = cerr
+	}
+	return size, errors.WithStackIf(err)
+}
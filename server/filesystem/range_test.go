@@ -0,0 +1,63 @@
+package filesystem
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	. "github.com/franela/goblin"
+)
+
+func TestFilesystem_WriteRange(t *testing.T) {
+	g := Goblin(t)
+	fs, rfs := NewFs()
+
+	g.Describe("WriteRange", func() {
+		read := func(p string) string {
+			b, err := os.ReadFile(filepath.Join(rfs.root, "server", p))
+			g.Assert(err).IsNil()
+			return string(b)
+		}
+
+		g.AfterEach(func() {
+			_ = fs.TruncateRootDirectory()
+		})
+
+		g.It("writes a file that is uploaded in parts", func() {
+			size, err := fs.WriteRange("world.dat", strings.NewReader("hello "), 0, 6, 11)
+			g.Assert(err).IsNil()
+			g.Assert(size).Equal(int64(6))
+
+			size, err = fs.WriteRange("world.dat", strings.NewReader("world"), 6, 5, 11)
+			g.Assert(err).IsNil()
+			g.Assert(size).Equal(int64(11))
+			g.Assert(read("world.dat")).Equal("hello world")
+			g.Assert(fs.CachedUsage()).Equal(int64(11))
+		})
+
+		g.It("removes the rest of an existing file once the final part is written", func() {
+			g.Assert(rfs.CreateServerFileFromString("world.dat", "a much longer file")).IsNil()
+
+			size, err := fs.WriteRange("world.dat", strings.NewReader("short"), 0, 5, 5)
+			g.Assert(err).IsNil()
+			g.Assert(size).Equal(int64(5))
+			g.Assert(read("world.dat")).Equal("short")
+		})
+
+		g.It("does not allow a range to start past the end of the file", func() {
+			g.Assert(rfs.CreateServerFileFromString("world.dat", "hello")).IsNil()
+
+			size, err := fs.WriteRange("world.dat", strings.NewReader("world"), 6, 5, -1)
+			g.Assert(errors.Is(err, ErrInvalidRange)).IsTrue()
+			g.Assert(size).Equal(int64(5))
+		})
+
+		g.It("returns an error if the body is shorter than the range", func() {
+			size, err := fs.WriteRange("world.dat", strings.NewReader("hel"), 0, 5, -1)
+			g.Assert(err == nil).IsFalse()
+			g.Assert(size).Equal(int64(3))
+		})
+	})
+}
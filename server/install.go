@@ -424,13 +424,19 @@ func (ip *InstallationProcess) Execute() (string, error) {
 		Cmd:          []string{ip.Script.Entrypoint, "/mnt/install/install.sh"},
 		Image:        ip.Script.ContainerImage,
 		Env:          ip.Server.GetEnvironmentVariables(),
-		Labels: map[string]string{
-			"Service":       "LionPanel",
-			"ContainerType": "server_installer",
-		},
 	}
 
 	cfg := config.Get()
+	// Apply the node's base labels and the labels identifying the server to the installer
+	// container as well, so that it can be discovered by the same tooling.
+	conf.Labels = make(map[string]string, len(cfg.Docker.Labels)+4)
+	for k, v := range cfg.Docker.Labels {
+		conf.Labels[k] = v
+	}
+	conf.Labels["turbowings.server.uuid"] = ip.Server.ID()
+	conf.Labels["turbowings.server.name"] = ip.Server.Config().Meta.Name
+	conf.Labels["Service"] = "LionPanel"
+	conf.Labels["ContainerType"] = "server_installer"
 	tmpfsSize := strconv.Itoa(int(cfg.Docker.TmpfsSize))
 	hostConf := &container.HostConfig{
 		Mounts: []mount.Mount{
@@ -2,6 +2,7 @@ package server
 
 import (
 	"bytes"
+	"fmt"
 	"regexp"
 	"strconv"
 	"sync"
@@ -10,6 +11,7 @@ import (
 	"github.com/apex/log"
 
 	"github.com/IvanX77/turbowings/events"
+	"github.com/IvanX77/turbowings/internal/models"
 	"github.com/IvanX77/turbowings/system"
 
 	"github.com/IvanX77/turbowings/environment"
@@ -129,9 +131,15 @@ func (s *Server) StartEventListeners() {
 					case environment.DockerImagePullStatus:
 						s.Events().Publish(InstallOutputEvent, e.Data)
 					case environment.DockerImagePullStarted:
-						s.PublishConsoleOutputFromDaemon("Pulling Docker container image, this could take a few minutes to complete...")
+						if msg, ok := e.Data.(string); ok && msg != "" {
+							s.PublishConsoleOutputFromDaemon(msg)
+						} else {
+							s.PublishConsoleOutputFromDaemon("Pulling Docker container image, this could take a few minutes to complete...")
+						}
 					case environment.DockerImagePullCompleted:
 						s.PublishConsoleOutputFromDaemon("Finished pulling Docker container image")
+					case environment.OomEvent:
+						s.onOomEvent()
 					default:
 					}
 				}(v, limit)
@@ -142,6 +150,18 @@ func (s *Server) StartEventListeners() {
 	}()
 }
 
+// onOomEvent notifies anyone watching the server that a process within it was
+// killed for exceeding the memory limit, and records it in the activity log so
+// that it can be surfaced by the Panel after the fact.
+func (s *Server) onOomEvent() {
+	limit := s.Config().Build.MemoryLimit
+	s.PublishConsoleOutputFromDaemon(fmt.Sprintf("Server process was killed for exceeding the assigned memory limit of %d MiB.", limit))
+	s.Events().Publish(OomEvent, "")
+	s.SaveActivity(s.NewRequestActivity("", "127.0.0.1"), ActivityServerOomKilled, models.ActivityMeta{
+		"memory_limit": limit,
+	})
+}
+
 var stripAnsiRegex = regexp.MustCompile("[\u001B\u009B][[\\]()#;?]*(?:(?:(?:[a-zA-Z\\d]*(?:;[a-zA-Z\\d]*)*)?\u0007)|(?:(?:\\d{1,4}(?:;\\d{0,4})*)?[\\dA-PRZcf-ntqry=><~]))")
 
 // Custom listener for console output events that will check if the given line
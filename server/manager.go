@@ -140,6 +140,63 @@ func (m *Manager) Remove(filter func(match *Server) bool) {
 	m.servers = r
 }
 
+// ValidateConfigurationFiles performs a dry run of the configuration file parsers
+// for every server in the collection without making any changes to the files.
+// The servers that have configuration files which could not be parsed are
+// returned, keyed by their UUID. This is read-only and safe to run while the
+// servers are running.
+func (m *Manager) ValidateConfigurationFiles() map[string][]ConfigurationFileError {
+	var mu sync.Mutex
+	out := make(map[string][]ConfigurationFileError)
+
+	pool := workerpool.New(runtime.NumCPU())
+	for _, s := range m.All() {
+		s := s
+		pool.Submit(func() {
+			if errs := s.ValidateConfigurationFiles(); len(errs) > 0 {
+				mu.Lock()
+				out[s.ID()] = errs
+				mu.Unlock()
+			}
+		})
+	}
+	pool.StopWait()
+
+	return out
+}
+
+// ReloadConfigurationFiles updates the configuration files for every running
+// server, re-applying the cached replacements that were provided by the Panel
+// when the server was last synced. The configuration is not fetched from the
+// Panel again, so changes made on the Panel are only applied once the server has
+// been synced again. This allows changes to be pushed out without having to
+// restart the servers. Offline servers are skipped since their files are updated
+// when they are next started. The errors for any servers that had files which
+// could not be updated are returned, keyed by their UUID.
+func (m *Manager) ReloadConfigurationFiles() map[string]error {
+	var mu sync.Mutex
+	out := make(map[string]error)
+
+	pool := workerpool.New(runtime.NumCPU())
+	for _, s := range m.All() {
+		s := s
+		if s.Environment.State() == environment.ProcessOfflineState {
+			continue
+		}
+		pool.Submit(func() {
+			s.Log().Info("reloading server configuration files")
+			if err := s.updateConfigurationFiles(); err != nil {
+				mu.Lock()
+				out[s.ID()] = err
+				mu.Unlock()
+			}
+		})
+	}
+	pool.StopWait()
+
+	return out
+}
+
 // PersistStates writes the current environment states to the disk for each
 // server. This is generally called at a specific interval defined in the root
 // runner command to avoid hammering disk I/O when tons of server switch states
@@ -208,7 +265,9 @@ func (m *Manager) InitServer(data remote.ServerConfigurationResponse) (*Server,
 		Mounts:      s.Mounts(),
 		Allocations: s.cfg.Allocations,
 		Limits:      s.cfg.Build,
-		Labels:      s.cfg.Labels,
+		Labels:      s.ContainerLabels(),
+		Entrypoint:  s.cfg.Container.Entrypoint,
+		Command:     s.cfg.Container.Command,
 	}
 
 	envCfg := environment.NewConfiguration(settings, s.GetEnvironmentVariables())
@@ -5,6 +5,7 @@ import (
 	"path/filepath"
 	"strings"
 
+	"emperror.dev/errors"
 	"github.com/apex/log"
 
 	"github.com/IvanX77/turbowings/config"
@@ -49,22 +50,39 @@ func (s *Server) Mounts() []environment.Mount {
 func (s *Server) customMounts() []environment.Mount {
 	var mounts []environment.Mount
 
+	env := s.Config().EnvVars
 	for _, m := range s.Config().Mounts {
-		source := filepath.Clean(m.Source)
 		target := filepath.Clean(m.Target)
 
 		logger := s.Log().WithFields(log.Fields{
-			"source_path": source,
+			"source_path": m.Source,
 			"target_path": target,
 			"read_only":   m.ReadOnly,
 		})
 
+		// Resolve any variables in the source before it is checked, so that all the
+		// checks below are performed against the path that would actually be mounted.
+		source, err := resolveMountSource(m.Source, env)
+		if err != nil {
+			logger.WithField("error", err).Warn("skipping custom server mount, failed to resolve source path")
+			continue
+		}
+		source = filepath.Clean(source)
+		logger = logger.WithField("source_path", source)
+
 		// Check if the source path exists
 		if _, err := os.Stat(source); os.IsNotExist(err) {
 			logger.WithField("missing_source_path", source).Warn("skipping custom server mount, source path does not exist")
 			continue
 		}
 
+		// Never allow sensitive host paths to be mounted into the container, even if an
+		// allowed mount would otherwise permit it.
+		if err := validateMountSource(source); err != nil {
+			logger.WithField("error", err).Warn("skipping custom server mount, source path is not allowed")
+			continue
+		}
+
 		// Check if the target path includes /home/container
 		if strings.HasPrefix(target, "/home/container") && config.Get().BlockBaseDirMount {
 			logger.WithField("invalid_target_path", target).Warn("Skipping custom server mount; target path includes /home/container")
@@ -81,9 +99,10 @@ func (s *Server) customMounts() []environment.Mount {
 
 			mounted = true
 			mounts = append(mounts, environment.Mount{
-				Source:   source,
-				Target:   target,
-				ReadOnly: m.ReadOnly,
+				Source:      source,
+				Target:      target,
+				ReadOnly:    m.ReadOnly,
+				Propagation: m.Propagation,
 			})
 
 			break
@@ -96,3 +115,100 @@ func (s *Server) customMounts() []environment.Mount {
 
 	return mounts
 }
+
+// resolveMountSource replaces any ${VARIABLE} references in the source path of
+// a mount with the value of that environment variable for the server. An error
+// is returned if a referenced variable is not set, rather than silently mounting
+// a different path than was intended.
+func resolveMountSource(source string, env environment.Variables) (string, error) {
+	if !strings.Contains(source, "$") {
+		return source, nil
+	}
+
+	var missing []string
+	resolved := os.Expand(source, func(key string) string {
+		v := env.Get(key)
+		if v == "" {
+			missing = append(missing, key)
+		}
+		return v
+	})
+	if len(missing) > 0 {
+		return "", errors.Errorf("server/mounts: mount source references unset variables: %s", strings.Join(missing, ", "))
+	}
+
+	return resolved, nil
+}
+
+// validateMountSource checks that the resolved source path for a mount is not a
+// sensitive location on the host system. Symlinks are resolved first so that a
+// link cannot be used to get around the denied paths.
+func validateMountSource(source string) error {
+	resolved, err := filepath.EvalSymlinks(source)
+	if err != nil {
+		return errors.Wrap(err, "server/mounts: failed to resolve mount source")
+	}
+	resolved = filepath.Clean(resolved)
+
+	if d, ok := deniedHostPath(resolved); ok {
+		switch {
+		case resolved == d:
+			return errors.Errorf("server/mounts: mounting \"%s\" is not permitted", resolved)
+		case strings.HasPrefix(resolved, d+"/"):
+			return errors.Errorf("server/mounts: mounting \"%s\" is not permitted as it is within \"%s\"", resolved, d)
+		default:
+			return errors.Errorf("server/mounts: mounting \"%s\" is not permitted as it contains \"%s\"", resolved, d)
+		}
+	}
+
+	return nil
+}
+
+// deniedHostPath returns the sensitive host path that the given path is, is nested
+// within, or contains, if any. Mounting a parent of a sensitive path exposes that
+// path just as much as mounting it directly. The path should already be cleaned and
+// have had any symlinks resolved.
+func deniedHostPath(p string) (string, bool) {
+	for _, d := range deniedHostPaths() {
+		// Only the root path itself is denied, otherwise every path would be matched.
+		if p == d || (d != "/" && strings.HasPrefix(p, d+"/")) {
+			return d, true
+		}
+		if p == "/" || strings.HasPrefix(d, p+"/") {
+			return d, true
+		}
+	}
+	return "", false
+}
+
+// deniedHostPaths returns the configured denied mounts along with the directories
+// used by TurboWings itself, which would otherwise allow a server to read the node
+// configuration or the data and backups of every other server.
+func deniedHostPaths() []string {
+	cfg := config.Get()
+	paths := make([]string, 0, len(cfg.DeniedMounts)+4)
+	for _, d := range cfg.DeniedMounts {
+		paths = append(paths, filepath.Clean(d))
+	}
+
+	var internal []string
+	if cfg.Path() != "" {
+		internal = append(internal, filepath.Dir(cfg.Path()))
+	}
+	internal = append(internal, cfg.System.RootDirectory, cfg.System.Data, cfg.System.BackupDirectory)
+	for _, d := range internal {
+		if d == "" {
+			continue
+		}
+		if abs, err := filepath.Abs(d); err == nil {
+			d = abs
+		}
+		// Compare against the real location of the directory, since the source of a
+		// mount has its symlinks resolved before it is checked.
+		if resolved, err := filepath.EvalSymlinks(d); err == nil {
+			d = resolved
+		}
+		paths = append(paths, filepath.Clean(d))
+	}
+	return paths
+}
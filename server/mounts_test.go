@@ -0,0 +1,99 @@
+package server
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	. "github.com/franela/goblin"
+
+	"github.com/IvanX77/turbowings/config"
+	"github.com/IvanX77/turbowings/environment"
+)
+
+func TestServer_customMounts(t *testing.T) {
+	g := Goblin(t)
+
+	g.Describe("Server#customMounts", func() {
+		var root string
+
+		g.BeforeEach(func() {
+			root = t.TempDir()
+			config.Set(&config.Configuration{
+				AuthenticationToken: "abc",
+				AllowedMounts:       []string{root},
+				DeniedMounts:        []string{filepath.Join(root, "secrets")},
+			})
+		})
+
+		g.It("resolves environment variables in the mount source", func() {
+			g.Assert(os.MkdirAll(filepath.Join(root, "maps", "survival"), 0o755)).IsNil()
+
+			s := &Server{}
+			s.cfg.EnvVars = environment.Variables{"GAME_TYPE": "survival"}
+			s.cfg.Mounts = []Mount{{Source: root + "/maps/${GAME_TYPE}", Target: "/maps"}}
+
+			mounts := s.customMounts()
+			g.Assert(len(mounts)).Equal(1)
+			g.Assert(mounts[0].Source).Equal(filepath.Join(root, "maps", "survival"))
+		})
+
+		g.It("skips mounts that reference unset variables", func() {
+			g.Assert(os.MkdirAll(filepath.Join(root, "maps"), 0o755)).IsNil()
+
+			s := &Server{}
+			s.cfg.Mounts = []Mount{{Source: root + "/maps/${GAME_TYPE}", Target: "/maps"}}
+
+			g.Assert(len(s.customMounts())).Equal(0)
+		})
+
+		g.It("skips mounts that resolve to a denied path", func() {
+			g.Assert(os.MkdirAll(filepath.Join(root, "secrets"), 0o755)).IsNil()
+
+			s := &Server{}
+			s.cfg.EnvVars = environment.Variables{"DIR": "maps/../secrets"}
+			s.cfg.Mounts = []Mount{{Source: root + "/${DIR}", Target: "/maps"}}
+
+			g.Assert(len(s.customMounts())).Equal(0)
+		})
+	})
+}
+
+func TestServer_validateMountSource(t *testing.T) {
+	g := Goblin(t)
+
+	g.Describe("validateMountSource", func() {
+		var root string
+
+		g.BeforeEach(func() {
+			root = t.TempDir()
+			c := &config.Configuration{
+				AuthenticationToken: "abc",
+				DeniedMounts:        []string{"/", "/var/run/docker.sock", "/run/docker.sock", "/var/lib/docker"},
+			}
+			c.System.Data = filepath.Join(root, "turbowings", "volumes")
+			c.System.BackupDirectory = filepath.Join(root, "turbowings", "backups")
+			config.Set(c)
+			g.Assert(os.MkdirAll(c.System.Data, 0o755)).IsNil()
+			g.Assert(os.MkdirAll(filepath.Join(root, "shared"), 0o755)).IsNil()
+		})
+
+		g.It("rejects directories that contain a denied path", func() {
+			err := validateMountSource("/var/run")
+			g.Assert(err).IsNotNil()
+			g.Assert(strings.Contains(err.Error(), "docker.sock")).IsTrue(err.Error())
+			g.Assert(validateMountSource("/") == nil).IsFalse()
+		})
+
+		g.It("rejects the directories used by TurboWings", func() {
+			g.Assert(validateMountSource(filepath.Join(root, "turbowings", "volumes")) == nil).IsFalse()
+			g.Assert(validateMountSource(filepath.Join(root, "turbowings")) == nil).IsFalse()
+			g.Assert(validateMountSource(root) == nil).IsFalse()
+		})
+
+		g.It("allows other directories", func() {
+			g.Assert(validateMountSource(filepath.Join(root, "shared"))).IsNil()
+		})
+	})
+}
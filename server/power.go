@@ -184,6 +184,12 @@ func (s *Server) onBeforeStart() error {
 	// and process resource limits are correctly applied.
 	s.SyncWithEnvironment()
 
+	// Refuse to start the server if any of the environment variables could not be
+	// passed through to it, when configured to do so.
+	if err := s.Config().EnvVars.Validate(); err != nil {
+		return err
+	}
+
 	// If a server has unlimited disk space, we don't care enough to block the startup to check remaining.
 	// However, we should trigger a size anyway, as it'd be good to kick it off for other processes.
 	if s.DiskSpace() <= 0 {
@@ -66,6 +66,11 @@ type Server struct {
 	transferring *system.AtomicBool
 	restoring    *system.AtomicBool
 
+	// Tracks the backups currently being generated for this server, keyed by the
+	// backup identifier, so that an in-progress backup can be cancelled.
+	backups   map[string]context.CancelFunc
+	backupsMu sync.Mutex
+
 	// The console throttler instance used to control outputs.
 	throttler    *ConsoleThrottle
 	throttleOnce sync.Once
@@ -123,6 +128,20 @@ func (s *Server) ID() string {
 	return s.Config().GetUuid()
 }
 
+// ContainerLabels returns the labels that should be applied to the container for
+// the server. These are the labels provided by the Panel along with labels that
+// identify the server, allowing external tooling to discover it.
+func (s *Server) ContainerLabels() map[string]string {
+	cfg := s.Config()
+	labels := make(map[string]string, len(cfg.Labels)+2)
+	for k, v := range cfg.Labels {
+		labels[k] = v
+	}
+	labels["turbowings.server.uuid"] = cfg.Uuid
+	labels["turbowings.server.name"] = cfg.Meta.Name
+	return labels
+}
+
 // Id returns the UUID for the server instance. This function is deprecated
 // in favor of Server.ID().
 //
@@ -147,6 +166,12 @@ func (s *Server) Context() context.Context {
 	return s.ctx
 }
 
+// Timezone returns the effective timezone for the server, which is the timezone
+// set for the server by its SERVER_TIMEZONE variable, or the system timezone.
+func (s *Server) Timezone() string {
+	return DetermineServerTimezone(s.Config().EnvVars, config.Get().System.Timezone)
+}
+
 // DetermineServerTimezone checks the envvars for a non-empty SERVER_TIMEZONE key,
 // validates if it's a valid timezone, and returns it. If not, returns the defaultTimezone.
 func DetermineServerTimezone(envvars map[string]interface{}, defaultTimezone string) string {
@@ -210,16 +235,17 @@ func parseInvocation(invocation string, envvars map[string]interface{}, memory i
 // Returns all of the environment variables that should be assigned to a running
 // server instance.
 func (s *Server) GetEnvironmentVariables() []string {
+	vars := s.Config().processEnvVars()
 	out := []string{
-		fmt.Sprintf("TZ=%s", DetermineServerTimezone(s.Config().EnvVars, config.Get().System.Timezone)),
-		fmt.Sprintf("STARTUP=%s", parseInvocation(s.Config().Invocation, s.Config().EnvVars, s.MemoryLimit(), s.Config().Allocations.DefaultMapping.Port, s.Config().Allocations.DefaultMapping.Ip)),
+		fmt.Sprintf("TZ=%s", s.Timezone()),
+		fmt.Sprintf("STARTUP=%s", parseInvocation(s.Config().Invocation, vars, s.MemoryLimit(), s.Config().Allocations.DefaultMapping.Port, s.Config().Allocations.DefaultMapping.Ip)),
 		fmt.Sprintf("SERVER_MEMORY=%d", s.MemoryLimit()),
 		fmt.Sprintf("SERVER_IP=%s", s.Config().Allocations.DefaultMapping.Ip),
 		fmt.Sprintf("SERVER_PORT=%d", s.Config().Allocations.DefaultMapping.Port),
 	}
 
 eloop:
-	for k := range s.Config().EnvVars {
+	for k := range vars {
 		// Don't allow any environment variables that we have already set above.
 		for _, e := range out {
 			if strings.HasPrefix(e, strings.ToUpper(k)+"=") {
@@ -227,7 +253,7 @@ eloop:
 			}
 		}
 
-		out = append(out, fmt.Sprintf("%s=%s", strings.ToUpper(k), s.Config().EnvVars.Get(k)))
+		out = append(out, fmt.Sprintf("%s=%s", strings.ToUpper(k), vars.Get(k)))
 	}
 
 	return out
@@ -279,6 +305,14 @@ func (s *Server) SyncWithConfiguration(cfg remote.ServerConfigurationResponse) e
 		return errors.WithStackIf(err)
 	}
 
+	// Load the variables from the environment file for the server, these are never
+	// sent by the Panel so they need to be loaded every time it is synced.
+	if vars, err := loadEnvironmentFile(c.Uuid); err != nil {
+		s.Log().WithField("error", err).Warn("failed to load environment file for server, variables from it will not be set")
+	} else {
+		c.fileEnvVars = vars
+	}
+
 	s.cfg.mu.Lock()
 	defer s.cfg.mu.Unlock()
 
@@ -421,16 +455,25 @@ type APIResponse struct {
 	IsSuspended   bool          `json:"is_suspended"`
 	Utilization   ResourceUsage `json:"utilization"`
 	Configuration Configuration `json:"configuration"`
+
+	// EffectiveLimits are the computed resource limits sent to Docker for this
+	// server's container.
+	EffectiveLimits environment.EffectiveLimits `json:"effective_limits"`
+
+	// Timezone is the effective timezone of the server, see Server.Timezone.
+	Timezone string `json:"timezone"`
 }
 
 // ToAPIResponse returns the server struct as an API object that can be consumed
 // by callers.
 func (s *Server) ToAPIResponse() APIResponse {
 	return APIResponse{
-		State:         s.Environment.State(),
-		IsSuspended:   s.IsSuspended(),
-		Utilization:   s.Proc(),
-		Configuration: *s.Config(),
+		State:           s.Environment.State(),
+		IsSuspended:     s.IsSuspended(),
+		Utilization:     s.Proc(),
+		Configuration:   *s.Config(),
+		EffectiveLimits: s.Config().Build.Effective(),
+		Timezone:        s.Timezone(),
 	}
 }
 
@@ -28,7 +28,9 @@ func (s *Server) SyncWithEnvironment() {
 		Mounts:      s.Mounts(),
 		Allocations: cfg.Allocations,
 		Limits:      cfg.Build,
-		Labels:      cfg.Labels,
+		Labels:      s.ContainerLabels(),
+		Entrypoint:  cfg.Container.Entrypoint,
+		Command:     cfg.Container.Command,
 	})
 
 	// For Docker specific environments we also want to update the configured image